@@ -3,11 +3,15 @@ module github.com/opwire/opwire-agent
 go 1.12
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/golang/mock v1.2.0
 	github.com/gorilla/mux v1.7.0
 	github.com/imdario/mergo v0.3.7
 	github.com/jeremywohl/flatten v0.0.0-20180923035001-588fe0d4c603
 	github.com/mattn/go-shellwords v1.0.5
+	github.com/nats-io/nats-server/v2 v2.2.0
+	github.com/nats-io/nats.go v1.11.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/spf13/afero v1.2.2
 	github.com/stretchr/testify v1.3.0
 	github.com/urfave/cli v1.20.0
@@ -17,6 +21,7 @@ require (
 	go.uber.org/atomic v1.3.2 // indirect
 	go.uber.org/multierr v1.1.0 // indirect
 	go.uber.org/zap v1.9.1
+	golang.org/x/crypto v0.0.0-20210314154223-e6e6c4f2bb5b
 	golang.org/x/sync v0.0.0-20190227155943-e225da77a7e6
 	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
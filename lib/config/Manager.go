@@ -2,10 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"os"
+	"strconv"
 	"time"
 	"github.com/opwire/opwire-agent/lib/invokers"
 	"github.com/opwire/opwire-agent/lib/storages"
-	"github.com/opwire/opwire-agent/lib/utils"
 )
 
 type Configuration struct {
@@ -17,6 +18,14 @@ type Configuration struct {
 	SettingsFormat *string `json:"settings-format"`
 	HttpServer *configHttpServer `json:"http-server"`
 	Logging *configLogging `json:"logging"`
+	AccessLog *configAccessLog `json:"access-log"`
+	Schedules map[string]*configSchedule `json:"schedules"`
+	Brokers map[string]*configBroker `json:"brokers"`
+	Watchers map[string]*configWatcher `json:"watchers"`
+	Auth *configAuth `json:"auth"`
+	Authorization *configAuthorization `json:"authorization"`
+	Access *configAccess `json:"access"`
+	Tracing *configTracing `json:"tracing"`
 	managerOptions ManagerOptions
 }
 
@@ -172,9 +181,15 @@ type configHttpServer struct {
 	MaxHeaderBytes *int `json:"max-header-bytes"`
 	ReadTimeout *string `json:"read-timeout"`
 	WriteTimeout *string `json:"write-timeout"`
+	ShutdownTimeout *string `json:"shutdown-timeout"`
 	BaseUrl *string `json:"baseurl"`
 	ConcurrentLimit *sectionConcurrentLimit `json:"concurrent-limit"`
 	SingleFlight *sectionSingleFlight `json:"single-flight"`
+	RateLimit *sectionRateLimit `json:"rate-limit"`
+	TrustedProxies []string `json:"trusted-proxies"`
+	ProxyProtocol *bool `json:"proxy-protocol"`
+	TLS *sectionTLS `json:"tls"`
+	Listeners []*sectionListener `json:"listeners"`
 }
 
 func (c *Configuration) GetHttpServer() *configHttpServer {
@@ -238,6 +253,27 @@ func (c *configHttpServer) GetWriteTimeout() (time.Duration, error) {
 	return 0, nil
 }
 
+func (c *configHttpServer) GetShutdownTimeout() (time.Duration, error) {
+	if c.ShutdownTimeout != nil {
+		return time.ParseDuration(*c.ShutdownTimeout)
+	}
+	return 0, nil
+}
+
+func (c *configHttpServer) GetTrustedProxies() []string {
+	if c.TrustedProxies == nil {
+		return []string{}
+	}
+	return c.TrustedProxies
+}
+
+func (c *configHttpServer) GetProxyProtocol() bool {
+	if c.ProxyProtocol == nil {
+		return false
+	}
+	return *c.ProxyProtocol
+}
+
 func (c *configHttpServer) ConcurrentLimitEnabled() bool {
 	return c.GetConcurrentLimit().GetEnabled()
 }
@@ -246,6 +282,14 @@ func (c *configHttpServer) ConcurrentLimitTotal() int {
 	return c.GetConcurrentLimit().GetTotal()
 }
 
+func (c *configHttpServer) ConcurrentLimitMaxQueue() int {
+	return c.GetConcurrentLimit().GetMaxQueue()
+}
+
+func (c *configHttpServer) ConcurrentLimitMaxWait() (time.Duration, error) {
+	return c.GetConcurrentLimit().GetMaxWait()
+}
+
 func (c *configHttpServer) SingleFlightEnabled() bool {
 	return c.GetSingleFlight().GetEnabled()
 }
@@ -288,6 +332,8 @@ func (c *configHttpServer) GetConcurrentLimit() *sectionConcurrentLimit {
 type sectionConcurrentLimit struct {
 	Enabled *bool `json:"enabled"`
 	Total *int `json:"total"`
+	MaxQueue *int `json:"max-queue"`
+	MaxWait *string `json:"max-wait"`
 }
 
 func (c *sectionConcurrentLimit) GetEnabled() bool {
@@ -304,6 +350,209 @@ func (c *sectionConcurrentLimit) GetTotal() int {
 	return *c.Total
 }
 
+// GetMaxQueue() returns -1 when the queue length is unlimited.
+func (c *sectionConcurrentLimit) GetMaxQueue() int {
+	if c.MaxQueue == nil {
+		return -1
+	}
+	return *c.MaxQueue
+}
+
+func (c *sectionConcurrentLimit) GetMaxWait() (time.Duration, error) {
+	if c.MaxWait != nil {
+		return time.ParseDuration(*c.MaxWait)
+	}
+	return 0, nil
+}
+
+func (c *configHttpServer) GetTLS() *sectionTLS {
+	if c.TLS == nil {
+		return &sectionTLS{}
+	}
+	return c.TLS
+}
+
+func (c *configHttpServer) GetListeners() []*sectionListener {
+	listeners := make([]*sectionListener, 0, len(c.Listeners))
+	for _, listener := range c.Listeners {
+		if listener != nil {
+			listeners = append(listeners, listener)
+		}
+	}
+	return listeners
+}
+
+// sectionListener declares a TCP listener (address) or a Unix domain socket (socket), and
+// the route groups which are served on it.
+type sectionListener struct {
+	Name *string `json:"name"`
+	Address *string `json:"address"`
+	Socket *string `json:"socket"`
+	Mode *string `json:"mode"`
+	Owner *string `json:"owner"`
+	Routes []string `json:"routes"`
+}
+
+func (c *sectionListener) GetName() string {
+	if c.Name == nil {
+		if c.Socket != nil {
+			return *c.Socket
+		}
+		return c.GetAddress()
+	}
+	return *c.Name
+}
+
+func (c *sectionListener) GetAddress() string {
+	if c.Address == nil {
+		return ""
+	}
+	return *c.Address
+}
+
+func (c *sectionListener) GetSocket() string {
+	if c.Socket == nil {
+		return ""
+	}
+	return *c.Socket
+}
+
+func (c *sectionListener) GetMode() (os.FileMode, error) {
+	if c.Mode == nil {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(*c.Mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(mode), nil
+}
+
+func (c *sectionListener) GetOwner() string {
+	if c.Owner == nil {
+		return ""
+	}
+	return *c.Owner
+}
+
+// GetRoutes() returns nil by default, the listener serves all of the route groups.
+func (c *sectionListener) GetRoutes() []string {
+	return c.Routes
+}
+
+type sectionTLS struct {
+	Enabled *bool `json:"enabled"`
+	CertFile *string `json:"cert-file"`
+	KeyFile *string `json:"key-file"`
+	MinVersion *string `json:"min-version"`
+	CipherSuites []string `json:"cipher-suites"`
+	ClientCAFile *string `json:"client-ca-file"`
+	ClientAuth *string `json:"client-auth"`
+}
+
+func (c *sectionTLS) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.CertFile != nil
+	}
+	return *c.Enabled
+}
+
+func (c *sectionTLS) GetCertFile() string {
+	if c.CertFile == nil {
+		return ""
+	}
+	return *c.CertFile
+}
+
+func (c *sectionTLS) GetKeyFile() string {
+	if c.KeyFile == nil {
+		return ""
+	}
+	return *c.KeyFile
+}
+
+func (c *sectionTLS) GetMinVersion() string {
+	if c.MinVersion == nil {
+		return ""
+	}
+	return *c.MinVersion
+}
+
+func (c *sectionTLS) GetCipherSuites() []string {
+	if c.CipherSuites == nil {
+		return []string{}
+	}
+	return c.CipherSuites
+}
+
+func (c *sectionTLS) GetClientCAFile() string {
+	if c.ClientCAFile == nil {
+		return ""
+	}
+	return *c.ClientCAFile
+}
+
+// GetClientAuth() returns "require" by default when the client CA bundle is declared.
+func (c *sectionTLS) GetClientAuth() string {
+	if c.ClientAuth == nil {
+		if c.ClientCAFile != nil {
+			return "require"
+		}
+		return "none"
+	}
+	return *c.ClientAuth
+}
+
+func (c *configHttpServer) GetRateLimit() *sectionRateLimit {
+	if c.RateLimit == nil {
+		return &sectionRateLimit{}
+	}
+	return c.RateLimit
+}
+
+type sectionRateLimit struct {
+	Enabled *bool `json:"enabled"`
+	Rate *float64 `json:"rate"`
+	Burst *int `json:"burst"`
+	By *string `json:"by"`
+	Header *string `json:"header"`
+}
+
+func (c *sectionRateLimit) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.Rate != nil
+	}
+	return *c.Enabled
+}
+
+func (c *sectionRateLimit) GetRate() float64 {
+	if c.Rate == nil {
+		return 0
+	}
+	return *c.Rate
+}
+
+func (c *sectionRateLimit) GetBurst() int {
+	if c.Burst == nil {
+		return 0
+	}
+	return *c.Burst
+}
+
+func (c *sectionRateLimit) GetBy() string {
+	if c.By == nil {
+		return ""
+	}
+	return *c.By
+}
+
+func (c *sectionRateLimit) GetHeader() string {
+	if c.Header == nil {
+		return ""
+	}
+	return *c.Header
+}
+
 func (c *configHttpServer) GetSingleFlight() *sectionSingleFlight {
 	if c.SingleFlight == nil {
 		return &sectionSingleFlight{}
@@ -313,13 +562,7 @@ func (c *configHttpServer) GetSingleFlight() *sectionSingleFlight {
 
 type sectionSingleFlight struct {
 	Enabled *bool `json:"enabled"`
-	ReqIdName *string `json:"req-id"`
-	ByMethod *bool `json:"by-method"`
-	ByPath *bool `json:"by-path"`
-	ByHeaders *string `json:"by-headers"`
-	ByQueries *string `json:"by-queries"`
-	ByBody *bool `json:"by-body"`
-	ByUserIP *bool `json:"by-userip"`
+	invokers.SingleFlightKey
 }
 
 func (c *sectionSingleFlight) GetEnabled() bool {
@@ -329,80 +572,775 @@ func (c *sectionSingleFlight) GetEnabled() bool {
 	return *c.Enabled
 }
 
-func (c *sectionSingleFlight) GetReqIdName() string {
-	if c.ReqIdName == nil {
+func (c *Configuration) GetSchedules() map[string]*configSchedule {
+	schedules := make(map[string]*configSchedule)
+	for name, schedule := range c.Schedules {
+		if schedule != nil {
+			schedules[name] = schedule
+		}
+	}
+	return schedules
+}
+
+type configSchedule struct {
+	Enabled *bool `json:"enabled"`
+	Cron *string `json:"cron"`
+	Timezone *string `json:"timezone"`
+	Resource *string `json:"resource"`
+	Method *string `json:"method"`
+	Stdin *string `json:"stdin"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+func (c *configSchedule) GetEnabled() bool {
+	if c.Enabled == nil {
+		return true
+	}
+	return *c.Enabled
+}
+
+func (c *configSchedule) GetCron() string {
+	if c.Cron == nil {
 		return ""
 	}
-	return *c.ReqIdName
+	return *c.Cron
 }
 
-func (c *sectionSingleFlight) GetByMethod() bool {
-	if c.ByMethod == nil {
-		if c.ReqIdName != nil && len(*c.ReqIdName) > 0 {
-			return false
-		}
-		if c.ByPath != nil && *c.ByPath {
-			return true
-		}
-		if c.ByUserIP != nil && *c.ByUserIP {
-			return true
-		}
-		return false
+func (c *configSchedule) GetTimezone() string {
+	if c.Timezone == nil {
+		return ""
+	}
+	return *c.Timezone
+}
+
+func (c *configSchedule) GetResource() string {
+	if c.Resource == nil {
+		return ""
 	}
-	return *c.ByMethod
+	return *c.Resource
 }
 
-func (c *sectionSingleFlight) GetByPath() bool {
-	if c.ByPath == nil {
-		if c.ReqIdName != nil && len(*c.ReqIdName) > 0 {
-			return false
+func (c *configSchedule) GetMethod() string {
+	if c.Method == nil {
+		return ""
+	}
+	return *c.Method
+}
+
+func (c *configSchedule) GetStdin() []byte {
+	if c.Stdin == nil {
+		return nil
+	}
+	return []byte(*c.Stdin)
+}
+
+func (c *configSchedule) GetSettings() map[string]interface{} {
+	return c.Settings
+}
+
+func (c *Configuration) GetBrokers() map[string]*configBroker {
+	brokers := make(map[string]*configBroker)
+	for name, broker := range c.Brokers {
+		if broker != nil {
+			brokers[name] = broker
 		}
-		if c.ByMethod != nil && *c.ByMethod {
-			return true
+	}
+	return brokers
+}
+
+type configBroker struct {
+	Enabled *bool `json:"enabled"`
+	Type *string `json:"type"`
+	Resource *string `json:"resource"`
+	Method *string `json:"method"`
+	Nats *sectionNatsBroker `json:"nats"`
+	Spool *sectionSpoolBroker `json:"spool"`
+}
+
+func (c *configBroker) GetEnabled() bool {
+	if c.Enabled == nil {
+		return true
+	}
+	return *c.Enabled
+}
+
+func (c *configBroker) GetType() string {
+	if c.Type == nil {
+		return ""
+	}
+	return *c.Type
+}
+
+func (c *configBroker) GetResource() string {
+	if c.Resource == nil {
+		return ""
+	}
+	return *c.Resource
+}
+
+func (c *configBroker) GetMethod() string {
+	if c.Method == nil {
+		return ""
+	}
+	return *c.Method
+}
+
+func (c *configBroker) GetNats() *sectionNatsBroker {
+	if c.Nats == nil {
+		return &sectionNatsBroker{}
+	}
+	return c.Nats
+}
+
+func (c *configBroker) GetSpool() *sectionSpoolBroker {
+	if c.Spool == nil {
+		return &sectionSpoolBroker{}
+	}
+	return c.Spool
+}
+
+type sectionNatsBroker struct {
+	Url *string `json:"url"`
+	Subject *string `json:"subject"`
+	QueueGroup *string `json:"queue-group"`
+	DeadLetterSubject *string `json:"dead-letter-subject"`
+}
+
+func (c *sectionNatsBroker) GetUrl() string {
+	if c.Url == nil {
+		return "nats://127.0.0.1:4222"
+	}
+	return *c.Url
+}
+
+func (c *sectionNatsBroker) GetSubject() string {
+	if c.Subject == nil {
+		return ""
+	}
+	return *c.Subject
+}
+
+func (c *sectionNatsBroker) GetQueueGroup() string {
+	if c.QueueGroup == nil {
+		return ""
+	}
+	return *c.QueueGroup
+}
+
+func (c *sectionNatsBroker) GetDeadLetterSubject() string {
+	if c.DeadLetterSubject == nil {
+		return ""
+	}
+	return *c.DeadLetterSubject
+}
+
+type sectionSpoolBroker struct {
+	Directory *string `json:"directory"`
+	ReplyDirectory *string `json:"reply-directory"`
+	DeadLetterDirectory *string `json:"dead-letter-directory"`
+	PollInterval *string `json:"poll-interval"`
+}
+
+func (c *sectionSpoolBroker) GetDirectory() string {
+	if c.Directory == nil {
+		return ""
+	}
+	return *c.Directory
+}
+
+func (c *sectionSpoolBroker) GetReplyDirectory() string {
+	if c.ReplyDirectory == nil {
+		return ""
+	}
+	return *c.ReplyDirectory
+}
+
+func (c *sectionSpoolBroker) GetDeadLetterDirectory() string {
+	if c.DeadLetterDirectory == nil {
+		return ""
+	}
+	return *c.DeadLetterDirectory
+}
+
+func (c *sectionSpoolBroker) GetPollInterval() (time.Duration, error) {
+	if c.PollInterval != nil {
+		return time.ParseDuration(*c.PollInterval)
+	}
+	return 0, nil
+}
+
+func (c *Configuration) GetWatchers() map[string]*configWatcher {
+	watchers := make(map[string]*configWatcher)
+	for name, watcher := range c.Watchers {
+		if watcher != nil {
+			watchers[name] = watcher
 		}
-		if c.ByUserIP != nil && *c.ByUserIP {
-			return true
+	}
+	return watchers
+}
+
+type configWatcher struct {
+	Enabled *bool `json:"enabled"`
+	Resource *string `json:"resource"`
+	Method *string `json:"method"`
+	Directory *string `json:"directory"`
+	Patterns []string `json:"patterns"`
+	Events []string `json:"events"`
+	Debounce *string `json:"debounce"`
+	DoneDirectory *string `json:"done-directory"`
+	FailedDirectory *string `json:"failed-directory"`
+}
+
+func (c *configWatcher) GetEnabled() bool {
+	if c.Enabled == nil {
+		return true
+	}
+	return *c.Enabled
+}
+
+func (c *configWatcher) GetResource() string {
+	if c.Resource == nil {
+		return ""
+	}
+	return *c.Resource
+}
+
+func (c *configWatcher) GetMethod() string {
+	if c.Method == nil {
+		return ""
+	}
+	return *c.Method
+}
+
+func (c *configWatcher) GetDirectory() string {
+	if c.Directory == nil {
+		return ""
+	}
+	return *c.Directory
+}
+
+func (c *configWatcher) GetPatterns() []string {
+	return c.Patterns
+}
+
+func (c *configWatcher) GetEvents() []string {
+	if len(c.Events) == 0 {
+		return []string{ "create", "write" }
+	}
+	return c.Events
+}
+
+func (c *configWatcher) GetDebounce() (time.Duration, error) {
+	if c.Debounce != nil {
+		return time.ParseDuration(*c.Debounce)
+	}
+	return 0, nil
+}
+
+func (c *configWatcher) GetDoneDirectory() string {
+	if c.DoneDirectory == nil {
+		return ""
+	}
+	return *c.DoneDirectory
+}
+
+func (c *configWatcher) GetFailedDirectory() string {
+	if c.FailedDirectory == nil {
+		return ""
+	}
+	return *c.FailedDirectory
+}
+
+func (c *Configuration) GetAuth() *configAuth {
+	if c.Auth == nil {
+		return &configAuth{}
+	}
+	return c.Auth
+}
+
+type configAuth struct {
+	Enabled *bool `json:"enabled"`
+	Realm *string `json:"realm"`
+	ApiKeys *sectionApiKeys `json:"api-keys"`
+	Basic *sectionBasicAuth `json:"basic"`
+	JWT *sectionJWT `json:"jwt"`
+}
+
+// GetEnabled() returns true by default when one of the providers is declared.
+func (c *configAuth) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.ApiKeys != nil || c.Basic != nil || c.JWT != nil
+	}
+	return *c.Enabled
+}
+
+func (c *configAuth) GetRealm() string {
+	if c.Realm == nil {
+		return "opwire"
+	}
+	return *c.Realm
+}
+
+func (c *configAuth) GetApiKeys() *sectionApiKeys {
+	if c.ApiKeys == nil {
+		return &sectionApiKeys{}
+	}
+	return c.ApiKeys
+}
+
+func (c *configAuth) GetBasic() *sectionBasicAuth {
+	if c.Basic == nil {
+		return &sectionBasicAuth{}
+	}
+	return c.Basic
+}
+
+func (c *configAuth) GetJWT() *sectionJWT {
+	if c.JWT == nil {
+		return &sectionJWT{}
+	}
+	return c.JWT
+}
+
+type sectionApiKeys struct {
+	Enabled *bool `json:"enabled"`
+	Header *string `json:"header"`
+	Keys []*sectionApiKey `json:"keys"`
+	KeysFile *string `json:"keys-file"`
+}
+
+func (c *sectionApiKeys) GetEnabled() bool {
+	if c.Enabled == nil {
+		return len(c.Keys) > 0 || c.KeysFile != nil
+	}
+	return *c.Enabled
+}
+
+func (c *sectionApiKeys) GetHeader() string {
+	if c.Header == nil {
+		return ""
+	}
+	return *c.Header
+}
+
+func (c *sectionApiKeys) GetKeys() []*sectionApiKey {
+	keys := make([]*sectionApiKey, 0, len(c.Keys))
+	for _, key := range c.Keys {
+		if key != nil {
+			keys = append(keys, key)
 		}
-		return false
 	}
-	return *c.ByPath
+	return keys
+}
+
+func (c *sectionApiKeys) GetKeysFile() string {
+	if c.KeysFile == nil {
+		return ""
+	}
+	return *c.KeysFile
+}
+
+type sectionApiKey struct {
+	Name *string `json:"name"`
+	Key *string `json:"key"`
+	Labels []string `json:"labels"`
+	ExpiresAt *string `json:"expires-at"`
+}
+
+func (c *sectionApiKey) GetName() string {
+	if c.Name == nil {
+		return ""
+	}
+	return *c.Name
+}
+
+func (c *sectionApiKey) GetKey() string {
+	if c.Key == nil {
+		return ""
+	}
+	return *c.Key
+}
+
+func (c *sectionApiKey) GetLabels() []string {
+	return c.Labels
+}
+
+// GetExpiresAt() returns nil when the key never expires.
+func (c *sectionApiKey) GetExpiresAt() (*time.Time, error) {
+	if c.ExpiresAt == nil {
+		return nil, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, *c.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &expiresAt, nil
 }
 
-func (c *sectionSingleFlight) GetByHeaders() []string {
-	if c.ByHeaders == nil {
+type sectionBasicAuth struct {
+	Enabled *bool `json:"enabled"`
+	HtpasswdFile *string `json:"htpasswd-file"`
+}
+
+func (c *sectionBasicAuth) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.HtpasswdFile != nil
+	}
+	return *c.Enabled
+}
+
+func (c *sectionBasicAuth) GetHtpasswdFile() string {
+	if c.HtpasswdFile == nil {
+		return ""
+	}
+	return *c.HtpasswdFile
+}
+
+type sectionJWT struct {
+	Enabled *bool `json:"enabled"`
+	Algorithms []string `json:"algorithms"`
+	Secret *string `json:"secret"`
+	SecretFile *string `json:"secret-file"`
+	PublicKeyFile *string `json:"public-key-file"`
+	JwksFile *string `json:"jwks-file"`
+	Issuer *string `json:"issuer"`
+	Audience *string `json:"audience"`
+	Leeway *string `json:"leeway"`
+	PrincipalClaim *string `json:"principal-claim"`
+}
+
+func (c *sectionJWT) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.Secret != nil || c.SecretFile != nil || c.PublicKeyFile != nil || c.JwksFile != nil
+	}
+	return *c.Enabled
+}
+
+func (c *sectionJWT) GetAlgorithms() []string {
+	return c.Algorithms
+}
+
+func (c *sectionJWT) GetSecret() string {
+	if c.Secret == nil {
+		return ""
+	}
+	return *c.Secret
+}
+
+func (c *sectionJWT) GetSecretFile() string {
+	if c.SecretFile == nil {
+		return ""
+	}
+	return *c.SecretFile
+}
+
+func (c *sectionJWT) GetPublicKeyFile() string {
+	if c.PublicKeyFile == nil {
+		return ""
+	}
+	return *c.PublicKeyFile
+}
+
+func (c *sectionJWT) GetJwksFile() string {
+	if c.JwksFile == nil {
+		return ""
+	}
+	return *c.JwksFile
+}
+
+func (c *sectionJWT) GetIssuer() string {
+	if c.Issuer == nil {
+		return ""
+	}
+	return *c.Issuer
+}
+
+func (c *sectionJWT) GetAudience() string {
+	if c.Audience == nil {
+		return ""
+	}
+	return *c.Audience
+}
+
+func (c *sectionJWT) GetLeeway() (time.Duration, error) {
+	if c.Leeway != nil {
+		return time.ParseDuration(*c.Leeway)
+	}
+	return 0, nil
+}
+
+func (c *sectionJWT) GetPrincipalClaim() string {
+	if c.PrincipalClaim == nil {
+		return "sub"
+	}
+	return *c.PrincipalClaim
+}
+
+func (c *Configuration) GetAccess() *configAccess {
+	if c.Access == nil {
+		return &configAccess{}
+	}
+	return c.Access
+}
+
+// configAccess declares the global IP/CIDR lists (applied to the resources and to the
+// control endpoints) and the lists of the control endpoints only.
+type configAccess struct {
+	Allow []string `json:"allow"`
+	Deny []string `json:"deny"`
+	Control *sectionAccessList `json:"control"`
+}
+
+// GetEnabled() returns true when one of the lists is declared.
+func (c *configAccess) GetEnabled() bool {
+	return len(c.Allow) > 0 || len(c.Deny) > 0
+}
+
+func (c *configAccess) GetAllow() []string {
+	if c.Allow == nil {
 		return []string{}
 	}
-	return utils.Split(*c.ByHeaders, ",")
+	return c.Allow
 }
 
-func (c *sectionSingleFlight) GetByQueries() []string {
-	if c.ByQueries == nil {
+func (c *configAccess) GetDeny() []string {
+	if c.Deny == nil {
 		return []string{}
 	}
-	return utils.Split(*c.ByQueries, ",")
+	return c.Deny
 }
 
-func (c *sectionSingleFlight) GetByBody() bool {
-	if c.ByBody == nil {
-		return false
+func (c *configAccess) GetControl() *sectionAccessList {
+	if c.Control == nil {
+		return &sectionAccessList{}
 	}
-	return *c.ByBody
+	return c.Control
 }
 
-func (c *sectionSingleFlight) GetByUserIP() bool {
-	if c.ByUserIP == nil {
-		if c.ReqIdName != nil && len(*c.ReqIdName) > 0 {
-			return false
+type sectionAccessList struct {
+	Allow []string `json:"allow"`
+	Deny []string `json:"deny"`
+}
+
+// GetEnabled() returns true when one of the lists is declared.
+func (c *sectionAccessList) GetEnabled() bool {
+	return len(c.Allow) > 0 || len(c.Deny) > 0
+}
+
+func (c *sectionAccessList) GetAllow() []string {
+	if c.Allow == nil {
+		return []string{}
+	}
+	return c.Allow
+}
+
+func (c *sectionAccessList) GetDeny() []string {
+	if c.Deny == nil {
+		return []string{}
+	}
+	return c.Deny
+}
+
+func (c *Configuration) GetTracing() *configTracing {
+	if c.Tracing == nil {
+		return &configTracing{}
+	}
+	return c.Tracing
+}
+
+// configTracing declares the exporters of the spans, both of them may be used at once.
+type configTracing struct {
+	Enabled *bool `json:"enabled"`
+	ServiceName *string `json:"service-name"`
+	FlushInterval *string `json:"flush-interval"`
+	Otlp *sectionOtlpExporter `json:"otlp"`
+	File *sectionFileExporter `json:"file"`
+}
+
+// GetEnabled() returns true when one of the exporters is declared.
+func (c *configTracing) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.Otlp != nil || c.File != nil
+	}
+	return *c.Enabled
+}
+
+func (c *configTracing) GetServiceName() string {
+	if c.ServiceName == nil {
+		return "opwire-agent"
+	}
+	return *c.ServiceName
+}
+
+func (c *configTracing) GetFlushInterval() (time.Duration, error) {
+	if c.FlushInterval != nil {
+		return time.ParseDuration(*c.FlushInterval)
+	}
+	return 0, nil
+}
+
+// GetOtlp() returns nil when the OTLP exporter is not declared.
+func (c *configTracing) GetOtlp() *sectionOtlpExporter {
+	return c.Otlp
+}
+
+// GetFile() returns nil when the file exporter is not declared.
+func (c *configTracing) GetFile() *sectionFileExporter {
+	return c.File
+}
+
+type sectionOtlpExporter struct {
+	Endpoint *string `json:"endpoint"`
+	Headers map[string]string `json:"headers"`
+	Timeout *string `json:"timeout"`
+}
+
+func (c *sectionOtlpExporter) GetEndpoint() string {
+	if c.Endpoint == nil {
+		return "http://localhost:4318/v1/traces"
+	}
+	return *c.Endpoint
+}
+
+func (c *sectionOtlpExporter) GetHeaders() map[string]string {
+	if c.Headers == nil {
+		return map[string]string{}
+	}
+	return c.Headers
+}
+
+func (c *sectionOtlpExporter) GetTimeout() (time.Duration, error) {
+	if c.Timeout != nil {
+		return time.ParseDuration(*c.Timeout)
+	}
+	return 0, nil
+}
+
+type sectionFileExporter struct {
+	Path *string `json:"path"`
+}
+
+func (c *sectionFileExporter) GetPath() string {
+	if c.Path == nil {
+		return ""
+	}
+	return *c.Path
+}
+
+func (c *Configuration) GetAuthorization() *configAuthorization {
+	if c.Authorization == nil {
+		return &configAuthorization{}
+	}
+	return c.Authorization
+}
+
+type configAuthorization struct {
+	Enabled *bool `json:"enabled"`
+	Roles map[string]*sectionRole `json:"roles"`
+	Bindings []*sectionRoleBinding `json:"bindings"`
+}
+
+// GetEnabled() returns true by default when some roles are declared.
+func (c *configAuthorization) GetEnabled() bool {
+	if c.Enabled == nil {
+		return len(c.Roles) > 0
+	}
+	return *c.Enabled
+}
+
+func (c *configAuthorization) GetRoles() map[string]*sectionRole {
+	roles := make(map[string]*sectionRole)
+	for name, role := range c.Roles {
+		if role != nil {
+			roles[name] = role
 		}
-		if c.ByMethod != nil && *c.ByMethod {
-			return true
+	}
+	return roles
+}
+
+func (c *configAuthorization) GetBindings() []*sectionRoleBinding {
+	bindings := make([]*sectionRoleBinding, 0, len(c.Bindings))
+	for _, binding := range c.Bindings {
+		if binding != nil {
+			bindings = append(bindings, binding)
 		}
-		if c.ByPath != nil && *c.ByPath {
-			return true
+	}
+	return bindings
+}
+
+type sectionRole struct {
+	Rules []*sectionAccessRule `json:"rules"`
+}
+
+func (c *sectionRole) GetRules() []*sectionAccessRule {
+	rules := make([]*sectionAccessRule, 0, len(c.Rules))
+	for _, rule := range c.Rules {
+		if rule != nil {
+			rules = append(rules, rule)
 		}
+	}
+	return rules
+}
+
+type sectionAccessRule struct {
+	Resources []string `json:"resources"`
+	Methods []string `json:"methods"`
+	Control []string `json:"control"`
+}
+
+func (c *sectionAccessRule) GetResources() []string {
+	return c.Resources
+}
+
+// GetMethods() returns all of the methods by default.
+func (c *sectionAccessRule) GetMethods() []string {
+	if c.Methods == nil {
+		return []string{ "*" }
+	}
+	return c.Methods
+}
+
+func (c *sectionAccessRule) GetControl() []string {
+	return c.Control
+}
+
+type sectionRoleBinding struct {
+	Role *string `json:"role"`
+	Principals []string `json:"principals"`
+	Claims map[string]string `json:"claims"`
+	ApiKeyLabels []string `json:"api-key-labels"`
+	CertSubjects []string `json:"cert-subjects"`
+	Anonymous *bool `json:"anonymous"`
+}
+
+func (c *sectionRoleBinding) GetRole() string {
+	if c.Role == nil {
+		return ""
+	}
+	return *c.Role
+}
+
+func (c *sectionRoleBinding) GetPrincipals() []string {
+	return c.Principals
+}
+
+func (c *sectionRoleBinding) GetClaims() map[string]string {
+	return c.Claims
+}
+
+func (c *sectionRoleBinding) GetApiKeyLabels() []string {
+	return c.ApiKeyLabels
+}
+
+func (c *sectionRoleBinding) GetCertSubjects() []string {
+	return c.CertSubjects
+}
+
+func (c *sectionRoleBinding) GetAnonymous() bool {
+	if c.Anonymous == nil {
 		return false
 	}
-	return *c.ByUserIP
+	return *c.Anonymous
 }
 
 func (c *Configuration) GetLogging() *configLogging {
@@ -413,6 +1351,53 @@ func (c *Configuration) GetLogging() *configLogging {
 	return logging
 }
 
+func (c *Configuration) GetAccessLog() *configAccessLog {
+	if c.AccessLog == nil {
+		return &configAccessLog{}
+	}
+	return c.AccessLog
+}
+
+type configAccessLog struct {
+	Enabled *bool `json:"enabled"`
+	Format *string `json:"format"`
+	Template *string `json:"template"`
+	OutputPaths []string `json:"output-paths"`
+}
+
+// GetEnabled() returns true when the access log is declared.
+func (c *configAccessLog) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.Format != nil || c.Template != nil || c.OutputPaths != nil
+	}
+	return *c.Enabled
+}
+
+// GetFormat() returns "template" when only a template is declared, "combined" by default.
+func (c *configAccessLog) GetFormat() string {
+	if c.Format == nil {
+		if c.Template != nil {
+			return "template"
+		}
+		return "combined"
+	}
+	return *c.Format
+}
+
+func (c *configAccessLog) GetTemplate() string {
+	if c.Template == nil {
+		return ""
+	}
+	return *c.Template
+}
+
+func (c *configAccessLog) GetOutputPaths() []string {
+	if len(c.OutputPaths) == 0 {
+		return []string{ "stdout" }
+	}
+	return c.OutputPaths
+}
+
 type configLogging struct {
 	Enabled *bool `json:"enabled"`
 	Format *string `json:"format"`
@@ -7,7 +7,7 @@ import (
 
 const RESOURCE_NAME_PATTERN string = `[a-zA-Z][a-zA-Z0-9_-]*`
 const BASEURL_PATTERN string = `([\\/]|([\\/][a-zA-Z]|[\\/][a-zA-Z][a-zA-Z0-9_-]*[a-zA-Z0-9])+)?`
-const TIMEOUT_PATTERN string = `([0-9]+h)?([0-9]+m)?([0-9]+s)?([0-9]+ms)?([0-9]+[uµ]s)?([0-9]+ns)?`
+const TIMEOUT_PATTERN string = `([0-9]+(h|m|s|ms|[uµ]s|ns))+`
 const VERSION_PATTERN string = `[v]?(\\d+\\.)?(\\d+\\.)?(\\*|\\d+)`
 
 type Validator struct {
@@ -115,6 +115,16 @@ var configurationSchema string = `{
 				}
 			]
 		},
+		"access-log": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"$ref": "#/definitions/AccessLog"
+				}
+			]
+		},
 		"http-server": {
 			"oneOf": [
 				{
@@ -124,6 +134,94 @@ var configurationSchema string = `{
 					"$ref": "#/definitions/HttpServer"
 				}
 			]
+		},
+		"schedules": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"type": "object",
+					"patternProperties": {
+						"^` + RESOURCE_NAME_PATTERN + `$": {
+							"$ref": "#/definitions/Schedule"
+						}
+					},
+					"additionalProperties": false
+				}
+			]
+		},
+		"brokers": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"type": "object",
+					"patternProperties": {
+						"^` + RESOURCE_NAME_PATTERN + `$": {
+							"$ref": "#/definitions/Broker"
+						}
+					},
+					"additionalProperties": false
+				}
+			]
+		},
+		"auth": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"$ref": "#/definitions/Auth"
+				}
+			]
+		},
+		"authorization": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"$ref": "#/definitions/Authorization"
+				}
+			]
+		},
+		"access": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"$ref": "#/definitions/Access"
+				}
+			]
+		},
+		"tracing": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"$ref": "#/definitions/Tracing"
+				}
+			]
+		},
+		"watchers": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"type": "object",
+					"patternProperties": {
+						"^` + RESOURCE_NAME_PATTERN + `$": {
+							"$ref": "#/definitions/Watcher"
+						}
+					},
+					"additionalProperties": false
+				}
+			]
 		}
 	},
 	"definitions": {
@@ -175,47 +273,1821 @@ var configurationSchema string = `{
 				},
 				"settings-format": {
 					"$ref": "#/definitions/SettingsFormat"
+				},
+				"cache": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/CommandCache"
+						}
+					]
+				},
+				"concurrent-limit": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionConcurrentLimit"
+						}
+					]
+				},
+				"single-flight": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionSingleFlight"
+						}
+					]
+				},
+				"rate-limit": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionRateLimit"
+						}
+					]
+				},
+				"circuit-breaker": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/CommandCircuitBreaker"
+						}
+					]
+				},
+				"auth": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/CommandAuth"
+						}
+					]
+				},
+				"signature": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/CommandSignature"
+						}
+					]
+				},
+				"client-cert": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/CommandClientCert"
+						}
+					]
+				},
+				"access": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/CommandAccess"
+						}
+					]
+				},
+				"locks": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"pattern": "^[a-zA-Z0-9][a-zA-Z0-9_.-]*(:(exclusive|shared))?$"
+							}
+						}
+					]
+				},
+				"lock-timeout": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"cache-control": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
 				}
 			}
 		},
-		"CommandDescriptor": {
+		"Auth": {
 			"type": "object",
 			"properties": {
-				"command": {
-					"type": "string"
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
 				},
-				"timeout": {
-					"type": "number",
-					"minimum": 0
+				"realm": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"api-keys": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/AuthApiKeys"
+						}
+					]
+				},
+				"basic": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/AuthBasic"
+						}
+					]
+				},
+				"jwt": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/AuthJWT"
+						}
+					]
 				}
 			},
-			"required": [ "command" ]
+			"additionalProperties": false
 		},
-		"Settings": {
-			"oneOf": [
-				{
-					"type": "null"
+		"AuthApiKeys": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
 				},
-				{
-					"type": "object"
+				"header": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"keys": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"$ref": "#/definitions/AuthApiKey"
+							}
+						}
+					]
+				},
+				"keys-file": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"AuthApiKey": {
+			"type": "object",
+			"properties": {
+				"name": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"key": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"labels": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				},
+				"expires-at": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"format": "date-time"
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"AuthBasic": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"htpasswd-file": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"AuthJWT": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"algorithms": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"enum": [ "HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512" ]
+							}
+						}
+					]
+				},
+				"secret": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"secret-file": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"public-key-file": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"jwks-file": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"issuer": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"audience": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"leeway": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"principal-claim": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"CommandAuth": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"providers": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"enum": [ "api-key", "basic", "jwt" ]
+							}
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"Access": {
+			"type": "object",
+			"properties": {
+				"allow": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"pattern": "^[0-9A-Fa-f:.]+(/[0-9]{1,3})?$"
+							}
+						}
+					]
+				},
+				"deny": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"pattern": "^[0-9A-Fa-f:.]+(/[0-9]{1,3})?$"
+							}
+						}
+					]
+				},
+				"control": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/AccessList"
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"Tracing": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"service-name": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"flush-interval": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"otlp": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "object",
+							"properties": {
+								"endpoint": {
+									"oneOf": [
+										{
+											"type": "null"
+										},
+										{
+											"type": "string",
+											"pattern": "^https?://"
+										}
+									]
+								},
+								"headers": {
+									"oneOf": [
+										{
+											"type": "null"
+										},
+										{
+											"type": "object",
+											"additionalProperties": {
+												"type": "string"
+											}
+										}
+									]
+								},
+								"timeout": {
+									"oneOf": [
+										{
+											"type": "null"
+										},
+										{
+											"type": "string",
+											"pattern": "^` + TIMEOUT_PATTERN + `$"
+										}
+									]
+								}
+							},
+							"additionalProperties": false
+						}
+					]
+				},
+				"file": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "object",
+							"properties": {
+								"path": {
+									"type": "string",
+									"minLength": 1
+								}
+							},
+							"additionalProperties": false
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"AccessList": {
+			"type": "object",
+			"properties": {
+				"allow": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"pattern": "^[0-9A-Fa-f:.]+(/[0-9]{1,3})?$"
+							}
+						}
+					]
+				},
+				"deny": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"pattern": "^[0-9A-Fa-f:.]+(/[0-9]{1,3})?$"
+							}
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"Authorization": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"roles": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "object",
+							"patternProperties": {
+								"^` + RESOURCE_NAME_PATTERN + `$": {
+									"$ref": "#/definitions/AuthorizationRole"
+								}
+							},
+							"additionalProperties": false
+						}
+					]
+				},
+				"bindings": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"$ref": "#/definitions/AuthorizationBinding"
+							}
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"AuthorizationRole": {
+			"type": "object",
+			"properties": {
+				"rules": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"$ref": "#/definitions/AuthorizationRule"
+							}
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"AuthorizationRule": {
+			"type": "object",
+			"properties": {
+				"resources": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				},
+				"methods": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"pattern": "^(?i)(GET|POST|PUT|PATCH|DELETE|\\*)$"
+							}
+						}
+					]
+				},
+				"control": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"AuthorizationBinding": {
+			"type": "object",
+			"properties": {
+				"role": {
+					"type": "string",
+					"minLength": 1
+				},
+				"principals": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				},
+				"claims": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "object",
+							"additionalProperties": {
+								"type": "string"
+							}
+						}
+					]
+				},
+				"api-key-labels": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				},
+				"cert-subjects": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				},
+				"anonymous": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				}
+			},
+			"required": [ "role" ],
+			"additionalProperties": false
+		},
+		"CommandSignature": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"header": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"algorithm": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"enum": [ "sha1", "sha256" ]
+						}
+					]
+				},
+				"secret": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"secret-file": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"secret-env": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"prefix": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"encoding": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"enum": [ "hex", "base64" ]
+						}
+					]
+				},
+				"signature-key": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"timestamp-header": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"timestamp-key": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"tolerance": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"payload": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"CommandAccess": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"allow": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"pattern": "^[0-9A-Fa-f:.]+(/[0-9]{1,3})?$"
+							}
+						}
+					]
+				},
+				"deny": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"pattern": "^[0-9A-Fa-f:.]+(/[0-9]{1,3})?$"
+							}
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"CommandClientCert": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"subjects": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				},
+				"sans": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"CommandCircuitBreaker": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"failure-threshold": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "integer",
+							"minimum": 1
+						}
+					]
+				},
+				"window": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"cool-down": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"half-open-trials": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "integer",
+							"minimum": 1
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"CommandCache": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"ttl": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"max-entries": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "integer",
+							"minimum": 1
+						}
+					]
+				},
+				"max-bytes": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "integer",
+							"minimum": 1
+						}
+					]
+				},
+				"by-method": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"by-path": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"by-headers": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"by-queries": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"by-body": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"CommandDescriptor": {
+			"type": "object",
+			"properties": {
+				"command": {
+					"type": "string"
+				},
+				"timeout": {
+					"type": "number",
+					"minimum": 0
+				},
+				"concurrent-limit": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionConcurrentLimit"
+						}
+					]
+				},
+				"single-flight": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionSingleFlight"
+						}
+					]
+				}
+			},
+			"required": [ "command" ]
+		},
+		"Schedule": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"cron": {
+					"type": "string",
+					"minLength": 1
+				},
+				"timezone": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"resource": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + RESOURCE_NAME_PATTERN + `$"
+						}
+					]
+				},
+				"method": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^(?i)(GET|POST|PUT|PATCH|DELETE)$"
+						}
+					]
+				},
+				"stdin": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"settings": {
+					"$ref": "#/definitions/Settings"
+				}
+			},
+			"required": [ "cron" ],
+			"additionalProperties": false
+		},
+		"Broker": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"type": {
+					"type": "string",
+					"enum": [ "nats", "spool" ]
+				},
+				"resource": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + RESOURCE_NAME_PATTERN + `$"
+						}
+					]
+				},
+				"method": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^(?i)(GET|POST|PUT|PATCH|DELETE)$"
+						}
+					]
+				},
+				"nats": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionNatsBroker"
+						}
+					]
+				},
+				"spool": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionSpoolBroker"
+						}
+					]
+				}
+			},
+			"required": [ "type" ],
+			"additionalProperties": false
+		},
+		"Watcher": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"resource": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + RESOURCE_NAME_PATTERN + `$"
+						}
+					]
+				},
+				"method": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^(?i)(GET|POST|PUT|PATCH|DELETE)$"
+						}
+					]
+				},
+				"directory": {
+					"type": "string",
+					"minLength": 1
+				},
+				"patterns": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string"
+							}
+						}
+					]
+				},
+				"events": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"enum": [ "create", "write", "remove", "rename", "chmod" ]
+							}
+						}
+					]
+				},
+				"debounce": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"done-directory": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"failed-directory": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				}
+			},
+			"required": [ "directory" ],
+			"additionalProperties": false
+		},
+		"Settings": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"type": "object"
+				}
+			]
+		},
+		"SettingsFormat": {
+			"oneOf": [
+				{
+					"type": "null"
+				},
+				{
+					"type": "string",
+					"enum": [ "json", "flat" ]
+				}
+			]
+		},
+		"Logging": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"format": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"enum": [ "json", "flat" ]
+						}
+					]
+				},
+				"level": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"enum": [ "debug", "info", "warn", "error", "panic", "fatal" ]
+						}
+					]
+				},
+				"output-paths": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string"
+							}
+						}
+					]
+				},
+				"error-output-paths": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string"
+							}
+						}
+					]
 				}
-			]
+			}
 		},
-		"SettingsFormat": {
-			"oneOf": [
-				{
-					"type": "null"
+		"AccessLog": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"format": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"enum": [ "combined", "json", "template" ]
+						}
+					]
+				},
+				"template": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"output-paths": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"HttpServer": {
+			"type": "object",
+			"properties": {
+				"host": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"port": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "integer",
+							"minimum": 0
+						}
+					]
+				},
+				"baseurl": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + BASEURL_PATTERN + `$"
+						}
+					]
+				},
+				"max-header-bytes": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "integer",
+							"minimum": 0
+						}
+					]
+				},
+				"read-timeout": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"write-timeout": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"shutdown-timeout": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				},
+				"concurrent-limit": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionConcurrentLimit"
+						}
+					]
+				},
+				"trusted-proxies": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
+						}
+					]
+				},
+				"proxy-protocol": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"tls": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionTLS"
+						}
+					]
+				},
+				"listeners": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "array",
+							"items": {
+								"$ref": "#/definitions/sectionListener"
+							}
+						}
+					]
+				},
+				"rate-limit": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionRateLimit"
+						}
+					]
+				},
+				"single-flight": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"$ref": "#/definitions/sectionSingleFlight"
+						}
+					]
+				},
+				"single-flight-enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
+				},
+				"single-flight-req-id": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"single-flight-by-method": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
+						}
+					]
 				},
-				{
-					"type": "string",
-					"enum": [ "json", "flat" ]
-				}
-			]
-		},
-		"Logging": {
-			"type": "object",
-			"properties": {
-				"enabled": {
+				"single-flight-by-path": {
 					"oneOf": [
 						{
 							"type": "null"
@@ -225,92 +2097,100 @@ var configurationSchema string = `{
 						}
 					]
 				},
-				"format": {
+				"single-flight-by-headers": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "string",
-							"enum": [ "json", "flat" ]
+							"type": "string"
 						}
 					]
 				},
-				"level": {
+				"single-flight-by-queries": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "string",
-							"enum": [ "debug", "info", "warn", "error", "panic", "fatal" ]
+							"type": "string"
 						}
 					]
 				},
-				"output-paths": {
+				"single-flight-by-body": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "array",
-							"items": {
-								"type": "string"
-							}
+							"type": "boolean"
 						}
 					]
 				},
-				"error-output-paths": {
+				"single-flight-by-userip": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "array",
-							"items": {
-								"type": "string"
-							}
+							"type": "boolean"
 						}
 					]
 				}
-			}
+			},
+			"additionalProperties": false
 		},
-		"HttpServer": {
+		"SectionExplanation": {
 			"type": "object",
 			"properties": {
-				"host": {
+				"enabled": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "string"
+							"type": "boolean"
 						}
 					]
 				},
-				"port": {
+				"format": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "integer",
-							"minimum": 0
+							"type": "string"
+						}
+					]
+				}
+			},
+			"additionalProperties": false
+		},
+		"sectionConcurrentLimit": {
+			"type": "object",
+			"properties": {
+				"enabled": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "boolean"
 						}
 					]
 				},
-				"baseurl": {
+				"total": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "string",
-							"pattern": "^` + BASEURL_PATTERN + `$"
+							"type": "integer",
+							"minimum": 1
 						}
 					]
 				},
-				"max-header-bytes": {
+				"max-queue": {
 					"oneOf": [
 						{
 							"type": "null"
@@ -321,7 +2201,7 @@ var configurationSchema string = `{
 						}
 					]
 				},
-				"read-timeout": {
+				"max-wait": {
 					"oneOf": [
 						{
 							"type": "null"
@@ -331,69 +2211,111 @@ var configurationSchema string = `{
 							"pattern": "^` + TIMEOUT_PATTERN + `$"
 						}
 					]
-				},
-				"write-timeout": {
+				}
+			},
+			"additionalProperties": false
+		},
+		"sectionListener": {
+			"type": "object",
+			"properties": {
+				"name": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
 							"type": "string",
-							"pattern": "^` + TIMEOUT_PATTERN + `$"
+							"minLength": 1
 						}
 					]
 				},
-				"concurrent-limit": {
+				"address": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"$ref": "#/definitions/sectionConcurrentLimit"
+							"type": "string",
+							"minLength": 1
 						}
 					]
 				},
-				"single-flight": {
+				"socket": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"$ref": "#/definitions/sectionSingleFlight"
+							"type": "string",
+							"minLength": 1
 						}
 					]
 				},
-				"single-flight-enabled": {
+				"mode": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "boolean"
+							"type": "string",
+							"pattern": "^0?[0-7]{3}$"
 						}
 					]
 				},
-				"single-flight-req-id": {
+				"owner": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "string"
+							"type": "string",
+							"pattern": "^[^:]*(:[^:]*)?$"
 						}
 					]
 				},
-				"single-flight-by-method": {
+				"routes": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "boolean"
+							"type": "array",
+							"items": {
+								"type": "string",
+								"enum": ["exec", "patterns", "control", "static"]
+							}
 						}
 					]
+				}
+			},
+			"oneOf": [
+				{
+					"properties": {
+						"address": {
+							"type": "string"
+						},
+						"socket": {
+							"type": "null"
+						}
+					}
 				},
-				"single-flight-by-path": {
+				{
+					"properties": {
+						"address": {
+							"type": "null"
+						},
+						"socket": {
+							"type": "string"
+						}
+					}
+				}
+			],
+			"additionalProperties": false
+		},
+		"sectionTLS": {
+			"type": "object",
+			"properties": {
+				"enabled": {
 					"oneOf": [
 						{
 							"type": "null"
@@ -403,76 +2325,79 @@ var configurationSchema string = `{
 						}
 					]
 				},
-				"single-flight-by-headers": {
+				"cert-file": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "string"
+							"type": "string",
+							"minLength": 1
 						}
 					]
 				},
-				"single-flight-by-queries": {
+				"key-file": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "string"
+							"type": "string",
+							"minLength": 1
 						}
 					]
 				},
-				"single-flight-by-body": {
+				"min-version": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "boolean"
+							"type": "string",
+							"enum": [ "1.0", "1.1", "1.2", "1.3" ]
 						}
 					]
 				},
-				"single-flight-by-userip": {
+				"cipher-suites": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "boolean"
+							"type": "array",
+							"items": {
+								"type": "string",
+								"minLength": 1
+							}
 						}
 					]
-				}
-			},
-			"additionalProperties": false
-		},
-		"SectionExplanation": {
-			"type": "object",
-			"properties": {
-				"enabled": {
+				},
+				"client-ca-file": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "boolean"
+							"type": "string",
+							"minLength": 1
 						}
 					]
 				},
-				"format": {
+				"client-auth": {
 					"oneOf": [
 						{
 							"type": "null"
 						},
 						{
-							"type": "string"
+							"type": "string",
+							"enum": [ "none", "optional", "require" ]
 						}
 					]
 				}
 			},
 			"additionalProperties": false
 		},
-		"sectionConcurrentLimit": {
+		"sectionRateLimit": {
 			"type": "object",
 			"properties": {
 				"enabled": {
@@ -485,7 +2410,18 @@ var configurationSchema string = `{
 						}
 					]
 				},
-				"total": {
+				"rate": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "number",
+							"exclusiveMinimum": 0
+						}
+					]
+				},
+				"burst": {
 					"oneOf": [
 						{
 							"type": "null"
@@ -495,6 +2431,28 @@ var configurationSchema string = `{
 							"minimum": 1
 						}
 					]
+				},
+				"by": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"enum": [ "userip", "header", "resource" ]
+						}
+					]
+				},
+				"header": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
 				}
 			},
 			"additionalProperties": false
@@ -584,6 +2542,90 @@ var configurationSchema string = `{
 				}
 			},
 			"additionalProperties": false
+		},
+		"sectionNatsBroker": {
+			"type": "object",
+			"properties": {
+				"url": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"minLength": 1
+						}
+					]
+				},
+				"subject": {
+					"type": "string",
+					"minLength": 1
+				},
+				"queue-group": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"dead-letter-subject": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				}
+			},
+			"required": [ "subject" ],
+			"additionalProperties": false
+		},
+		"sectionSpoolBroker": {
+			"type": "object",
+			"properties": {
+				"directory": {
+					"type": "string",
+					"minLength": 1
+				},
+				"reply-directory": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"dead-letter-directory": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string"
+						}
+					]
+				},
+				"poll-interval": {
+					"oneOf": [
+						{
+							"type": "null"
+						},
+						{
+							"type": "string",
+							"pattern": "^` + TIMEOUT_PATTERN + `$"
+						}
+					]
+				}
+			},
+			"required": [ "directory" ],
+			"additionalProperties": false
 		}
 	}
 }`
@@ -3,6 +3,7 @@ package config
 import(
 	"testing"
 	"github.com/stretchr/testify/assert"
+	"github.com/opwire/opwire-agent/lib/invokers"
 )
 
 func TestValidator_Validate(t *testing.T) {
@@ -33,4 +34,341 @@ func TestValidator_Validate(t *testing.T) {
 		assert.Nil(t, err)
 		assert.False(t, result.Valid())
 	})
+
+	t.Run("schedule with cron expression and overridden settings", func(t *testing.T) {
+		cron := "0 30 2 * * *"
+		timezone := "UTC"
+		resource := "cleanup-job"
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Schedules: map[string]*configSchedule{
+				"cleanup": &configSchedule{
+					Cron: &cron,
+					Timezone: &timezone,
+					Resource: &resource,
+					Settings: map[string]interface{}{ "dry-run": true },
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+	})
+
+	t.Run("schedule without cron expression", func(t *testing.T) {
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Schedules: map[string]*configSchedule{
+				"cleanup": &configSchedule{},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("schedule with an unsupported method", func(t *testing.T) {
+		cron := "@daily"
+		method := "HEAD"
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Schedules: map[string]*configSchedule{
+				"cleanup": &configSchedule{ Cron: &cron, Method: &method },
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("resource with a zero rate limit", func(t *testing.T) {
+		rate := 0.0
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Resources: map[string]invokers.CommandEntrypoint{
+				"reports": invokers.CommandEntrypoint{
+					RateLimit: &invokers.CommandRateLimit{ Rate: &rate },
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("resource with an unsupported lock mode", func(t *testing.T) {
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Resources: map[string]invokers.CommandEntrypoint{
+				"backup": invokers.CommandEntrypoint{
+					Locks: []string{ "db-maintenance:readonly" },
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("resource with single-flight override, locks and concurrent limit", func(t *testing.T) {
+		disabled := false
+		total := 2
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Resources: map[string]invokers.CommandEntrypoint{
+				"reports": invokers.CommandEntrypoint{
+					Default: &invokers.CommandDescriptor{ CommandString: "echo" },
+					SingleFlight: &invokers.CommandSingleFlight{},
+					ConcurrentLimit: &invokers.CommandConcurrentLimit{ Total: &total },
+					Locks: []string{ "db-maintenance:shared" },
+					Methods: map[string]*invokers.CommandDescriptor{
+						"POST": &invokers.CommandDescriptor{
+							CommandString: "echo",
+							SingleFlight: &invokers.CommandSingleFlight{ Enabled: &disabled },
+						},
+					},
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+	})
+
+	t.Run("mutual TLS with an unsupported client-auth mode", func(t *testing.T) {
+		caFile := "/etc/opwire/ca.pem"
+		clientAuth := "always"
+		cfg := &Configuration{
+			Version: "0.0.1",
+			HttpServer: &configHttpServer{
+				TLS: &sectionTLS{ ClientCAFile: &caFile, ClientAuth: &clientAuth },
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("resource with a client certificate allowlist", func(t *testing.T) {
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Resources: map[string]invokers.CommandEntrypoint{
+				"billing": invokers.CommandEntrypoint{
+					Default: &invokers.CommandDescriptor{ CommandString: "echo" },
+					ClientCert: &invokers.CommandClientCert{
+						Subjects: []string{ "orders-service" },
+						SANs: []string{ "*.internal.example.com" },
+					},
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+	})
+
+	t.Run("authentication with API keys, Basic auth and JWT", func(t *testing.T) {
+		name, key, expiresAt := "ci", "s3cr3t", "2030-01-01T00:00:00Z"
+		htpasswd, secret, leeway := "/etc/opwire/htpasswd", "hmac-secret", "30s"
+		disabled := false
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Auth: &configAuth{
+				ApiKeys: &sectionApiKeys{
+					Keys: []*sectionApiKey{ &sectionApiKey{ Name: &name, Key: &key, ExpiresAt: &expiresAt } },
+				},
+				Basic: &sectionBasicAuth{ HtpasswdFile: &htpasswd },
+				JWT: &sectionJWT{ Secret: &secret, Algorithms: []string{ "HS256" }, Leeway: &leeway },
+			},
+			Resources: map[string]invokers.CommandEntrypoint{
+				"status": invokers.CommandEntrypoint{
+					Default: &invokers.CommandDescriptor{ CommandString: "echo" },
+					Auth: &invokers.CommandAuth{ Enabled: &disabled },
+				},
+				"deploy": invokers.CommandEntrypoint{
+					Default: &invokers.CommandDescriptor{ CommandString: "echo" },
+					Auth: &invokers.CommandAuth{ Providers: []string{ "jwt", "api-key" } },
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+	})
+
+	t.Run("authentication with an unsupported JWT algorithm", func(t *testing.T) {
+		secret := "hmac-secret"
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Auth: &configAuth{
+				JWT: &sectionJWT{ Secret: &secret, Algorithms: []string{ "none" } },
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("authorization with roles and bindings", func(t *testing.T) {
+		role := "ops"
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Authorization: &configAuthorization{
+				Roles: map[string]*sectionRole{
+					"ops": &sectionRole{
+						Rules: []*sectionAccessRule{
+							&sectionAccessRule{ Resources: []string{ "product" }, Methods: []string{ "GET", "delete" } },
+							&sectionAccessRule{ Control: []string{ "lock", "unlock" } },
+						},
+					},
+				},
+				Bindings: []*sectionRoleBinding{
+					&sectionRoleBinding{ Role: &role, Claims: map[string]string{ "groups": "ops" } },
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+	})
+
+	t.Run("authorization with an unsupported method", func(t *testing.T) {
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Authorization: &configAuthorization{
+				Roles: map[string]*sectionRole{
+					"ops": &sectionRole{
+						Rules: []*sectionAccessRule{
+							&sectionAccessRule{ Resources: []string{ "product" }, Methods: []string{ "HEAD" } },
+						},
+					},
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("TCP & Unix socket listeners", func(t *testing.T) {
+		address, socket, mode, owner := "0.0.0.0:8080", "/run/opwire/admin.sock", "0660", "opwire:ops"
+		cfg := &Configuration{
+			Version: "0.0.1",
+			HttpServer: &configHttpServer{
+				Listeners: []*sectionListener{
+					&sectionListener{ Address: &address, Routes: []string{ "exec", "patterns" } },
+					&sectionListener{ Socket: &socket, Mode: &mode, Owner: &owner, Routes: []string{ "control" } },
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+
+		invalids := []*sectionListener{
+			&sectionListener{},
+			&sectionListener{ Address: &address, Socket: &socket },
+			&sectionListener{ Address: &address, Routes: []string{ "admin" } },
+		}
+		for _, listener := range invalids {
+			cfg.HttpServer.Listeners = []*sectionListener{ listener }
+			result, err = validator.Validate(cfg)
+			assert.Nil(t, err)
+			assert.False(t, result.Valid())
+		}
+	})
+
+	t.Run("global, control & resource access lists", func(t *testing.T) {
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Access: &configAccess{
+				Deny: []string{ "198.51.100.0/24" },
+				Control: &sectionAccessList{ Allow: []string{ "127.0.0.1", "::1" } },
+			},
+			Resources: map[string]invokers.CommandEntrypoint{
+				"internal": invokers.CommandEntrypoint{
+					Default: &invokers.CommandDescriptor{ CommandString: "echo" },
+					Access: &invokers.CommandAccess{ Allow: []string{ "10.0.0.0/8", "fd00::/8" } },
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+
+		cfg.Access.Control.Allow = []string{ "localhost" }
+		result, err = validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("resource with a webhook signature", func(t *testing.T) {
+		header, prefix, secretEnv := "X-Hub-Signature-256", "sha256=", "GITHUB_WEBHOOK_SECRET"
+		sha1, tolerance := "sha1", "5m"
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Resources: map[string]invokers.CommandEntrypoint{
+				"github": invokers.CommandEntrypoint{
+					Default: &invokers.CommandDescriptor{ CommandString: "echo" },
+					Signature: &invokers.CommandSignature{ Header: &header, Prefix: &prefix, SecretEnv: &secretEnv, Tolerance: &tolerance },
+				},
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+
+		md5 := "md5"
+		cfg.Resources["legacy"] = invokers.CommandEntrypoint{
+			Default: &invokers.CommandDescriptor{ CommandString: "echo" },
+			Signature: &invokers.CommandSignature{ Header: &header, Algorithm: &sha1 },
+		}
+		cfg.Resources["broken"] = invokers.CommandEntrypoint{
+			Default: &invokers.CommandDescriptor{ CommandString: "echo" },
+			Signature: &invokers.CommandSignature{ Header: &header, Algorithm: &md5 },
+		}
+		result, err = validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("tracing with the OTLP & file exporters", func(t *testing.T) {
+		endpoint, path := "http://127.0.0.1:4318/v1/traces", "/var/log/opwire/traces.json"
+		cfg := &Configuration{
+			Version: "0.0.1",
+			Tracing: &configTracing{
+				Otlp: &sectionOtlpExporter{ Endpoint: &endpoint, Headers: map[string]string{ "Authorization": "Bearer token" } },
+				File: &sectionFileExporter{ Path: &path },
+			},
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+		assert.True(t, cfg.GetTracing().GetEnabled())
+
+		// the path of the file exporter is mandatory
+		cfg.Tracing.File = &sectionFileExporter{}
+		result, err = validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
+
+	t.Run("access log with a custom template", func(t *testing.T) {
+		template := "{{.ClientIP}} {{.Method}} {{.Path}} {{.Status}}"
+		cfg := &Configuration{
+			Version: "0.0.1",
+			AccessLog: &configAccessLog{ Template: &template, OutputPaths: []string{ "/var/log/opwire/access.log" } },
+		}
+		result, err := validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.True(t, result.Valid())
+		assert.True(t, cfg.GetAccessLog().GetEnabled())
+		assert.Equal(t, "template", cfg.GetAccessLog().GetFormat())
+
+		xml := "xml"
+		cfg.AccessLog.Format = &xml
+		result, err = validator.Validate(cfg)
+		assert.Nil(t, err)
+		assert.False(t, result.Valid())
+	})
 }
@@ -5,7 +5,11 @@ import(
 	"fmt"
 	"bytes"
 	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"sync"
+	"sync/atomic"
 	"time"
 	loq "github.com/opwire/opwire-agent/lib/logging"
 	"github.com/opwire/opwire-agent/lib/utils"
@@ -13,6 +17,7 @@ import(
 
 const BLANK string = ""
 const MAIN_RESOURCE string = ":default-resource:"
+const DRAIN_POLLING_INTERVAL time.Duration = 50 * time.Millisecond
 
 type TimeSecond float64
 
@@ -20,11 +25,36 @@ type Executor struct {
 	newPipeChain func(logger *loq.Logger) (PipeChainRunner)
 	resources map[string]*CommandEntrypoint
 	logger *loq.Logger
+	running map[PipeChainRunner]*runningExecution
+	runningMutex sync.Mutex
+	observer ExecutionObserver
+	tracer ExecutionTracer
 }
 
 type ExecutorOptions struct {
 	DefaultCommand *CommandDescriptor
 	Logger *loq.Logger
+	Observer ExecutionObserver
+	Tracer ExecutionTracer
+}
+
+// ExecutionObserver is notified of the lifecycle of the executions (e.g. to collect metrics),
+// its methods are called synchronously and must not block.
+type ExecutionObserver interface {
+	ExecutionStarted(resourceName string, methodName string)
+	ExecutionFinished(resourceName string, methodName string, state *ExecutionState, err error)
+	ExecutionSignalled(resourceName string, methodName string, sig os.Signal, processes int)
+}
+
+// ExecutionTracer records a span for each process of the executions, the span is a child of
+// the current span of ctx and its context is passed to the process.
+type ExecutionTracer interface {
+	TraceProcess(ctx context.Context, index int, cmd *exec.Cmd) func(err error)
+}
+
+type runningExecution struct {
+	resourceName string
+	methodName string
 }
 
 type CommandEntrypoint struct {
@@ -34,12 +64,111 @@ type CommandEntrypoint struct {
 	Pattern *string `json:"pattern"`
 	Settings map[string]interface{} `json:"settings"`
 	SettingsFormat *string `json:"settings-format"`
+	Cache *CommandCache `json:"cache"`
+	CacheControl *string `json:"cache-control"`
+	ConcurrentLimit *CommandConcurrentLimit `json:"concurrent-limit"`
+	SingleFlight *CommandSingleFlight `json:"single-flight"`
+	CircuitBreaker *CommandCircuitBreaker `json:"circuit-breaker"`
+	RateLimit *CommandRateLimit `json:"rate-limit"`
+	ClientCert *CommandClientCert `json:"client-cert"`
+	Auth *CommandAuth `json:"auth"`
+	Signature *CommandSignature `json:"signature"`
+	Access *CommandAccess `json:"access"`
+	Locks []string `json:"locks"`
+	LockTimeout *string `json:"lock-timeout"`
 	settingsEnvs []string
 }
 
+type CommandCache struct {
+	Enabled *bool `json:"enabled"`
+	Ttl *string `json:"ttl"`
+	MaxEntries *int `json:"max-entries"`
+	MaxBytes *int `json:"max-bytes"`
+	ByMethod *bool `json:"by-method"`
+	ByPath *bool `json:"by-path"`
+	ByHeaders *string `json:"by-headers"`
+	ByQueries *string `json:"by-queries"`
+	ByBody *bool `json:"by-body"`
+}
+
+type CommandConcurrentLimit struct {
+	Enabled *bool `json:"enabled"`
+	Total *int `json:"total"`
+	MaxQueue *int `json:"max-queue"`
+	MaxWait *string `json:"max-wait"`
+}
+
+type CommandRateLimit struct {
+	Enabled *bool `json:"enabled"`
+	Rate *float64 `json:"rate"`
+	Burst *int `json:"burst"`
+	By *string `json:"by"`
+	Header *string `json:"header"`
+}
+
+type CommandCircuitBreaker struct {
+	Enabled *bool `json:"enabled"`
+	FailureThreshold *int `json:"failure-threshold"`
+	Window *string `json:"window"`
+	CoolDown *string `json:"cool-down"`
+	HalfOpenTrials *int `json:"half-open-trials"`
+}
+
+type CommandAuth struct {
+	Enabled *bool `json:"enabled"`
+	Providers []string `json:"providers"`
+}
+
+type CommandSignature struct {
+	Enabled *bool `json:"enabled"`
+	Header *string `json:"header"`
+	Algorithm *string `json:"algorithm"`
+	Secret *string `json:"secret"`
+	SecretFile *string `json:"secret-file"`
+	SecretEnv *string `json:"secret-env"`
+	Prefix *string `json:"prefix"`
+	Encoding *string `json:"encoding"`
+	SignatureKey *string `json:"signature-key"`
+	TimestampHeader *string `json:"timestamp-header"`
+	TimestampKey *string `json:"timestamp-key"`
+	Tolerance *string `json:"tolerance"`
+	Payload *string `json:"payload"`
+}
+
+type CommandClientCert struct {
+	Enabled *bool `json:"enabled"`
+	Subjects []string `json:"subjects"`
+	SANs []string `json:"sans"`
+}
+
+type CommandAccess struct {
+	Enabled *bool `json:"enabled"`
+	Allow []string `json:"allow"`
+	Deny []string `json:"deny"`
+}
+
+type CommandSingleFlight struct {
+	Enabled *bool `json:"enabled"`
+	SingleFlightKey
+}
+
+// SingleFlightKey declares the dimensions of the single-flight key, it is shared by the global
+// single-flight section and the per-resource overrides.
+type SingleFlightKey struct {
+	ReqIdName *string `json:"req-id"`
+	ByMethod *bool `json:"by-method"`
+	ByPath *bool `json:"by-path"`
+	ByHeaders *string `json:"by-headers"`
+	ByQueries *string `json:"by-queries"`
+	ByBody *bool `json:"by-body"`
+	ByUserIP *bool `json:"by-userip"`
+}
+
 type CommandDescriptor struct {
 	CommandString string `json:"command"`
 	ExecutionTimeout TimeSecond `json:"timeout"`
+	ConcurrentLimit *CommandConcurrentLimit `json:"concurrent-limit"`
+	SingleFlight *CommandSingleFlight `json:"single-flight"`
 	subCommands []string
 }
 
@@ -51,16 +180,21 @@ type CommandInvocation struct {
 	MethodName string
 	RequestId string
 	ExecutionTimeout TimeSecond
+	SettingsEnvs []string
 }
 
 type ExecutionState struct {
 	IsTimeout bool
 	Duration time.Duration
+	StdoutBytes int64
+	StderrBytes int64
 }
 
 type PipeChainRunner interface {
 	Run(ib io.Reader, ob io.Writer, eb io.Writer, chain ...*exec.Cmd) error
 	Stop()
+	Signal(sig os.Signal) int
+	SetProcessHook(hook ProcessHook)
 }
 
 func NewExecutor(opts *ExecutorOptions) (e *Executor, err error) {
@@ -68,6 +202,8 @@ func NewExecutor(opts *ExecutorOptions) (e *Executor, err error) {
 	var defaultCommand *CommandDescriptor
 	if opts != nil {
 		e.logger = opts.Logger
+		e.observer = opts.Observer
+		e.tracer = opts.Tracer
 		defaultCommand = opts.DefaultCommand
 	}
 	if e.logger == nil {
@@ -84,6 +220,425 @@ func NewExecutor(opts *ExecutorOptions) (e *Executor, err error) {
 	return e, nil
 }
 
+func (c *CommandEntrypoint) GetCacheControl() string {
+	if c.CacheControl == nil {
+		return ""
+	}
+	return *c.CacheControl
+}
+
+func (c *CommandEntrypoint) GetLockTimeout() (time.Duration, error) {
+	if c.LockTimeout != nil {
+		return time.ParseDuration(*c.LockTimeout)
+	}
+	return 0, nil
+}
+
+func (c *CommandEntrypoint) GetConcurrentLimit() *CommandConcurrentLimit {
+	if c.ConcurrentLimit == nil {
+		return &CommandConcurrentLimit{}
+	}
+	return c.ConcurrentLimit
+}
+
+func (c *CommandDescriptor) GetConcurrentLimit() *CommandConcurrentLimit {
+	if c.ConcurrentLimit == nil {
+		return &CommandConcurrentLimit{}
+	}
+	return c.ConcurrentLimit
+}
+
+func (c *CommandConcurrentLimit) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.Total != nil
+	}
+	return *c.Enabled
+}
+
+func (c *CommandConcurrentLimit) GetTotal() int {
+	if c.Total == nil {
+		return 0
+	}
+	return *c.Total
+}
+
+// GetMaxQueue() returns -1 when the queue length is unlimited.
+func (c *CommandConcurrentLimit) GetMaxQueue() int {
+	if c.MaxQueue == nil {
+		return -1
+	}
+	return *c.MaxQueue
+}
+
+func (c *CommandConcurrentLimit) GetMaxWait() (time.Duration, error) {
+	if c.MaxWait != nil {
+		return time.ParseDuration(*c.MaxWait)
+	}
+	return 0, nil
+}
+
+func (c *CommandRateLimit) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.Rate != nil
+	}
+	return *c.Enabled
+}
+
+func (c *CommandRateLimit) GetRate() float64 {
+	if c.Rate == nil {
+		return 0
+	}
+	return *c.Rate
+}
+
+func (c *CommandRateLimit) GetBurst() int {
+	if c.Burst == nil {
+		return 0
+	}
+	return *c.Burst
+}
+
+func (c *CommandRateLimit) GetBy() string {
+	if c.By == nil {
+		return ""
+	}
+	return *c.By
+}
+
+func (c *CommandRateLimit) GetHeader() string {
+	if c.Header == nil {
+		return ""
+	}
+	return *c.Header
+}
+
+// GetEnabled() returns true by default, a declared circuit breaker is enabled unless it is disabled explicitly
+func (c *CommandCircuitBreaker) GetEnabled() bool {
+	if c.Enabled == nil {
+		return true
+	}
+	return *c.Enabled
+}
+
+func (c *CommandCircuitBreaker) GetFailureThreshold() int {
+	if c.FailureThreshold == nil {
+		return 0
+	}
+	return *c.FailureThreshold
+}
+
+func (c *CommandCircuitBreaker) GetWindow() (time.Duration, error) {
+	if c.Window != nil {
+		return time.ParseDuration(*c.Window)
+	}
+	return 0, nil
+}
+
+func (c *CommandCircuitBreaker) GetCoolDown() (time.Duration, error) {
+	if c.CoolDown != nil {
+		return time.ParseDuration(*c.CoolDown)
+	}
+	return 0, nil
+}
+
+func (c *CommandCircuitBreaker) GetHalfOpenTrials() int {
+	if c.HalfOpenTrials == nil {
+		return 0
+	}
+	return *c.HalfOpenTrials
+}
+
+// GetEnabled() returns true by default, a declared signature is verified unless it is disabled explicitly
+func (c *CommandSignature) GetEnabled() bool {
+	if c.Enabled == nil {
+		return true
+	}
+	return *c.Enabled
+}
+
+func (c *CommandSignature) GetHeader() string {
+	if c.Header == nil {
+		return ""
+	}
+	return *c.Header
+}
+
+func (c *CommandSignature) GetAlgorithm() string {
+	if c.Algorithm == nil {
+		return ""
+	}
+	return *c.Algorithm
+}
+
+func (c *CommandSignature) GetSecret() string {
+	if c.Secret == nil {
+		return ""
+	}
+	return *c.Secret
+}
+
+func (c *CommandSignature) GetSecretFile() string {
+	if c.SecretFile == nil {
+		return ""
+	}
+	return *c.SecretFile
+}
+
+func (c *CommandSignature) GetSecretEnv() string {
+	if c.SecretEnv == nil {
+		return ""
+	}
+	return *c.SecretEnv
+}
+
+func (c *CommandSignature) GetPrefix() string {
+	if c.Prefix == nil {
+		return ""
+	}
+	return *c.Prefix
+}
+
+func (c *CommandSignature) GetEncoding() string {
+	if c.Encoding == nil {
+		return ""
+	}
+	return *c.Encoding
+}
+
+func (c *CommandSignature) GetSignatureKey() string {
+	if c.SignatureKey == nil {
+		return ""
+	}
+	return *c.SignatureKey
+}
+
+func (c *CommandSignature) GetTimestampHeader() string {
+	if c.TimestampHeader == nil {
+		return ""
+	}
+	return *c.TimestampHeader
+}
+
+func (c *CommandSignature) GetTimestampKey() string {
+	if c.TimestampKey == nil {
+		return ""
+	}
+	return *c.TimestampKey
+}
+
+func (c *CommandSignature) GetTolerance() (time.Duration, error) {
+	if c.Tolerance != nil {
+		return time.ParseDuration(*c.Tolerance)
+	}
+	return 0, nil
+}
+
+func (c *CommandSignature) GetPayload() string {
+	if c.Payload == nil {
+		return ""
+	}
+	return *c.Payload
+}
+
+// GetEnabled() returns true by default, a declared allowlist is enabled unless it is disabled explicitly
+func (c *CommandClientCert) GetEnabled() bool {
+	if c.Enabled == nil {
+		return true
+	}
+	return *c.Enabled
+}
+
+func (c *CommandClientCert) GetSubjects() []string {
+	if c.Subjects == nil {
+		return []string{}
+	}
+	return c.Subjects
+}
+
+func (c *CommandClientCert) GetSANs() []string {
+	if c.SANs == nil {
+		return []string{}
+	}
+	return c.SANs
+}
+
+// GetEnabled() returns true by default, declared access lists are enabled unless they are disabled explicitly
+func (c *CommandAccess) GetEnabled() bool {
+	if c.Enabled == nil {
+		return true
+	}
+	return *c.Enabled
+}
+
+func (c *CommandAccess) GetAllow() []string {
+	if c.Allow == nil {
+		return []string{}
+	}
+	return c.Allow
+}
+
+func (c *CommandAccess) GetDeny() []string {
+	if c.Deny == nil {
+		return []string{}
+	}
+	return c.Deny
+}
+
+// GetEnabled() returns true by default, a declared override is enabled unless it is disabled explicitly
+func (c *CommandSingleFlight) GetEnabled() bool {
+	if c.Enabled == nil {
+		return true
+	}
+	return *c.Enabled
+}
+
+func (c *SingleFlightKey) GetReqIdName() string {
+	if c.ReqIdName == nil {
+		return ""
+	}
+	return *c.ReqIdName
+}
+
+func (c *SingleFlightKey) GetByMethod() bool {
+	if c.ByMethod == nil {
+		if c.ReqIdName != nil && len(*c.ReqIdName) > 0 {
+			return false
+		}
+		if c.ByPath != nil && *c.ByPath {
+			return true
+		}
+		if c.ByUserIP != nil && *c.ByUserIP {
+			return true
+		}
+		return false
+	}
+	return *c.ByMethod
+}
+
+func (c *SingleFlightKey) GetByPath() bool {
+	if c.ByPath == nil {
+		if c.ReqIdName != nil && len(*c.ReqIdName) > 0 {
+			return false
+		}
+		if c.ByMethod != nil && *c.ByMethod {
+			return true
+		}
+		if c.ByUserIP != nil && *c.ByUserIP {
+			return true
+		}
+		return false
+	}
+	return *c.ByPath
+}
+
+func (c *SingleFlightKey) GetByHeaders() []string {
+	if c.ByHeaders == nil {
+		return []string{}
+	}
+	return utils.Split(*c.ByHeaders, ",")
+}
+
+func (c *SingleFlightKey) GetByQueries() []string {
+	if c.ByQueries == nil {
+		return []string{}
+	}
+	return utils.Split(*c.ByQueries, ",")
+}
+
+func (c *SingleFlightKey) GetByBody() bool {
+	if c.ByBody == nil {
+		return false
+	}
+	return *c.ByBody
+}
+
+func (c *SingleFlightKey) GetByUserIP() bool {
+	if c.ByUserIP == nil {
+		if c.ReqIdName != nil && len(*c.ReqIdName) > 0 {
+			return false
+		}
+		if c.ByMethod != nil && *c.ByMethod {
+			return true
+		}
+		if c.ByPath != nil && *c.ByPath {
+			return true
+		}
+		return false
+	}
+	return *c.ByUserIP
+}
+
+func (c *CommandEntrypoint) GetCache() *CommandCache {
+	if c.Cache == nil {
+		return &CommandCache{}
+	}
+	return c.Cache
+}
+
+func (c *CommandCache) GetEnabled() bool {
+	if c.Enabled == nil {
+		return c.Ttl != nil
+	}
+	return *c.Enabled
+}
+
+func (c *CommandCache) GetTtl() (time.Duration, error) {
+	if c.Ttl != nil {
+		return time.ParseDuration(*c.Ttl)
+	}
+	return 0, nil
+}
+
+func (c *CommandCache) GetMaxEntries() int {
+	if c.MaxEntries == nil {
+		return 0
+	}
+	return *c.MaxEntries
+}
+
+func (c *CommandCache) GetMaxBytes() int {
+	if c.MaxBytes == nil {
+		return 0
+	}
+	return *c.MaxBytes
+}
+
+func (c *CommandCache) GetByMethod() bool {
+	if c.ByMethod == nil {
+		return true
+	}
+	return *c.ByMethod
+}
+
+func (c *CommandCache) GetByPath() bool {
+	if c.ByPath == nil {
+		return true
+	}
+	return *c.ByPath
+}
+
+func (c *CommandCache) GetByHeaders() []string {
+	if c.ByHeaders == nil {
+		return []string{}
+	}
+	return utils.Split(*c.ByHeaders, ",")
+}
+
+// GetByQueries() returns nil when the queries are not specified, it means all of queries are used
+func (c *CommandCache) GetByQueries() []string {
+	if c.ByQueries == nil {
+		return nil
+	}
+	return utils.Split(*c.ByQueries, ",")
+}
+
+func (c *CommandCache) GetByBody() bool {
+	if c.ByBody == nil {
+		return false
+	}
+	return *c.ByBody
+}
+
 func GetExecutionTimeout(cd *CommandDescriptor, ci *CommandInvocation) TimeSecond {
 	var timeout TimeSecond
 	if cd != nil && cd.ExecutionTimeout > 0 {
@@ -187,6 +742,62 @@ func (e *Executor) StoreSettings(prefix string, settings map[string]interface{},
 	return err
 }
 
+// Running() returns the number of the executions in progress.
+func (e *Executor) Running() int {
+	e.runningMutex.Lock()
+	defer e.runningMutex.Unlock()
+	return len(e.running)
+}
+
+// Drain() waits for the executions in progress until ctx is done, it returns the number of
+// the remaining executions.
+func (e *Executor) Drain(ctx context.Context) int {
+	ticker := time.NewTicker(DRAIN_POLLING_INTERVAL)
+	defer ticker.Stop()
+	for {
+		remaining := e.Running()
+		if remaining == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return remaining
+		case <-ticker.C:
+		}
+	}
+}
+
+// Signal() sends the signal to the process groups of the executions in progress, it returns
+// the number of the signalled processes.
+func (e *Executor) Signal(sig os.Signal) int {
+	e.runningMutex.Lock()
+	defer e.runningMutex.Unlock()
+	count := 0
+	for pipeChain, execution := range e.running {
+		processes := pipeChain.Signal(sig)
+		if e.observer != nil {
+			e.observer.ExecutionSignalled(execution.resourceName, execution.methodName, sig, processes)
+		}
+		count += processes
+	}
+	return count
+}
+
+func (e *Executor) track(pipeChain PipeChainRunner, execution *runningExecution) {
+	e.runningMutex.Lock()
+	defer e.runningMutex.Unlock()
+	if e.running == nil {
+		e.running = make(map[PipeChainRunner]*runningExecution)
+	}
+	e.running[pipeChain] = execution
+}
+
+func (e *Executor) untrack(pipeChain PipeChainRunner) {
+	e.runningMutex.Lock()
+	defer e.runningMutex.Unlock()
+	delete(e.running, pipeChain)
+}
+
 func (e *Executor) RunOnRawData(opts *CommandInvocation, inData []byte) ([]byte, []byte, *ExecutionState, error) {
 	ib := bytes.NewBuffer(inData)
 	var ob bytes.Buffer
@@ -221,9 +832,48 @@ func (e *Executor) Run(ib io.Reader, opts *CommandInvocation, ob io.Writer, eb i
 				}
 			}
 
+			execution := &runningExecution{ resourceName: getResourceName(opts) }
+			if opts != nil {
+				execution.methodName = opts.MethodName
+			}
+
 			state := &ExecutionState{}
 			constructor := e.GetNewPipeChain()
 			pipeChain := constructor(runLogger)
+			e.track(pipeChain, execution)
+			defer e.untrack(pipeChain)
+
+			if e.tracer != nil {
+				traceCtx := context.Background()
+				if opts != nil && opts.Context != nil {
+					traceCtx = opts.Context
+				}
+				pipeChain.SetProcessHook(func(index int, cmd *exec.Cmd) func(err error) {
+					return e.tracer.TraceProcess(traceCtx, index, cmd)
+				})
+			}
+
+			// a combined output keeps a single writer, so that both streams share one pipe
+			stdout := &countingWriter{ w: ob }
+			stderr := stdout
+			if eb != ob {
+				stderr = &countingWriter{ w: eb }
+			}
+			ob, eb = stdout, stderr
+
+			if e.observer != nil {
+				e.observer.ExecutionStarted(execution.resourceName, execution.methodName)
+			}
+			finish := func(err error) (*ExecutionState, error) {
+				state.StdoutBytes = atomic.LoadInt64(&stdout.count)
+				if stderr != stdout {
+					state.StderrBytes = atomic.LoadInt64(&stderr.count)
+				}
+				if e.observer != nil {
+					e.observer.ExecutionFinished(execution.resourceName, execution.methodName, state, err)
+				}
+				return state, err
+			}
 
 			timeout := GetExecutionTimeout(descriptor, opts)
 
@@ -251,10 +901,10 @@ func (e *Executor) Run(ib io.Reader, opts *CommandInvocation, ob io.Writer, eb i
 					err := <-c
 					state.IsTimeout = true
 					state.Duration = time.Since(startTime)
-					return state, err
+					return finish(err)
 				case err := <-c:
 					state.Duration = time.Since(startTime)
-					return state, err
+					return finish(err)
 				}
 			}
 
@@ -276,7 +926,7 @@ func (e *Executor) Run(ib io.Reader, opts *CommandInvocation, ob io.Writer, eb i
 
 			state.Duration = time.Since(startTime)
 
-			return state, err
+			return finish(err)
 		} else {
 			return nil, err
 		}
@@ -339,6 +989,10 @@ func (e *Executor) buildEnvs(opts *CommandInvocation) []string {
 	envs := make([]string, len(opts.Envs))
 	copy(envs, opts.Envs)
 
+	if opts.SettingsEnvs != nil {
+		return append(envs, opts.SettingsEnvs...)
+	}
+
 	resourceName := getResourceName(opts)
 	if entrypoint, ok := e.resources[resourceName]; ok {
 		settings := entrypoint.settingsEnvs
@@ -358,6 +1012,40 @@ func getResourceName(opts *CommandInvocation) (string) {
 	return resourceName
 }
 
+// countingWriter counts the bytes written by the processes, a nil writer discards them. The
+// processes of a chain share the stderr writer, so the counter is updated atomically.
+type countingWriter struct {
+	w io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.w == nil {
+		atomic.AddInt64(&c.count, int64(len(p)))
+		return len(p), nil
+	}
+	n, err := c.w.Write(p)
+	atomic.AddInt64(&c.count, int64(n))
+	return n, err
+}
+
+// ReadFrom() lets the copy of the process output reach the ReaderFrom of the wrapped writer
+// (e.g. a bytes.Buffer), as it does without the counter.
+func (c *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	var err error
+	switch w := c.w.(type) {
+	case nil:
+		n, err = io.Copy(ioutil.Discard, r)
+	case io.ReaderFrom:
+		n, err = w.ReadFrom(r)
+	default:
+		n, err = io.Copy(struct{ io.Writer }{ w }, r)
+	}
+	atomic.AddInt64(&c.count, n)
+	return n, err
+}
+
 func runCommand(ib io.Reader, ob io.Writer, eb io.Writer, cmdObject *exec.Cmd) error {
 	cmdObject.Stdin = ib
 	cmdObject.Stdout = ob
@@ -1,8 +1,12 @@
 package invokers
 
 import(
+	"context"
 	"fmt"
+	"os"
+	"syscall"
 	"testing"
+	"time"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -38,3 +42,75 @@ func TestExecutor_Run(t *testing.T) {
 		assert.True(t, state.Duration.Seconds() > 0)
 	})
 }
+
+func TestExecutor_Drain(t *testing.T) {
+	e, _ := NewExecutor(&ExecutorOptions{
+		DefaultCommand: &CommandDescriptor{
+			CommandString: "sleep 5",
+		},
+	})
+	assert.Equal(t, 0, e.Running())
+	assert.Equal(t, 0, e.Drain(context.Background()))
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := e.RunOnRawData(nil, nil)
+		done <- err
+	}()
+	for i := 0; i < 100 && e.Running() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, e.Running())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+	defer cancel()
+	assert.Equal(t, 1, e.Drain(ctx))
+
+	// the remaining process is terminated through its process group
+	assert.Equal(t, 1, e.Signal(syscall.SIGTERM))
+	select {
+	case err := <-done:
+		assert.NotNil(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the execution is not terminated")
+	}
+	assert.Equal(t, 0, e.Drain(context.Background()))
+}
+
+func TestExecutor_Observer(t *testing.T) {
+	observer := &ExecutionObserverTest{}
+	e, _ := NewExecutor(&ExecutorOptions{ Observer: observer })
+	e.Register(&CommandDescriptor{ CommandString: "sh -c 'printf hello; printf oops >&2'" }, "echo")
+	e.Register(&CommandDescriptor{ CommandString: "opwire-command-not-found" }, "missing")
+
+	outBytes, errBytes, state, err := e.RunOnRawData(&CommandInvocation{ ResourceName: "echo", MethodName: "GET" }, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), outBytes)
+	assert.Equal(t, []byte("oops"), errBytes)
+	assert.Equal(t, int64(5), state.StdoutBytes)
+	assert.Equal(t, int64(4), state.StderrBytes)
+	assert.Equal(t, []string{ "started:echo/GET", "finished:echo/GET" }, observer.events)
+
+	_, _, _, err = e.RunOnRawData(&CommandInvocation{ ResourceName: "missing" }, nil)
+	_, spawnFailed := err.(*SpawnError)
+	assert.True(t, spawnFailed)
+	assert.Equal(t, observer.lastErr, err)
+}
+
+type ExecutionObserverTest struct {
+	events []string
+	lastErr error
+}
+
+func (o *ExecutionObserverTest) ExecutionStarted(resourceName string, methodName string) {
+	o.events = append(o.events, "started:" + resourceName + "/" + methodName)
+}
+
+func (o *ExecutionObserverTest) ExecutionFinished(resourceName string, methodName string, state *ExecutionState, err error) {
+	o.events = append(o.events, "finished:" + resourceName + "/" + methodName)
+	o.lastErr = err
+}
+
+func (o *ExecutionObserverTest) ExecutionSignalled(resourceName string, methodName string, sig os.Signal, processes int) {
+	o.events = append(o.events, "signalled:" + resourceName + "/" + methodName)
+}
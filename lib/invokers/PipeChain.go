@@ -3,14 +3,34 @@ package invokers
 import (
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"sync"
 	loq "github.com/opwire/opwire-agent/lib/logging"
 )
 
+// SpawnError is returned when a process of the chain cannot be started.
+type SpawnError struct {
+	Err error
+}
+
+func (e *SpawnError) Error() string {
+	return e.Err.Error()
+}
+
+// ProcessHook is called before a process of the chain is started, the returned function is
+// called with the error of the process when it is finished (or could not be started).
+type ProcessHook func(index int, cmd *exec.Cmd) func(err error)
+
 type PipeChain struct {
 	logger *loq.Logger
 	stopChan chan int
 	stopFlag bool
+	chain []*exec.Cmd
+	running []*exec.Cmd
+	runningMutex sync.Mutex
+	hook ProcessHook
+	hooked map[*exec.Cmd]func(err error)
 }
 
 func NewPipeChain(logger *loq.Logger) *PipeChain {
@@ -36,21 +56,28 @@ func (p *PipeChain) Run(ib io.Reader, ob io.Writer, eb io.Writer, chain ...*exec
 	}
 	chain[i].Stdout = ob
 	chain[i].Stderr = eb
+	for _, cmd := range chain {
+		setProcessGroup(cmd)
+	}
 
-	p.stopChan = make(chan int)
+	stopChan := make(chan int)
+	p.runningMutex.Lock()
+	p.stopChan = stopChan
+	p.runningMutex.Unlock()
 	p.stopFlag = false
+	p.chain = chain
 
 	defer p.closeChannel()
 
 	go func() {
-		sign := <- p.stopChan
+		sign := <- stopChan
 		if sign != 0 {
 			p.stopFlag = true
 			for idx, cmd := range chain {
 				if cmd != nil && cmd.Process != nil {
 					if cmd.ProcessState == nil {
 						p.logger.Log(loq.InfoLevel, fmt.Sprintf("Pipe[%d] - Process[%d] is running, kill it now", idx, cmd.Process.Pid))
-						procErr := cmd.Process.Kill()
+						procErr := signalProcessGroup(cmd.Process, os.Kill)
 						if procErr != nil {
 							p.logger.Log(loq.ErrorLevel, fmt.Sprintf("Pipe[%d] - Process[%d]: Kill() failed %s", idx, cmd.Process.Pid, procErr))
 						}
@@ -72,33 +99,111 @@ func (p *PipeChain) Run(ib io.Reader, ob io.Writer, eb io.Writer, chain ...*exec
 }
 
 func (p *PipeChain) Stop() {
-	if p.stopChan != nil {
-		p.stopChan <- 1
+	if stopChan := p.takeChannel(); stopChan != nil {
+		stopChan <- 1
+		close(stopChan)
+	}
+}
+
+// Signal() sends the signal to the process groups of the running processes, it returns the
+// number of the signalled processes.
+func (p *PipeChain) Signal(sig os.Signal) int {
+	p.runningMutex.Lock()
+	defer p.runningMutex.Unlock()
+	count := 0
+	for idx, cmd := range p.running {
+		if err := signalProcessGroup(cmd.Process, sig); err != nil {
+			p.logger.Log(loq.ErrorLevel, fmt.Sprintf("Pipe[%d] - Process[%d]: Signal() failed %s", idx, cmd.Process.Pid, err))
+			continue
+		}
+		count++
 	}
-	p.closeChannel()
+	return count
+}
+
+// SetProcessHook() registers the hook of the processes, it must be called before Run().
+func (p *PipeChain) SetProcessHook(hook ProcessHook) {
+	p.hook = hook
+}
+
+func (p *PipeChain) start(cmd *exec.Cmd) error {
+	var done func(err error)
+	if p.hook != nil {
+		done = p.hook(p.indexOf(cmd), cmd)
+	}
+	p.runningMutex.Lock()
+	defer p.runningMutex.Unlock()
+	if err := cmd.Start(); err != nil {
+		spawnErr := &SpawnError{ Err: err }
+		if done != nil {
+			done(spawnErr)
+		}
+		return spawnErr
+	}
+	p.running = append(p.running, cmd)
+	if done != nil {
+		if p.hooked == nil {
+			p.hooked = make(map[*exec.Cmd]func(err error))
+		}
+		p.hooked[cmd] = done
+	}
+	return nil
+}
+
+func (p *PipeChain) finish(cmd *exec.Cmd, err error) {
+	p.runningMutex.Lock()
+	defer p.runningMutex.Unlock()
+	for i, item := range p.running {
+		if item == cmd {
+			p.running = append(p.running[:i], p.running[i+1:]...)
+			break
+		}
+	}
+	if done, ok := p.hooked[cmd]; ok {
+		delete(p.hooked, cmd)
+		done(err)
+	}
+}
+
+func (p *PipeChain) indexOf(cmd *exec.Cmd) int {
+	for i, item := range p.chain {
+		if item == cmd {
+			return i
+		}
+	}
+	return -1
 }
 
 func (p *PipeChain) closeChannel() {
-	if p.stopChan != nil {
-		close(p.stopChan)
-		p.stopChan = nil
+	if stopChan := p.takeChannel(); stopChan != nil {
+		close(stopChan)
 	}
 }
 
+// takeChannel() detaches the stop channel, so that it is signalled or closed only once.
+func (p *PipeChain) takeChannel() chan int {
+	p.runningMutex.Lock()
+	defer p.runningMutex.Unlock()
+	stopChan := p.stopChan
+	p.stopChan = nil
+	return stopChan
+}
+
 func (p *PipeChain) next(chain []*exec.Cmd, pipes []*io.PipeWriter) error {
 	if chain[0].Process == nil {
-		if err := chain[0].Start(); err != nil {
+		if err := p.start(chain[0]); err != nil {
 			return err
 		}
 	}
 	if len(chain) > 1 {
 		if chain[1].Process == nil {
-			if err := chain[1].Start(); err != nil {
+			if err := p.start(chain[1]); err != nil {
 				return err
 			}
 		}
 	}
 	err := chain[0].Wait()
+	p.finish(chain[0], err)
 	if len(chain) > 1 {
 		pipes[0].Close()
 		if err == nil && !p.stopFlag {
@@ -0,0 +1,25 @@
+// +build !plan9,!windows
+
+package invokers
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup() starts the command in its own process group, so that the signals reach
+// the sub-processes of the command (e.g. the children of a shell script).
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func signalProcessGroup(process *os.Process, sig os.Signal) error {
+	if signal, ok := sig.(syscall.Signal); ok {
+		return syscall.Kill(-process.Pid, signal)
+	}
+	return process.Signal(sig)
+}
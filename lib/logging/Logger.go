@@ -191,3 +191,9 @@ func (l *Logger) Sync() error {
 	l.assertReady()
 	return l.zapLogger.Sync()
 }
+
+// OpenOutputs() opens the output paths ("stdout", "stderr" or the files) as a single locked
+// writer, the returned function closes them.
+func OpenOutputs(paths ...string) (zapcore.WriteSyncer, func(), error) {
+	return zap.Open(paths...)
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"net"
+	"net/http"
+)
+
+// AccessList filters the requests by the client IP address (resolved from the trusted
+// proxies). The deny list takes precedence, an empty allow list allows the other addresses.
+type AccessList struct {
+	allow []*net.IPNet
+	deny []*net.IPNet
+}
+
+type AccessListOptions interface {
+	GetAllow() []string
+	GetDeny() []string
+}
+
+func NewAccessList(opts AccessListOptions) (*AccessList, error) {
+	a := new(AccessList)
+	for _, item := range opts.GetAllow() {
+		ipnet, err := parseIPNet(item)
+		if err != nil {
+			return nil, err
+		}
+		a.allow = append(a.allow, ipnet)
+	}
+	for _, item := range opts.GetDeny() {
+		ipnet, err := parseIPNet(item)
+		if err != nil {
+			return nil, err
+		}
+		a.deny = append(a.deny, ipnet)
+	}
+	return a, nil
+}
+
+// Allow() returns false when the IP address is unknown.
+func (a *AccessList) Allow(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if containsIP(a.deny, ip) {
+		return false
+	}
+	return len(a.allow) == 0 || containsIP(a.allow, ip)
+}
+
+// AllowRequest() checks the client IP of the request against the access lists, the nil lists
+// are skipped.
+func AllowRequest(r *http.Request, lists ...*AccessList) (net.IP, bool) {
+	ip, _ := extractUserIP(r)
+	for _, list := range lists {
+		if list != nil && !list.Allow(ip) {
+			return ip, false
+		}
+	}
+	return ip, true
+}
+
+func containsIP(ipnets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range ipnets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
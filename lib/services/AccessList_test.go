@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessList_Allow(t *testing.T) {
+	t.Run("deny list takes precedence over the allow list", func(t *testing.T) {
+		a, err := NewAccessList(&AccessListOptionsTest{
+			Allow: []string{ "10.0.0.0/8" },
+			Deny: []string{ "10.0.13.0/24" },
+		})
+		assert.Nil(t, err)
+		assert.True(t, a.Allow(net.ParseIP("10.1.2.3")))
+		assert.False(t, a.Allow(net.ParseIP("10.0.13.7")))
+		assert.False(t, a.Allow(net.ParseIP("192.168.1.1")))
+		assert.False(t, a.Allow(nil))
+	})
+	t.Run("empty allow list allows all but the denied addresses", func(t *testing.T) {
+		a, err := NewAccessList(&AccessListOptionsTest{ Deny: []string{ "203.0.113.5", "2001:db8::/32" } })
+		assert.Nil(t, err)
+		assert.True(t, a.Allow(net.ParseIP("198.51.100.1")))
+		assert.False(t, a.Allow(net.ParseIP("203.0.113.5")))
+		assert.False(t, a.Allow(net.ParseIP("2001:db8::17")))
+	})
+	t.Run("loopback addresses only", func(t *testing.T) {
+		a, err := NewAccessList(&AccessListOptionsTest{ Allow: []string{ "127.0.0.1", "::1" } })
+		assert.Nil(t, err)
+		assert.True(t, a.Allow(net.ParseIP("127.0.0.1")))
+		assert.True(t, a.Allow(net.ParseIP("::ffff:127.0.0.1")))
+		assert.True(t, a.Allow(net.ParseIP("::1")))
+		assert.False(t, a.Allow(net.ParseIP("10.0.0.1")))
+	})
+	t.Run("malformed entries are refused", func(t *testing.T) {
+		_, err := NewAccessList(&AccessListOptionsTest{ Allow: []string{ "10.0.0.0/33" } })
+		assert.NotNil(t, err)
+		_, err = NewAccessList(&AccessListOptionsTest{ Deny: []string{ "localhost" } })
+		assert.NotNil(t, err)
+	})
+}
+
+func TestAllowRequest(t *testing.T) {
+	global, _ := NewAccessList(&AccessListOptionsTest{ Deny: []string{ "198.51.100.0/24" } })
+	local, _ := NewAccessList(&AccessListOptionsTest{ Allow: []string{ "127.0.0.1" } })
+
+	r := httptest.NewRequest("GET", "/_/lock", nil)
+	r.RemoteAddr = "127.0.0.1:4000"
+	_, ok := AllowRequest(r, global, local)
+	assert.True(t, ok)
+	_, ok = AllowRequest(r, nil, nil)
+	assert.True(t, ok)
+
+	// the client IP resolved from the trusted proxies is checked instead of the remote address
+	r = r.WithContext(context.WithValue(r.Context(), clientIPContextKey{}, net.ParseIP("198.51.100.9")))
+	ip, ok := AllowRequest(r, global, nil)
+	assert.False(t, ok)
+	assert.Equal(t, "198.51.100.9", ip.String())
+}
+
+type AccessListOptionsTest struct {
+	Allow []string
+	Deny []string
+}
+
+func (o *AccessListOptionsTest) GetAllow() []string {
+	return o.Allow
+}
+
+func (o *AccessListOptionsTest) GetDeny() []string {
+	return o.Deny
+}
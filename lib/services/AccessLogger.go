@@ -0,0 +1,257 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"github.com/opwire/opwire-agent/lib/invokers"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+// AccessLogEntry describes a request of a resource, ExitCode is -1 when no command has been
+// executed (e.g. a rejected request or a cached response).
+type AccessLogEntry struct {
+	Time time.Time
+	ClientIP string
+	Principal string
+	Method string
+	Path string
+	Protocol string
+	Resource string
+	Status int
+	ExitCode int
+	BytesIn int64
+	BytesOut int64
+	Duration time.Duration
+	RequestId string
+	Shared bool
+	Referer string
+	UserAgent string
+}
+
+// Seconds() returns the duration in seconds, for the templates.
+func (e *AccessLogEntry) Seconds() float64 {
+	return e.Duration.Seconds()
+}
+
+type AccessLoggerOptions interface {
+	GetFormat() string
+	GetTemplate() string
+	GetOutputPaths() []string
+}
+
+// AccessLogger writes one line per request of the resources, in the Apache combined format
+// (extended with the fields of the agent), in JSON or with a text/template of AccessLogEntry.
+// All of its methods are no-op on a nil receiver.
+type AccessLogger struct {
+	format string
+	template *template.Template
+	output io.Writer
+	sync func() error
+	close func()
+	mutex sync.Mutex
+}
+
+func NewAccessLogger(opts AccessLoggerOptions) (*AccessLogger, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("AccessLoggerOptions must not be nil")
+	}
+	l := &AccessLogger{ format: opts.GetFormat() }
+	switch l.format {
+	case ACCESS_LOG_FORMAT_COMBINED, ACCESS_LOG_FORMAT_JSON:
+	case ACCESS_LOG_FORMAT_TEMPLATE:
+		text := opts.GetTemplate()
+		if len(text) == 0 {
+			return nil, fmt.Errorf("Access log template must not be empty")
+		}
+		tmpl, err := template.New("access-log").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		l.template = tmpl
+	default:
+		return nil, fmt.Errorf("Access log format [%s] is not supported", l.format)
+	}
+	output, closeOutput, err := loq.OpenOutputs(opts.GetOutputPaths()...)
+	if err != nil {
+		return nil, err
+	}
+	l.output = output
+	l.sync = output.Sync
+	l.close = closeOutput
+	return l, nil
+}
+
+// Log() writes the entry as a single line.
+func (l *AccessLogger) Log(entry *AccessLogEntry) error {
+	if l == nil || entry == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	switch l.format {
+	case ACCESS_LOG_FORMAT_JSON:
+		if err := json.NewEncoder(&buf).Encode(newJsonAccessLogEntry(entry)); err != nil {
+			return err
+		}
+	case ACCESS_LOG_FORMAT_TEMPLATE:
+		if err := l.template.Execute(&buf, entry); err != nil {
+			return err
+		}
+		buf.WriteString("\n")
+	default:
+		writeCombinedAccessLog(&buf, entry)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err := l.output.Write(buf.Bytes())
+	return err
+}
+
+func (l *AccessLogger) Sync() error {
+	if l == nil {
+		return nil
+	}
+	return l.sync()
+}
+
+func (l *AccessLogger) Close() {
+	if l == nil {
+		return
+	}
+	l.close()
+}
+
+func writeCombinedAccessLog(buf *bytes.Buffer, e *AccessLogEntry) {
+	bytesOut := "-"
+	if e.BytesOut > 0 {
+		bytesOut = strconv.FormatInt(e.BytesOut, 10)
+	}
+	exitCode := "-"
+	if e.ExitCode >= 0 {
+		exitCode = strconv.Itoa(e.ExitCode)
+	}
+	fmt.Fprintf(buf, "%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\" %s %s %d %.6f %s %t\n",
+		orDash(e.ClientIP),
+		orDash(e.Principal),
+		e.Time.Format(ACCESS_LOG_TIME_FORMAT),
+		e.Method,
+		combinedEscaper.Replace(e.Path),
+		e.Protocol,
+		e.Status,
+		bytesOut,
+		combinedEscaper.Replace(orDash(e.Referer)),
+		combinedEscaper.Replace(orDash(e.UserAgent)),
+		orDash(e.Resource),
+		exitCode,
+		e.BytesIn,
+		e.Duration.Seconds(),
+		orDash(e.RequestId),
+		e.Shared)
+}
+
+var combinedEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func orDash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+type jsonAccessLogEntry struct {
+	Time time.Time `json:"time"`
+	ClientIP string `json:"clientIP"`
+	Principal string `json:"principal,omitempty"`
+	Method string `json:"method"`
+	Path string `json:"path"`
+	Protocol string `json:"protocol"`
+	Resource string `json:"resource"`
+	Status int `json:"status"`
+	ExitCode *int `json:"exitCode"`
+	BytesIn int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+	Duration float64 `json:"duration"`
+	RequestId string `json:"requestId,omitempty"`
+	Shared bool `json:"shared"`
+	Referer string `json:"referer,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+func newJsonAccessLogEntry(e *AccessLogEntry) *jsonAccessLogEntry {
+	j := &jsonAccessLogEntry{
+		Time: e.Time,
+		ClientIP: e.ClientIP,
+		Principal: e.Principal,
+		Method: e.Method,
+		Path: e.Path,
+		Protocol: e.Protocol,
+		Resource: e.Resource,
+		Status: e.Status,
+		BytesIn: e.BytesIn,
+		BytesOut: e.BytesOut,
+		Duration: e.Duration.Seconds(),
+		RequestId: e.RequestId,
+		Shared: e.Shared,
+		Referer: e.Referer,
+		UserAgent: e.UserAgent,
+	}
+	if e.ExitCode >= 0 {
+		exitCode := e.ExitCode
+		j.ExitCode = &exitCode
+	}
+	return j
+}
+
+// commandExitCode() returns the exit code of an execution, -1 when the command has not been
+// executed or has been killed by a signal.
+func commandExitCode(state *invokers.ExecutionState, err error) int {
+	if state == nil {
+		return -1
+	}
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// countingReadCloser counts the bytes of the request body read by the agent, the body may be
+// copied to the stdin of a command by another goroutine.
+type countingReadCloser struct {
+	io.ReadCloser
+	count int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.count, int64(n))
+	return n, err
+}
+
+// requestBytesIn() returns the declared length of the request body, or the number of bytes
+// read when the length is unknown (chunked encoding).
+func requestBytesIn(r *http.Request, body *countingReadCloser) int64 {
+	if r.ContentLength >= 0 {
+		return r.ContentLength
+	}
+	if body != nil {
+		return atomic.LoadInt64(&body.count)
+	}
+	return 0
+}
+
+const ACCESS_LOG_FORMAT_COMBINED string = "combined"
+const ACCESS_LOG_FORMAT_JSON string = "json"
+const ACCESS_LOG_FORMAT_TEMPLATE string = "template"
+const ACCESS_LOG_TIME_FORMAT string = "02/Jan/2006:15:04:05 -0700"
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLogger_Log(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opwire-access-log")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	entry := &AccessLogEntry{
+		Time: time.Date(2019, time.March, 8, 10, 20, 30, 0, time.UTC),
+		ClientIP: "10.0.0.7",
+		Principal: "alice",
+		Method: "POST",
+		Path: "/$/reports?year=2019",
+		Protocol: "HTTP/1.1",
+		Resource: "reports",
+		Status: 200,
+		ExitCode: 0,
+		BytesIn: 12,
+		BytesOut: 345,
+		Duration: 1500 * time.Millisecond,
+		RequestId: "req-1",
+		Shared: true,
+		UserAgent: `curl/7.64 "beta"`,
+	}
+	rejected := &AccessLogEntry{
+		Time: entry.Time,
+		ClientIP: "10.0.0.8",
+		Method: "GET",
+		Path: "/$/reports",
+		Protocol: "HTTP/1.1",
+		Resource: "reports",
+		Status: 429,
+		ExitCode: -1,
+	}
+
+	t.Run("combined", func(t *testing.T) {
+		lines := writeAccessLog(t, filepath.Join(dir, "combined.log"), &AccessLoggerOptionsTest{ Format: ACCESS_LOG_FORMAT_COMBINED }, entry, rejected)
+		assert.Equal(t, []string{
+			`10.0.0.7 - alice [08/Mar/2019:10:20:30 +0000] "POST /$/reports?year=2019 HTTP/1.1" 200 345 "-" "curl/7.64 \"beta\"" reports 0 12 1.500000 req-1 true`,
+			`10.0.0.8 - - [08/Mar/2019:10:20:30 +0000] "GET /$/reports HTTP/1.1" 429 - "-" "-" reports - 0 0.000000 - false`,
+		}, lines)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		lines := writeAccessLog(t, filepath.Join(dir, "access.json"), &AccessLoggerOptionsTest{ Format: ACCESS_LOG_FORMAT_JSON }, entry, rejected)
+		if assert.Equal(t, 2, len(lines)) {
+			var first, second map[string]interface{}
+			assert.Nil(t, json.Unmarshal([]byte(lines[0]), &first))
+			assert.Nil(t, json.Unmarshal([]byte(lines[1]), &second))
+			assert.Equal(t, "alice", first["principal"])
+			assert.Equal(t, float64(0), first["exitCode"])
+			assert.Equal(t, float64(12), first["bytesIn"])
+			assert.Equal(t, float64(345), first["bytesOut"])
+			assert.Equal(t, 1.5, first["duration"])
+			assert.Equal(t, "req-1", first["requestId"])
+			assert.Equal(t, true, first["shared"])
+			assert.Contains(t, second, "exitCode")
+			assert.Nil(t, second["exitCode"])
+			assert.NotContains(t, second, "principal")
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		opts := &AccessLoggerOptionsTest{
+			Format: ACCESS_LOG_FORMAT_TEMPLATE,
+			Template: `{{.ClientIP}} {{.Resource}} {{.Status}} {{.ExitCode}} {{printf "%.1f" .Seconds}}`,
+		}
+		lines := writeAccessLog(t, filepath.Join(dir, "template.log"), opts, entry)
+		assert.Equal(t, []string{ "10.0.0.7 reports 200 0 1.5" }, lines)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewAccessLogger(&AccessLoggerOptionsTest{ Format: ACCESS_LOG_FORMAT_TEMPLATE })
+		assert.NotNil(t, err)
+		_, err = NewAccessLogger(&AccessLoggerOptionsTest{ Format: ACCESS_LOG_FORMAT_TEMPLATE, Template: "{{.Status" })
+		assert.NotNil(t, err)
+		_, err = NewAccessLogger(&AccessLoggerOptionsTest{ Format: "common" })
+		assert.NotNil(t, err)
+	})
+
+	// a nil AccessLogger is a no-op
+	var disabled *AccessLogger
+	assert.Nil(t, disabled.Log(entry))
+	assert.Nil(t, disabled.Sync())
+}
+
+func writeAccessLog(t *testing.T, path string, opts *AccessLoggerOptionsTest, entries ...*AccessLogEntry) []string {
+	opts.OutputPaths = []string{ path }
+	l, err := NewAccessLogger(opts)
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	defer l.Close()
+	for _, entry := range entries {
+		assert.Nil(t, l.Log(entry))
+	}
+	l.Sync()
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+type AccessLoggerOptionsTest struct {
+	Format string
+	Template string
+	OutputPaths []string
+}
+
+func (o *AccessLoggerOptionsTest) GetFormat() string {
+	return o.Format
+}
+
+func (o *AccessLoggerOptionsTest) GetTemplate() string {
+	return o.Template
+}
+
+func (o *AccessLoggerOptionsTest) GetOutputPaths() []string {
+	return o.OutputPaths
+}
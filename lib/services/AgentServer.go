@@ -3,16 +3,23 @@ package services
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 	"github.com/gorilla/mux"
 	"github.com/opwire/opwire-agent/lib/config"
@@ -27,6 +34,9 @@ type CommandExecutor interface {
 	GetSettings(resourceName string) []string
 	StoreSettings(prefix string, settings map[string]interface{}, format string, resourceName string) (error)
 	Run(io.Reader, *invokers.CommandInvocation, io.Writer, io.Writer) (*invokers.ExecutionState, error)
+	Running() int
+	Drain(ctx context.Context) int
+	Signal(sig os.Signal) int
 }
 
 type AgentServerOptions interface {
@@ -44,10 +54,40 @@ type AgentServer struct {
 	configManager *config.Manager
 	httpServer *http.Server
 	httpRouter *mux.Router
+	listenerRouters []http.Handler
 	httpOptions *httpServerOptions
 	reqRestrictor *ReqRestrictor
+	reqRateLimiter *ReqRateLimiter
+	clientIPResolver *ClientIPResolver
+	globalAccess *AccessList
+	controlAccess *AccessList
+	accessLists map[string]*AccessList
+	authenticator *Authenticator
+	authorizer *Authorizer
+	certReloader *CertReloader
+	notifier *SystemdNotifier
+	handoffListeners []*SystemdListener
+	handoff chan struct{}
+	done chan struct{}
+	doneOnce sync.Once
+	lifecycleLock sync.Mutex
+	restartSignal chan os.Signal
+	restarting int32
+	tlsConfig *tls.Config
+	reloadSignal chan os.Signal
+	lockManager *LockManager
+	circuitBreakers map[string]*CircuitBreaker
+	clientCertPolicies map[string]*ClientCertPolicy
+	signatureVerifiers map[string]*SignatureVerifier
 	reqSerializer *ReqSerializer
+	scheduler *Scheduler
+	brokers []*brokerBinding
+	resultCaches map[string]*ResultCache
+	cacheControls map[string]string
 	textFormatter *TextFormatter
+	metrics *AgentMetrics
+	tracer *Tracer
+	accessLogger *AccessLogger
 	stateStore *StateStore
 	logger *loq.Logger
 	executor CommandExecutor
@@ -59,9 +99,12 @@ type AgentServer struct {
 
 type httpServerOptions struct {
 	Addr string
+	ProxyProtocol bool
 	MaxHeaderBytes int
 	ReadTimeout time.Duration
 	WriteTimeout time.Duration
+	ShutdownTimeout time.Duration
+	Listeners []*listenerSpec
 }
 
 func NewAgentServer(o AgentServerOptions) (s *AgentServer, err error) {
@@ -70,7 +113,7 @@ func NewAgentServer(o AgentServerOptions) (s *AgentServer, err error) {
 	}
 
 	// creates a new server instance
-	s = &AgentServer{}
+	s = &AgentServer{ done: make(chan struct{}) }
 
 	// remember server edition & options
 	s.options = o
@@ -119,15 +162,96 @@ func NewAgentServer(o AgentServerOptions) (s *AgentServer, err error) {
 		}
 	}
 
+	// create the metrics of the agent
+	s.metrics = NewAgentMetrics()
+
+	// create the tracer (nil when the tracing is disabled)
+	s.tracer, err = buildTracer(conf, s.logger)
+
+	if err != nil {
+		return nil, err
+	}
+
 	// creates a new command executor
-	s.executor, err = invokers.NewExecutor(&invokers.ExecutorOptions{ Logger: s.logger })
+	executorOpts := &invokers.ExecutorOptions{ Logger: s.logger, Observer: s.metrics }
+	if s.tracer != nil {
+		executorOpts.Tracer = s.tracer
+	}
+	s.executor, err = invokers.NewExecutor(executorOpts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// create the concurrent limiters & the singleflight
+	s.reqRestrictor, err = NewReqRestrictor(s.logger, conf.GetHttpServer())
 
 	if err != nil {
 		return nil, err
 	}
 
+	s.reqRestrictor.SetMetrics(s.metrics)
+	s.reqRestrictor.SetTracer(s.tracer)
+
+	// create the access log of the resources (nil when it is disabled)
+	if accessLogConf := conf.GetAccessLog(); accessLogConf.GetEnabled() {
+		s.accessLogger, err = NewAccessLogger(accessLogConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// create the client IP resolver of the trusted proxies
+	s.clientIPResolver, err = NewClientIPResolver(conf.GetHttpServer().GetTrustedProxies())
+
+	if err != nil {
+		return nil, err
+	}
+
+	// create the global & the control endpoints IP access lists
+	if accessConf := conf.GetAccess(); accessConf.GetEnabled() {
+		s.globalAccess, err = NewAccessList(accessConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if controlConf := conf.GetAccess().GetControl(); controlConf.GetEnabled() {
+		s.controlAccess, err = NewAccessList(controlConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// create the global rate limit
+	rateLimitConf := conf.GetHttpServer().GetRateLimit()
+	s.reqRateLimiter, err = NewReqRateLimiter(rateLimitConf, rateLimitConf.GetEnabled())
+
+	if err != nil {
+		return nil, err
+	}
+
+	// create the authentication providers
+	s.authenticator, err = buildAuthenticator(conf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// create the role-based authorization
+	if conf.GetAuthorization().GetEnabled() {
+		s.authorizer, err = buildAuthorizer(conf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// create the named locks manager
+	s.lockManager = NewLockManager()
+
 	// register main & sub-resources
-	s.registerResources(conf)
+	if err := s.registerResources(conf); err != nil {
+		return nil, err
+	}
 
 	// creates a ReqSerializer instance
 	s.reqSerializer, err = NewReqSerializer()
@@ -136,40 +260,41 @@ func NewAgentServer(o AgentServerOptions) (s *AgentServer, err error) {
 		return nil, err
 	}
 
-	// create a weighted semaphore
-	s.reqRestrictor, err = NewReqRestrictor(s.logger, conf.GetHttpServer())
+	// create a cron scheduler
+	s.scheduler, err = NewScheduler(s.logger, s.runScheduledJob)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// create a response formatter
-	s.textFormatter = NewTextFormatter(conf.GetAgent().GetExplanation())
+	// register the scheduled invocations
+	if err := s.registerSchedules(conf); err != nil {
+		return nil, err
+	}
 
-	// defines HTTP request invokers
-	s.httpRouter = mux.NewRouter()
-	s.httpRouter.HandleFunc(CTRL_BASEURL + `/health`, s.makeHealthCheckHandler())
-	s.httpRouter.HandleFunc(CTRL_BASEURL + `/lock`, s.makeLockServiceHandler(true))
-	s.httpRouter.HandleFunc(CTRL_BASEURL + `/unlock`, s.makeLockServiceHandler(false))
+	// register the message brokers
+	if err := s.registerBrokers(conf); err != nil {
+		return nil, err
+	}
 
-	s.mappingResourceToExecUrl(EXEC_BASEURL, conf)
+	// register the file-system watchers
+	if err := s.registerWatchers(conf); err != nil {
+		return nil, err
+	}
+
+	// create the systemd notifier (no-op without NOTIFY_SOCKET)
+	s.notifier = NewSystemdNotifier()
+
+	// create a response formatter
+	s.textFormatter = NewTextFormatter(conf.GetAgent().GetExplanation())
 
 	// validate duplicated resource patterns
 	if err := validateResourcePatterns(conf); err != nil {
 		return nil, err
 	}
 
-	// declare resource patterns
-	s.mappingResourcePatterns(conf)
-
-	webStaticPath := s.options.GetStaticPath()
-	urlPaths := utils.SortDesc(utils.Keys(webStaticPath))
-	for _, urlPath := range urlPaths {
-		filePath := webStaticPath[urlPath]
-		if utils.IsExists(filePath) {
-			s.httpRouter.PathPrefix(urlPath).Handler(http.StripPrefix(urlPath, http.FileServer(http.Dir(filePath))))
-		}
-	}
+	// defines HTTP request invokers
+	s.httpRouter = s.buildRouter(conf, ALL_ROUTE_GROUPS)
 
 	// creates a new HTTP server
 	s.httpOptions = new(httpServerOptions)
@@ -185,6 +310,31 @@ func NewAgentServer(o AgentServerOptions) (s *AgentServer, err error) {
 	if timeout, err := httpConf.GetWriteTimeout(); timeout > 0 && err == nil {
 		s.httpOptions.WriteTimeout = timeout
 	}
+	if timeout, err := httpConf.GetShutdownTimeout(); timeout > 0 && err == nil {
+		s.httpOptions.ShutdownTimeout = timeout
+	}
+	s.httpOptions.ProxyProtocol = httpConf.GetProxyProtocol()
+	if s.httpOptions.ProxyProtocol && len(httpConf.GetTrustedProxies()) == 0 {
+		return nil, fmt.Errorf("The proxy-protocol requires the trusted-proxies to be declared")
+	}
+	for _, listenerConf := range httpConf.GetListeners() {
+		spec, err := newListenerSpec(listenerConf)
+		if err != nil {
+			return nil, err
+		}
+		s.httpOptions.Listeners = append(s.httpOptions.Listeners, spec)
+		s.listenerRouters = append(s.listenerRouters, s.buildRouter(conf, spec.routes))
+	}
+	if tlsConf := httpConf.GetTLS(); tlsConf.GetEnabled() {
+		s.certReloader, err = NewCertReloader(tlsConf.GetCertFile(), tlsConf.GetKeyFile(), s.logger)
+		if err != nil {
+			return nil, err
+		}
+		s.tlsConfig, err = BuildTLSConfig(tlsConf, s.certReloader)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// other configurations
 	s.explanationEnabled = conf.GetAgent().GetExplanation().GetEnabled()
@@ -201,12 +351,15 @@ func NewAgentServer(o AgentServerOptions) (s *AgentServer, err error) {
 }
 
 func (s *AgentServer) Start() (error) {
+	// a shutdown waits until the agent is started
+	s.lifecycleLock.Lock()
+
 	// create a httpServer instance
 	if s.httpServer == nil {
 		s.httpServer = &http.Server{
 			Addr: s.httpOptions.Addr,
 			MaxHeaderBytes: s.httpOptions.MaxHeaderBytes,
-			Handler: s.httpRouter,
+			Handler: s.clientIPResolver.Handler(newRoutedHandler(s.listenerRouters, s.httpRouter)),
 		}
 		if s.httpOptions.ReadTimeout > 0 {
 			s.httpServer.ReadTimeout = s.httpOptions.ReadTimeout
@@ -216,141 +369,1004 @@ func (s *AgentServer) Start() (error) {
 		}
 	}
 
+	restartParent := LookupRestartParent()
+
+	listeners, err := s.openListeners()
+	if err != nil {
+		s.logger.Log(loq.ErrorLevel, "Opening the listeners failed", loq.Error(err))
+		s.lifecycleLock.Unlock()
+		return err
+	}
+
 	// listens and waiting for TERM signal for shutting down
 	idleConnections := make(chan struct{})
+	s.handoff = make(chan struct{})
 
 	go func() {
 		SIGLIST := utils.ShutdownSignals()
 		sig := make(chan os.Signal, 1)
 		signal.Notify(sig, SIGLIST...)
-		<-sig
-		s.logger.Log(loq.InfoLevel, "SIGTERM/SIGTSTP received. Agent is shutting down ...")
-		s.Shutdown()
+		defer signal.Stop(sig)
+		handoff := false
+		select {
+		case <-sig:
+			s.logger.Log(loq.InfoLevel, "SIGTERM/SIGTSTP received. Agent is shutting down ...")
+		case <-s.handoff:
+			s.logger.Log(loq.InfoLevel, "Listeners are handed over to the new agent. Agent is draining ...")
+			handoff = true
+		case <-s.done:
+			// Shutdown() has been called, the agent is already stopped
+			close(idleConnections)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout())
+		defer cancel()
+		s.shutdown(ctx, handoff)
 		close(idleConnections)
 	}()
 
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
-			s.logger.Log(loq.ErrorLevel, "httpServer.ListenAndServe() failed", loq.Error(err))
+		if err := s.serve(listeners); err != http.ErrServerClosed {
+			s.logger.Log(loq.ErrorLevel, "httpServer.Serve() failed", loq.Error(err))
 			close(idleConnections)
 		}
 	}()
 
+	s.startCertReloader()
+
 	s.unlockService()
 
+	// the listeners are opened, notify systemd that the agent is ready
+	if err := s.notifier.Notify(SD_NOTIFY_READY); err != nil {
+		s.logger.Log(loq.ErrorLevel, "Notifying systemd failed", loq.Error(err))
+	}
+	s.notifier.StartWatchdog()
+	if err := NotifyRestartParent(restartParent); err != nil {
+		s.logger.Log(loq.ErrorLevel, "Notifying the parent agent failed", loq.Error(err))
+	}
+
+	s.startRestartListener()
+
+	s.scheduler.Start()
+
+	s.startBrokers()
+
+	s.lifecycleLock.Unlock()
+
 	<-idleConnections
 	return nil
 }
 
-func (s *AgentServer) Shutdown() (error) {
-	closingTimeout := 10 * time.Second // default WriteTimeout
-	if s.httpOptions.WriteTimeout > 0 {
-		closingTimeout = s.httpOptions.WriteTimeout
+// openListeners() opens the declared listeners, or the default TCP listener. The sockets
+// passed by the systemd socket activation (or by the parent agent of a restart) replace the
+// listeners of the same name, or the default listener when no listener is declared.
+func (s *AgentServer) openListeners() ([]net.Listener, error) {
+	inherited, err := ListenInheritedSockets()
+	if err != nil {
+		return nil, err
+	}
+	s.handoffListeners = nil
+	if len(s.httpOptions.Listeners) == 0 {
+		if len(inherited) > 0 {
+			listeners := make([]net.Listener, 0, len(inherited))
+			for _, item := range inherited {
+				s.logger.Log(loq.InfoLevel, "Listener is inherited",
+					loq.String("name", item.Name),
+					loq.String("address", item.Listener.Addr().String()))
+				s.handoffListeners = append(s.handoffListeners, item)
+				listeners = append(listeners, s.wrapListener(item.Listener, item.Listener.Addr().Network()))
+			}
+			return listeners, nil
+		}
+		addr := s.httpServer.Addr
+		if len(addr) == 0 {
+			addr = ":http"
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		s.handoffListeners = append(s.handoffListeners, &SystemdListener{ Name: DEFAULT_LISTENER_NAME, Listener: listener })
+		return []net.Listener{ s.wrapListener(listener, "tcp") }, nil
+	}
+	passed := make(map[string]net.Listener)
+	for _, item := range inherited {
+		passed[item.Name] = item.Listener
+	}
+	listeners := make([]net.Listener, 0, len(s.httpOptions.Listeners))
+	for i, spec := range s.httpOptions.Listeners {
+		listener, ok := passed[spec.name]
+		if ok {
+			delete(passed, spec.name)
+			s.logger.Log(loq.InfoLevel, "Listener is inherited",
+				loq.String("name", spec.name),
+				loq.String("address", listener.Addr().String()),
+				loq.Strings("routes", spec.routes))
+		} else {
+			listener, err = spec.listen()
+			if err != nil {
+				for _, opened := range listeners {
+					opened.Close()
+				}
+				for _, unused := range passed {
+					unused.Close()
+				}
+				return nil, err
+			}
+			s.logger.Log(loq.InfoLevel, "Listener is opened",
+				loq.String("name", spec.name),
+				loq.String("network", spec.network),
+				loq.String("address", spec.address),
+				loq.Strings("routes", spec.routes))
+		}
+		s.handoffListeners = append(s.handoffListeners, &SystemdListener{ Name: spec.name, Listener: listener })
+		listeners = append(listeners, s.wrapListener(&routedListener{ Listener: listener, index: i }, listener.Addr().Network()))
+	}
+	for name, unused := range passed {
+		s.logger.Log(loq.WarnLevel, "Inherited socket matches no listener, it is closed", loq.String("name", name))
+		unused.Close()
+	}
+	return listeners, nil
+}
+
+// serve() accepts the connections of all of the listeners, until one of them fails.
+func (s *AgentServer) serve(listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			errs <- s.httpServer.Serve(listener)
+		}(listener)
+	}
+	return <-errs
+}
+
+// wrapListener() applies the PROXY protocol & the TLS termination to the TCP listeners.
+func (s *AgentServer) wrapListener(listener net.Listener, network string) net.Listener {
+	if network != "tcp" {
+		return listener
+	}
+	if s.httpOptions.ProxyProtocol {
+		listener = NewProxyProtocolListener(listener, s.clientIPResolver)
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	return listener
+}
+
+// startCertReloader() reloads the TLS certificate when its files change or on SIGHUP.
+func (s *AgentServer) startCertReloader() {
+	if s.certReloader == nil {
+		return
+	}
+	if err := s.certReloader.Watch(); err != nil {
+		s.logger.Log(loq.ErrorLevel, "Watching certificate files failed", loq.Error(err))
+	}
+	signals := utils.ReloadSignals()
+	if len(signals) == 0 {
+		return
+	}
+	s.reloadSignal = make(chan os.Signal, 1)
+	signal.Notify(s.reloadSignal, signals...)
+	go func(sig chan os.Signal) {
+		for range sig {
+			s.certReloader.reloadAndLog("SIGHUP")
+		}
+	}(s.reloadSignal)
+}
+
+func (s *AgentServer) stopCertReloader() {
+	if s.certReloader == nil {
+		return
+	}
+	if s.reloadSignal != nil {
+		signal.Stop(s.reloadSignal)
+		close(s.reloadSignal)
+		s.reloadSignal = nil
+	}
+	s.certReloader.Stop()
+}
+
+// Shutdown() stops the agent gracefully: the new requests are refused, then the agent waits
+// for the in-flight requests & executions until ctx is done. The remaining processes receive
+// SIGTERM through their process groups, then SIGKILL after the kill grace period. A running
+// Start() returns once the agent is stopped.
+func (s *AgentServer) Shutdown(ctx context.Context) (error) {
+	return s.shutdown(ctx, false)
+}
+
+// shutdown() stops the agent, after a handoff the new agent process is already serving
+// (and is the main process for systemd), so that the agent does not report its stopping.
+func (s *AgentServer) shutdown(ctx context.Context, handoff bool) (error) {
+	s.lifecycleLock.Lock()
+	defer s.lifecycleLock.Unlock()
+
+	httpServer := s.httpServer
+
+	defer func() {
+		s.httpServer = nil
+	}()
+
+	if !handoff {
+		if err := s.notifier.Notify(SD_NOTIFY_STOPPING); err != nil {
+			s.logger.Log(loq.ErrorLevel, "Notifying systemd failed", loq.Error(err))
+		}
+	}
+	s.notifier.StopWatchdog()
+
+	s.stopRestartListener()
+
+	s.stopBrokers()
+
+	s.scheduler.Stop()
+
+	s.stopCertReloader()
+
+	if !handoff {
+		if err := s.lockService(); err != nil {
+			s.logger.Log(loq.ErrorLevel, "lockService() failed", loq.Error(err))
+		}
+	}
+
+	inflight := s.executor.Running()
+	s.logger.Log(loq.InfoLevel, "No new requests allowed, draining the in-flight executions ...", loq.Int("inflight", inflight))
+
+	// the listeners are closed, the idle connections too, the active ones are waited for
+	httpDone := make(chan error, 1)
+	if httpServer != nil {
+		go func() {
+			httpDone <- httpServer.Shutdown(ctx)
+		}()
+	} else {
+		httpDone <- nil
+	}
+
+	terminated, killed := 0, 0
+	if remaining := s.executor.Drain(ctx); remaining > 0 {
+		s.logger.Log(loq.WarnLevel, "Shutdown deadline is exceeded, terminating the remaining executions ...", loq.Int("remaining", remaining))
+		terminated = s.executor.Signal(syscall.SIGTERM)
+		graceCtx, cancel := context.WithTimeout(context.Background(), DEFAULT_KILL_GRACE_PERIOD)
+		if remaining = s.executor.Drain(graceCtx); remaining > 0 {
+			s.logger.Log(loq.WarnLevel, "Executions are still running, killing them ...", loq.Int("remaining", remaining))
+			killed = s.executor.Signal(os.Kill)
+		}
+		cancel()
+	}
+
+	if err := <-httpDone; err != nil {
+		s.logger.Log(loq.ErrorLevel, "httpServer.Shutdown() failed", loq.Error(err))
+		httpServer.Close()
+	}
+
+	tracerCtx, cancel := context.WithTimeout(context.Background(), DEFAULT_TRACING_SHUTDOWN_TIMEOUT)
+	if err := s.tracer.Shutdown(tracerCtx); err != nil {
+		s.logger.Log(loq.ErrorLevel, "Spans could not be flushed", loq.Error(err))
+	}
+	cancel()
+
+	s.accessLogger.Sync()
+
+	s.logger.Log(loq.InfoLevel, "Agent is stopped",
+		loq.Int("inflight", inflight),
+		loq.Int("terminated", terminated),
+		loq.Int("killed", killed))
+
+	// Start() returns once the agent is stopped
+	s.doneOnce.Do(func() {
+		close(s.done)
+	})
+
+	return nil
+}
+
+// shutdownTimeout() returns the deadline of the draining.
+func (s *AgentServer) shutdownTimeout() time.Duration {
+	if s.httpOptions.ShutdownTimeout > 0 {
+		return s.httpOptions.ShutdownTimeout
+	}
+	if s.httpOptions.WriteTimeout > 0 {
+		return s.httpOptions.WriteTimeout
+	}
+	return DEFAULT_SHUTDOWN_TIMEOUT
+}
+
+// Restart() forks a new agent process which inherits the listeners, waits until it is ready,
+// then hands the service over: the current agent stops accepting and drains its requests.
+func (s *AgentServer) Restart() error {
+	if !atomic.CompareAndSwapInt32(&s.restarting, 0, 1) {
+		return fmt.Errorf("Agent is already restarting")
+	}
+	s.logger.Log(loq.InfoLevel, "Agent is restarting, start a new agent process ...")
+	pid, err := ForkAgent(s.handoffListeners, DEFAULT_RESTART_TIMEOUT)
+	if err != nil {
+		atomic.StoreInt32(&s.restarting, 0)
+		s.logger.Log(loq.ErrorLevel, "Restarting the agent failed", loq.Error(err))
+		return err
+	}
+	s.logger.Log(loq.InfoLevel, "New agent process is ready", loq.Int("pid", pid))
+	if err := s.notifier.Notify(fmt.Sprintf("MAINPID=%d", pid)); err != nil {
+		s.logger.Log(loq.ErrorLevel, "Notifying systemd failed", loq.Error(err))
+	}
+	// the socket files are kept for the new agent when the listeners are closed
+	for _, item := range s.handoffListeners {
+		if listener, ok := item.Listener.(*net.UnixListener); ok {
+			listener.SetUnlinkOnClose(false)
+		}
+	}
+	if s.handoff != nil {
+		close(s.handoff)
+	}
+	return nil
+}
+
+// startRestartListener() restarts the agent on SIGUSR2.
+func (s *AgentServer) startRestartListener() {
+	signals := utils.RestartSignals()
+	if len(signals) == 0 {
+		return
+	}
+	s.restartSignal = make(chan os.Signal, 1)
+	signal.Notify(s.restartSignal, signals...)
+	go func(sig chan os.Signal) {
+		for range sig {
+			s.logger.Log(loq.InfoLevel, "SIGUSR2 received")
+			s.Restart()
+		}
+	}(s.restartSignal)
+}
+
+func (s *AgentServer) stopRestartListener() {
+	if s.restartSignal != nil {
+		signal.Stop(s.restartSignal)
+		close(s.restartSignal)
+		s.restartSignal = nil
+	}
+}
+
+func (s *AgentServer) registerResources(conf *config.Configuration) error {
+	s.resultCaches = make(map[string]*ResultCache)
+	s.cacheControls = make(map[string]string)
+	s.circuitBreakers = make(map[string]*CircuitBreaker)
+	s.clientCertPolicies = make(map[string]*ClientCertPolicy)
+	s.signatureVerifiers = make(map[string]*SignatureVerifier)
+	s.accessLists = make(map[string]*AccessList)
+
+	// register the main resource
+	if conf.Main != nil {
+		resourceName := invokers.MAIN_RESOURCE
+		resourceConf := conf.Main
+		if err := s.registerResource(resourceName, resourceConf, conf.Settings, conf.SettingsFormat); err != nil {
+			return err
+		}
+	}
+
+	// register the sub-resources
+	if conf.Resources != nil {
+		for resourceName, resourceConf := range conf.Resources {
+			if err := s.registerResource(resourceName, &resourceConf, conf.Settings, conf.SettingsFormat); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *AgentServer) registerResource(resourceName string, resourceConf *invokers.CommandEntrypoint,
+		settings map[string]interface{}, format *string) error {
+	if resourceConf != nil && (resourceConf.Enabled == nil || *resourceConf.Enabled == true) {
+		s.executor.Register(resourceConf.Default, resourceName)
+		s.metrics.AddResource(resourceName)
+		if len(resourceConf.Methods) > 0 {
+			for methodName, methodDescriptor := range resourceConf.Methods {
+				if methodId, ok := normalizeMethod(methodName); ok {
+					s.executor.Register(methodDescriptor, resourceName, methodId)
+				}
+			}
+		}
+		if privSettings, privFormat, err := combineResourceSettings(resourceConf, settings, format); err == nil {
+			s.executor.StoreSettings(OPWIRE_SETTINGS_PREFIX, privSettings, privFormat, resourceName)
+		}
+		if err := s.reqRestrictor.RegisterLimiter(resourceConf.GetConcurrentLimit(), resourceName, ""); err != nil {
+			return fmt.Errorf("Concurrent limit of resource [%s] cannot be created: %v", resourceName, err)
+		}
+		if resourceConf.SingleFlight != nil {
+			s.reqRestrictor.RegisterSingleFlight(resourceConf.SingleFlight, resourceName, "")
+		}
+		for methodName, methodDescriptor := range resourceConf.Methods {
+			if methodId, ok := normalizeMethod(methodName); ok && methodDescriptor != nil {
+				if err := s.reqRestrictor.RegisterLimiter(methodDescriptor.GetConcurrentLimit(), resourceName, methodId); err != nil {
+					return fmt.Errorf("Concurrent limit of resource [%s] (method %s) cannot be created: %v", resourceName, methodId, err)
+				}
+				if methodDescriptor.SingleFlight != nil {
+					s.reqRestrictor.RegisterSingleFlight(methodDescriptor.SingleFlight, resourceName, methodId)
+				}
+			}
+		}
+		if rateLimitConf := resourceConf.RateLimit; rateLimitConf != nil && rateLimitConf.GetEnabled() {
+			if err := s.reqRateLimiter.Register(rateLimitConf, resourceName); err != nil {
+				return fmt.Errorf("Rate limit of resource [%s] cannot be created: %v", resourceName, err)
+			}
+		}
+		if breakerConf := resourceConf.CircuitBreaker; breakerConf != nil && breakerConf.GetEnabled() {
+			breaker, err := NewCircuitBreaker(normalizeResourceName(resourceName), s.logger, breakerConf)
+			if err != nil {
+				return fmt.Errorf("Circuit breaker of resource [%s] cannot be created: %v", resourceName, err)
+			}
+			s.circuitBreakers[normalizeResourceName(resourceName)] = breaker
+		}
+		if clientCertConf := resourceConf.ClientCert; clientCertConf != nil && clientCertConf.GetEnabled() {
+			policy, err := NewClientCertPolicy(clientCertConf)
+			if err != nil {
+				return fmt.Errorf("Client certificate allowlist of resource [%s] cannot be created: %v", resourceName, err)
+			}
+			s.clientCertPolicies[normalizeResourceName(resourceName)] = policy
+		}
+		if accessConf := resourceConf.Access; accessConf != nil && accessConf.GetEnabled() {
+			list, err := NewAccessList(accessConf)
+			if err != nil {
+				return fmt.Errorf("Access lists of resource [%s] cannot be created: %v", resourceName, err)
+			}
+			s.accessLists[normalizeResourceName(resourceName)] = list
+		}
+		if signatureConf := resourceConf.Signature; signatureConf != nil && signatureConf.GetEnabled() {
+			verifier, err := NewSignatureVerifier(signatureConf)
+			if err != nil {
+				return fmt.Errorf("Signature verifier of resource [%s] cannot be created: %v", resourceName, err)
+			}
+			s.signatureVerifiers[normalizeResourceName(resourceName)] = verifier
+		}
+		if authConf := resourceConf.Auth; authConf != nil {
+			required := s.authenticator.IsRequired(resourceName)
+			if authConf.Enabled != nil {
+				required = *authConf.Enabled
+			}
+			if err := s.authenticator.Register(resourceName, required, authConf.Providers); err != nil {
+				return fmt.Errorf("Authentication of resource [%s] cannot be configured: %v", resourceName, err)
+			}
+		}
+		lockTimeout, err := resourceConf.GetLockTimeout()
+		if err != nil {
+			return fmt.Errorf("Resource [%s] has an invalid lock-timeout: %v", resourceName, err)
+		}
+		if err := s.lockManager.Register(resourceName, resourceConf.Locks, lockTimeout); err != nil {
+			return fmt.Errorf("Locks of resource [%s] cannot be registered: %v", resourceName, err)
+		}
+		if cacheControl := resourceConf.GetCacheControl(); len(cacheControl) > 0 {
+			s.cacheControls[resourceName] = cacheControl
+		}
+		if cacheConf := resourceConf.GetCache(); cacheConf.GetEnabled() {
+			cache, err := NewResultCache(cacheConf)
+			if err != nil {
+				return fmt.Errorf("Result cache of resource [%s] cannot be created: %v", resourceName, err)
+			}
+			s.resultCaches[resourceName] = cache
+		}
+	}
+	return nil
+}
+
+func (s *AgentServer) getResultCache(resourceName string, methodName string) *ResultCache {
+	if methodName != http.MethodGet {
+		return nil
+	}
+	if len(resourceName) == 0 {
+		resourceName = invokers.MAIN_RESOURCE
+	}
+	return s.resultCaches[resourceName]
+}
+
+func combineResourceSettings(resourceConf *invokers.CommandEntrypoint,
+		settings map[string]interface{}, format *string) (map[string]interface{}, string, error) {
+	privFormat := "json"
+	if format != nil {
+		privFormat = *format
+	}
+	if resourceConf.SettingsFormat != nil {
+		privFormat = *resourceConf.SettingsFormat
+	}
+	privSettings, err := utils.CombineSettings(resourceConf.Settings, settings)
+	return privSettings, privFormat, err
+}
+
+func (s *AgentServer) registerSchedules(conf *config.Configuration) error {
+	for scheduleName, scheduleConf := range conf.GetSchedules() {
+		if err := s.registerSchedule(scheduleName, scheduleConf, conf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AgentServer) registerSchedule(scheduleName string, scheduleConf ScheduleOptions, conf *config.Configuration) error {
+	if !scheduleConf.GetEnabled() {
+		return nil
+	}
+
+	resourceName, resourceConf := lookupResource(conf, scheduleConf.GetResource())
+	if resourceConf == nil {
+		return fmt.Errorf("Schedule [%s] refers to an unavailable resource [%s]", scheduleName, resourceName)
+	}
+
+	job := &ScheduledJob{
+		Name: scheduleName,
+		Cron: scheduleConf.GetCron(),
+		Timezone: scheduleConf.GetTimezone(),
+		ResourceName: resourceName,
+		Stdin: scheduleConf.GetStdin(),
+	}
+	if len(scheduleConf.GetMethod()) > 0 {
+		job.MethodName, _ = normalizeMethod(scheduleConf.GetMethod())
+	}
+
+	if overrides := scheduleConf.GetSettings(); len(overrides) > 0 {
+		privSettings, privFormat, err := combineResourceSettings(resourceConf, conf.Settings, conf.SettingsFormat)
+		if err != nil {
+			return err
+		}
+		privSettings, err = utils.CombineSettings(overrides, privSettings)
+		if err != nil {
+			return err
+		}
+		job.SettingsEnvs, err = utils.TransformSettingsToEnvs(OPWIRE_SETTINGS_PREFIX, privSettings, privFormat)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.scheduler.Register(job)
+}
+
+func (s *AgentServer) runScheduledJob(job *ScheduledJob) (*invokers.ExecutionState, []byte, []byte, error) {
+	if !s.isReady() {
+		return nil, nil, nil, fmt.Errorf("Agent is not ready to serve")
+	}
+	packet := &RequestPacket{
+		Method: &job.MethodName,
+		Header: make(http.Header),
+		Query: make(url.Values),
+		Origin: &RequestOrigin{
+			Kind: ORIGIN_KIND_SCHEDULE,
+			Name: job.Name,
+		},
+	}
+	ci := &invokers.CommandInvocation{
+		ResourceName: job.ResourceName,
+		MethodName: job.MethodName,
+		SettingsEnvs: job.SettingsEnvs,
+	}
+	return s.runDetachedCommand(packet, ci, bytes.NewReader(job.Stdin))
+}
+
+func (s *AgentServer) registerBrokers(conf *config.Configuration) error {
+	for brokerName, brokerConf := range conf.GetBrokers() {
+		if !brokerConf.GetEnabled() {
+			continue
+		}
+		resourceName, resourceConf := lookupResource(conf, brokerConf.GetResource())
+		if resourceConf == nil {
+			return fmt.Errorf("Broker [%s] refers to an unavailable resource [%s]", brokerName, resourceName)
+		}
+		methodName := ""
+		if len(brokerConf.GetMethod()) > 0 {
+			methodName, _ = normalizeMethod(brokerConf.GetMethod())
+		}
+		var broker Broker
+		var err error
+		switch brokerConf.GetType() {
+		case BROKER_TYPE_NATS:
+			broker, err = NewNatsBroker(brokerName, s.logger, brokerConf.GetNats())
+		case BROKER_TYPE_SPOOL:
+			broker, err = NewSpoolBroker(brokerName, s.logger, brokerConf.GetSpool())
+		default:
+			err = fmt.Errorf("Broker [%s] has an unsupported type [%s]", brokerName, brokerConf.GetType())
+		}
+		if err != nil {
+			return err
+		}
+		s.brokers = append(s.brokers, &brokerBinding{
+			broker: broker,
+			handler: s.makeBrokerHandler(resourceName, methodName),
+		})
+	}
+	return nil
+}
+
+func (s *AgentServer) registerWatchers(conf *config.Configuration) error {
+	for watcherName, watcherConf := range conf.GetWatchers() {
+		if !watcherConf.GetEnabled() {
+			continue
+		}
+		resourceName, resourceConf := lookupResource(conf, watcherConf.GetResource())
+		if resourceConf == nil {
+			return fmt.Errorf("Watcher [%s] refers to an unavailable resource [%s]", watcherName, resourceName)
+		}
+		methodName := ""
+		if len(watcherConf.GetMethod()) > 0 {
+			methodName, _ = normalizeMethod(watcherConf.GetMethod())
+		}
+		watcher, err := NewFileWatcher(watcherName, s.logger, watcherConf)
+		if err != nil {
+			return err
+		}
+		s.brokers = append(s.brokers, &brokerBinding{
+			broker: watcher,
+			handler: s.makeBrokerHandler(resourceName, methodName),
+		})
+	}
+	return nil
+}
+
+type brokerBinding struct {
+	broker Broker
+	handler BrokerHandler
+}
+
+func (s *AgentServer) startBrokers() {
+	for _, binding := range s.brokers {
+		if err := binding.broker.Start(binding.handler); err != nil {
+			s.logger.Log(loq.ErrorLevel, "Broker cannot be started", loq.String("broker", binding.broker.GetName()), loq.Error(err))
+		}
+	}
+}
+
+func (s *AgentServer) stopBrokers() {
+	for _, binding := range s.brokers {
+		if err := binding.broker.Stop(); err != nil {
+			s.logger.Log(loq.ErrorLevel, "Broker cannot be stopped", loq.String("broker", binding.broker.GetName()), loq.Error(err))
+		}
+	}
+}
+
+func (s *AgentServer) makeBrokerHandler(resourceName string, methodName string) BrokerHandler {
+	return func(msg *BrokerMessage) *BrokerResult {
+		if !s.isReady() {
+			return &BrokerResult{ Error: fmt.Errorf("Agent is not ready to serve"), Retry: true }
+		}
+		packet := &RequestPacket{
+			Method: &methodName,
+			Header: msg.Header,
+			Query: make(url.Values),
+			Origin: msg.Origin,
+		}
+		ci := &invokers.CommandInvocation{
+			ResourceName: resourceName,
+			MethodName: methodName,
+			RequestId: msg.Id,
+		}
+		state, stdout, stderr, err := s.runDetachedCommand(packet, ci, bytes.NewReader(msg.Body))
+		return &BrokerResult{
+			State: state,
+			Stdout: stdout,
+			Stderr: stderr,
+			Error: err,
+		}
+	}
+}
+
+// runDetachedCommand() runs a command that is not triggered by a HTTP request.
+func (s *AgentServer) runDetachedCommand(packet *RequestPacket, ci *invokers.CommandInvocation, ir io.Reader) (*invokers.ExecutionState, []byte, []byte, error) {
+	encoded, err := s.reqSerializer.EncodePacket(packet)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ci.Envs = s.buildCommandEnvs(encoded)
+	if ci.Context == nil {
+		ci.Context = context.Background()
+	}
+
+	if s.reqRestrictor.HasLimiters() {
+		release, err := s.reqRestrictor.Acquire(ci.Context, ci.ResourceName, strings.ToUpper(ci.MethodName))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("Failed to acquire permits, error: [%v]", err)
+		}
+		defer release()
+	}
+
+	result := s.runCommand(ir, ci)
+	return result.state, result.stdout, result.stderr, result.err
+}
+
+func lookupResource(conf *config.Configuration, resourceName string) (string, *invokers.CommandEntrypoint) {
+	resourceConf := conf.Main
+	if len(resourceName) == 0 {
+		resourceName = invokers.MAIN_RESOURCE
+	} else {
+		resourceConf = nil
+		if entrypoint, ok := conf.Resources[resourceName]; ok {
+			resourceConf = &entrypoint
+		}
+	}
+	if resourceConf == nil || (resourceConf.Enabled != nil && *resourceConf.Enabled == false) {
+		return resourceName, nil
+	}
+	return resourceName, resourceConf
+}
+
+// buildRouter() registers the handlers of the route groups: the control endpoints, the
+// execution url, the resource patterns and the static paths.
+func (s *AgentServer) buildRouter(conf *config.Configuration, routes []string) *mux.Router {
+	router := mux.NewRouter()
+	if utils.Contains(routes, ROUTE_GROUP_CONTROL) {
+		router.HandleFunc(CTRL_BASEURL + `/health`, s.makeAccessHandler(`health`, s.makeHealthCheckHandler()))
+		router.HandleFunc(CTRL_BASEURL + `/lock`, s.makeAuthHandler(`lock`, s.makeLockServiceHandler(true)))
+		router.HandleFunc(CTRL_BASEURL + `/unlock`, s.makeAuthHandler(`unlock`, s.makeLockServiceHandler(false)))
+		router.HandleFunc(CTRL_BASEURL + `/schedules`, s.makeAuthHandler(`schedules`, s.makeScheduleListHandler()))
+		router.HandleFunc(CTRL_BASEURL + `/cache/stats`, s.makeAuthHandler(`cache/stats`, s.makeCacheStatsHandler()))
+		router.HandleFunc(CTRL_BASEURL + `/cache/purge`, s.makeAuthHandler(`cache/purge`, s.makeCachePurgeHandler()))
+		router.HandleFunc(CTRL_BASEURL + `/locks`, s.makeAuthHandler(`locks`, s.makeLockListHandler()))
+		router.HandleFunc(CTRL_BASEURL + `/restart`, s.makeAuthHandler(`restart`, s.makeRestartHandler()))
+		router.HandleFunc(CTRL_BASEURL + `/metrics`, s.makeAuthHandler(`metrics`, s.makeMetricsHandler()))
+	}
+	if utils.Contains(routes, ROUTE_GROUP_EXEC) {
+		s.mappingResourceToExecUrl(router, EXEC_BASEURL, conf)
+	}
+	if utils.Contains(routes, ROUTE_GROUP_PATTERNS) {
+		s.mappingResourcePatterns(router, conf)
+	}
+	if utils.Contains(routes, ROUTE_GROUP_STATIC) {
+		webStaticPath := s.options.GetStaticPath()
+		urlPaths := utils.SortDesc(utils.Keys(webStaticPath))
+		for _, urlPath := range urlPaths {
+			filePath := webStaticPath[urlPath]
+			if utils.IsExists(filePath) {
+				router.PathPrefix(urlPath).Handler(http.StripPrefix(urlPath, http.FileServer(http.Dir(filePath))))
+			}
+		}
+	}
+	return router
+}
+
+func (s *AgentServer) mappingResourcePatterns(router *mux.Router, conf *config.Configuration) {
+	// register the main resource
+	if conf.Main != nil {
+		resourceName := invokers.MAIN_RESOURCE
+		resourceConf := conf.Main
+		s.mappingResourcePattern(router, resourceName, resourceConf)
+	}
+	// register the sub-resources
+	if conf.Resources != nil {
+		for resourceName, resourceConf := range conf.Resources {
+			s.mappingResourcePattern(router, resourceName, &resourceConf)
+		}
+	}
+}
+
+func (s *AgentServer) mappingResourcePattern(router *mux.Router, resourceName string, resourceConf *invokers.CommandEntrypoint) {
+	if len(resourceName) > 0 && resourceConf.Pattern != nil && (resourceConf.Enabled == nil || *resourceConf.Enabled == true) {
+		router.HandleFunc(*resourceConf.Pattern, s.makeUrlPatternHandler(resourceName))
+	}
+}
+
+func (s *AgentServer) mappingResourceToExecUrl(router *mux.Router, defaultBaseUrl string, conf *config.Configuration) {
+	baseUrl := buildExecUrl(defaultBaseUrl, conf)
+	handler := s.makeInvocationHandler()
+	router.HandleFunc(baseUrl + `/{resourceName:` + config.RESOURCE_NAME_PATTERN + `}`, handler)
+	router.HandleFunc(baseUrl + `/`, handler)
+	if len(baseUrl) > 0 {
+		router.HandleFunc(baseUrl, handler)
+	}
+}
+
+func (s *AgentServer) makeLockServiceHandler(freezed bool) func(http.ResponseWriter, *http.Request) {
+	return func (w http.ResponseWriter, r *http.Request) {
+		if freezed {
+			s.lockService()
+		} else {
+			s.unlockService()
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *AgentServer) makeScheduleListHandler() func(http.ResponseWriter, *http.Request) {
+	return func (w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			data, err := json.Marshal(s.scheduler.List())
+			if err != nil {
+				w.Header().Set(RES_HEADER_ERROR_MESSAGE, err.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (s *AgentServer) makeLockListHandler() func(http.ResponseWriter, *http.Request) {
+	return func (w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			data, err := json.Marshal(s.lockManager.Holders())
+			if err != nil {
+				w.Header().Set(RES_HEADER_ERROR_MESSAGE, err.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (s *AgentServer) makeCacheStatsHandler() func(http.ResponseWriter, *http.Request) {
+	return func (w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			stats := make(map[string]*ResultCacheStats)
+			for resourceName, cache := range s.resultCaches {
+				stats[resourceName] = cache.Stats()
+			}
+			data, err := json.Marshal(stats)
+			if err != nil {
+				w.Header().Set(RES_HEADER_ERROR_MESSAGE, err.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
 	}
+}
 
-	defer func() {
-		s.httpServer = nil
-	}()
-
-	if s.isReady() {
-		if err := s.lockService(); err != nil {
-			s.logger.Log(loq.ErrorLevel, "lockService() failed", loq.Error(err))
+func (s *AgentServer) makeCachePurgeHandler() func(http.ResponseWriter, *http.Request) {
+	return func (w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodDelete:
+			purged := make(map[string]int)
+			resourceName := r.URL.Query().Get("resource")
+			for name, cache := range s.resultCaches {
+				if len(resourceName) == 0 || name == resourceName {
+					purged[name] = cache.Purge()
+				}
+			}
+			if len(resourceName) > 0 && len(purged) == 0 {
+				w.Header().Set(RES_HEADER_ERROR_MESSAGE, fmt.Sprintf("Resource [%s] has no cache", resourceName))
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			data, _ := json.Marshal(purged)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
-		s.logger.Log(loq.InfoLevel, "No new requests allowed, wait for ...", loq.Duration("closingTimeout", closingTimeout))
-		<-time.Tick(closingTimeout)
 	}
+}
 
-	if s.httpServer != nil {
-		if err := s.httpServer.Shutdown(context.Background()); err != nil {
-			s.logger.Log(loq.ErrorLevel, "httpServer.Shutdown() failed", loq.Error(err))
+func (s *AgentServer) makeRestartHandler() func(http.ResponseWriter, *http.Request) {
+	return func (w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			if atomic.LoadInt32(&s.restarting) != 0 {
+				w.Header().Set(RES_HEADER_ERROR_MESSAGE, "Agent is already restarting")
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			go s.Restart()
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
-		s.logger.Log(loq.InfoLevel, "HTTP server is shutting down in ...", loq.Duration("closingTimeout", closingTimeout))
-		<-time.Tick(closingTimeout)
 	}
-
-	return nil
 }
 
-func (s *AgentServer) registerResources(conf *config.Configuration) {
-	// register the main resource
-	if conf.Main != nil {
-		resourceName := invokers.MAIN_RESOURCE
-		resourceConf := conf.Main
-		s.registerResource(resourceName, resourceConf, conf.Settings, conf.SettingsFormat)
+func (s *AgentServer) makeMetricsHandler() func(http.ResponseWriter, *http.Request) {
+	return func (w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.metrics.CollectLimiters(s.reqRestrictor.LimiterStats())
+			w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+			w.WriteHeader(http.StatusOK)
+			s.metrics.WriteText(w)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
 	}
+}
 
-	// register the sub-resources
-	if conf.Resources != nil {
-		for resourceName, resourceConf := range conf.Resources {
-			s.registerResource(resourceName, &resourceConf, conf.Settings, conf.SettingsFormat)
+// makeAccessHandler() applies the access lists of the control endpoints only, the endpoint
+// stays public for the probes.
+func (s *AgentServer) makeAccessHandler(endpoint string, next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func (w http.ResponseWriter, r *http.Request) {
+		if !s.checkAccess(w, r, s.controlAccess, loq.String("endpoint", endpoint)) {
+			return
 		}
+		next(w, r)
 	}
 }
 
-func (s *AgentServer) registerResource(resourceName string, resourceConf *invokers.CommandEntrypoint,
-		settings map[string]interface{}, format *string) {
-	if resourceConf != nil && (resourceConf.Enabled == nil || *resourceConf.Enabled == true) {
-		s.executor.Register(resourceConf.Default, resourceName)
-		if len(resourceConf.Methods) > 0 {
-			for methodName, methodDescriptor := range resourceConf.Methods {
-				if methodId, ok := normalizeMethod(methodName); ok {
-					s.executor.Register(methodDescriptor, resourceName, methodId)
-				}
-			}
+// makeAuthHandler() protects a control endpoint with the global authentication requirement
+// and the control rules of the roles.
+func (s *AgentServer) makeAuthHandler(endpoint string, next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func (w http.ResponseWriter, r *http.Request) {
+		if !s.checkAccess(w, r, s.controlAccess, loq.String("endpoint", endpoint)) {
+			return
 		}
-		if privSettings, err := utils.CombineSettings(resourceConf.Settings, settings); err == nil {
-			privFormat := "json"
-			if format != nil {
-				privFormat = *format
-			}
-			if resourceConf.SettingsFormat != nil {
-				privFormat = *resourceConf.SettingsFormat
+		r, authenticated := s.authenticate(w, r, AUTH_CONTROL_RESOURCE)
+		if !authenticated {
+			return
+		}
+		if s.authorizer != nil {
+			roles := s.authorizer.Roles(extractPrincipal(r), ExtractClientIdentity(r))
+			if !s.authorizer.AllowControl(roles, endpoint) {
+				s.writeAccessDenied(w, r, roles, loq.String("endpoint", endpoint))
+				return
 			}
-			s.executor.StoreSettings(OPWIRE_SETTINGS_PREFIX, privSettings, privFormat, resourceName)
 		}
+		next(w, r)
 	}
 }
 
-func (s *AgentServer) mappingResourcePatterns(conf *config.Configuration) {
-	// register the main resource
-	if conf.Main != nil {
-		resourceName := invokers.MAIN_RESOURCE
-		resourceConf := conf.Main
-		s.mappingResourcePattern(resourceName, resourceConf)
-	}
-	// register the sub-resources
-	if conf.Resources != nil {
-		for resourceName, resourceConf := range conf.Resources {
-			s.mappingResourcePattern(resourceName, &resourceConf)
-		}
+// checkAccess() writes the 403 response when the client IP is rejected by the global access
+// lists or by the given ones, it is called before the authentication & the body reading.
+func (s *AgentServer) checkAccess(w http.ResponseWriter, r *http.Request, list *AccessList, fields ...loq.Field) bool {
+	ip, allowed := AllowRequest(r, s.globalAccess, list)
+	if allowed {
+		return true
 	}
+	fields = append(fields, loq.String("clientIP", ip.String()), loq.String("path", r.URL.Path))
+	s.logger.Log(loq.WarnLevel, "Client IP is not allowed", fields...)
+	w.Header().Set(RES_HEADER_ERROR_MESSAGE, "Client IP is not allowed")
+	w.WriteHeader(http.StatusForbidden)
+	return false
 }
 
-func (s *AgentServer) mappingResourcePattern(resourceName string, resourceConf *invokers.CommandEntrypoint) {
-	if len(resourceName) > 0 && resourceConf.Pattern != nil && (resourceConf.Enabled == nil || *resourceConf.Enabled == true) {
-		s.httpRouter.HandleFunc(*resourceConf.Pattern, s.makeUrlPatternHandler(resourceName))
+// authorize() writes the 403 response when none of the roles allows the method on the resource.
+func (s *AgentServer) authorize(w http.ResponseWriter, r *http.Request, resourceName string) bool {
+	if s.authorizer == nil {
+		return true
+	}
+	roles := s.authorizer.Roles(extractPrincipal(r), ExtractClientIdentity(r))
+	if s.authorizer.Allow(roles, resourceName, r.Method) {
+		return true
 	}
+	s.writeAccessDenied(w, r, roles, loq.String("resourceName", resourceName), loq.String("method", r.Method))
+	return false
 }
 
-func (s *AgentServer) mappingResourceToExecUrl(defaultBaseUrl string, conf *config.Configuration) {
-	baseUrl := buildExecUrl(defaultBaseUrl, conf)
-	handler := s.makeInvocationHandler()
-	s.httpRouter.HandleFunc(baseUrl + `/{resourceName:` + config.RESOURCE_NAME_PATTERN + `}`, handler)
-	s.httpRouter.HandleFunc(baseUrl + `/`, handler)
-	if len(baseUrl) > 0 {
-		s.httpRouter.HandleFunc(baseUrl, handler)
+func (s *AgentServer) writeAccessDenied(w http.ResponseWriter, r *http.Request, roles []string, fields ...loq.Field) {
+	if principal := extractPrincipal(r); principal != nil {
+		fields = append(fields, loq.String("principal", principal.Name), loq.String("provider", principal.Provider))
 	}
+	if identity := ExtractClientIdentity(r); identity != nil {
+		fields = append(fields, loq.String("subject", identity.Subject))
+	}
+	fields = append(fields, loq.Strings("roles", roles), loq.String("path", r.URL.Path))
+	s.logger.Log(loq.WarnLevel, "Access is denied", fields...)
+	w.Header().Set(RES_HEADER_ERROR_MESSAGE, "Access is denied")
+	w.WriteHeader(http.StatusForbidden)
 }
 
-func (s *AgentServer) makeLockServiceHandler(freezed bool) func(http.ResponseWriter, *http.Request) {
-	return func (w http.ResponseWriter, r *http.Request) {
-		if freezed {
-			s.lockService()
-		} else {
-			s.unlockService()
-		}
-		w.WriteHeader(http.StatusOK)
-	}
+// authenticate() writes the 401 response when the credentials are missing or invalid, the
+// returned request carries the authenticated principal.
+func (s *AgentServer) authenticate(w http.ResponseWriter, r *http.Request, resourceName string) (*http.Request, bool) {
+	principal, err := s.authenticator.Authenticate(r, resourceName)
+	if err != nil {
+		s.logger.Log(loq.WarnLevel, "Authentication failed",
+			loq.String("resourceName", resourceName),
+			loq.String("path", r.URL.Path),
+			loq.Error(err))
+		s.authenticator.WriteChallenge(w, resourceName)
+		w.Header().Set(RES_HEADER_ERROR_MESSAGE, s.authenticator.PublicMessage(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return r, false
+	}
+	if principal != nil {
+		r = withPrincipal(r, principal)
+	}
+	return r, true
 }
 
 func (s *AgentServer) makeHealthCheckHandler() func(http.ResponseWriter, *http.Request) {
@@ -365,7 +1381,12 @@ func (s *AgentServer) makeHealthCheckHandler() func(http.ResponseWriter, *http.R
 		case http.MethodGet:
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
-			io.WriteString(w, `{"ready": true, "alive": true}`)
+			json.NewEncoder(w).Encode(&healthStatus{
+				Ready: true,
+				Alive: true,
+				ConcurrentLimits: s.reqRestrictor.LimiterStats(),
+				CircuitBreakers: s.getCircuitBreakerStats(),
+			})
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
@@ -403,6 +1424,94 @@ func (s *AgentServer) makeUrlPatternHandler(resourceName string) func(http.Respo
 }
 
 func (s *AgentServer) doExecuteCommand(w http.ResponseWriter, r *http.Request, resourceName string, fromExecUrl bool) {
+	startTime := time.Now()
+	recorder := &responseRecorder{ ResponseWriter: w }
+	w = recorder
+	exitCode, flightShared := -1, false
+	var bodyCounter *countingReadCloser
+	if s.accessLogger != nil && r.Body != nil {
+		bodyCounter = &countingReadCloser{ ReadCloser: r.Body }
+		r.Body = bodyCounter
+	}
+	ctx, span := s.tracer.StartSpan(ContextWithTraceparent(r.Context(), r.Header.Get(TRACEPARENT_HEADER_NAME)), "HTTP " + r.Method, SPAN_KIND_SERVER)
+	if span != nil {
+		r = r.WithContext(ctx)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("opwire.resource", normalizeResourceName(resourceName))
+		if requestId := r.Header.Get(REQ_HEADER_REQUEST_ID_NAME); len(requestId) > 0 {
+			span.SetAttribute("opwire.request_id", requestId)
+		}
+	}
+	defer func() {
+		status := recorder.Status()
+		s.metrics.ObserveRequest(resourceName, r.Method, status, time.Since(startTime))
+		span.SetAttribute("http.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf(http.StatusText(status)))
+		}
+		span.End()
+		if s.accessLogger != nil {
+			entry := &AccessLogEntry{
+				Time: startTime,
+				Method: r.Method,
+				Path: r.URL.RequestURI(),
+				Protocol: r.Proto,
+				Resource: normalizeResourceName(resourceName),
+				Status: status,
+				ExitCode: exitCode,
+				BytesIn: requestBytesIn(r, bodyCounter),
+				BytesOut: recorder.written,
+				Duration: time.Since(startTime),
+				RequestId: r.Header.Get(REQ_HEADER_REQUEST_ID_NAME),
+				Shared: flightShared,
+				Referer: r.Referer(),
+				UserAgent: r.UserAgent(),
+			}
+			if ip, err := extractUserIP(r); err == nil {
+				entry.ClientIP = ip.String()
+			}
+			if principal := extractPrincipal(r); principal != nil {
+				entry.Principal = principal.Name
+			}
+			if err := s.accessLogger.Log(entry); err != nil {
+				s.logger.Log(loq.WarnLevel, "Access log could not be written", loq.Error(err))
+			}
+		}
+	}()
+	if !s.checkAccess(w, r, s.accessLists[normalizeResourceName(resourceName)], loq.String("resourceName", resourceName)) {
+		return
+	}
+	r, authenticated := s.authenticate(w, r, resourceName)
+	if !authenticated {
+		return
+	}
+	if !s.authorize(w, r, resourceName) {
+		return
+	}
+	if policy, ok := s.clientCertPolicies[normalizeResourceName(resourceName)]; ok {
+		if identity := ExtractClientIdentity(r); !policy.Allow(identity) {
+			fields := []loq.Field{ loq.String("resourceName", resourceName) }
+			if identity != nil {
+				fields = append(fields, loq.String("subject", identity.Subject))
+			}
+			s.logger.Log(loq.WarnLevel, "Client certificate is not allowed", fields...)
+			w.Header().Set(RES_HEADER_ERROR_MESSAGE, "Client certificate is not allowed")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	if limited := s.reqRateLimiter.Allow(r, resourceName); limited != nil {
+		limited.WriteHeaders(w)
+		if !limited.Allowed {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(limited.Reset.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, "Rate limit is exceeded")
+			return
+		}
+	}
+
 	expIn, expOut, expErr := s.getExplanationModes(r)
 
 	ib, tee := s.generateTeeBuffer()
@@ -416,6 +1525,25 @@ func (s *AgentServer) doExecuteCommand(w http.ResponseWriter, r *http.Request, r
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if verifier, ok := s.signatureVerifiers[normalizeResourceName(resourceName)]; ok {
+		var signed []byte
+		if ir != nil {
+			var err error
+			signed, err = ioutil.ReadAll(ir)
+			if err != nil {
+				w.Header().Set(RES_HEADER_ERROR_MESSAGE, "Request body cannot be read")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			ir = ioutil.NopCloser(bytes.NewReader(signed))
+		}
+		if err := verifier.Verify(r, signed); err != nil {
+			s.logger.Log(loq.WarnLevel, "Signature verification failed", loq.String("resourceName", resourceName), loq.Error(err))
+			w.Header().Set(RES_HEADER_ERROR_MESSAGE, err.Error())
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
 	ci, ciErr := s.buildCommandInvocation(r, resourceName, fromExecUrl)
 	if ciErr != nil {
 		w.Header().Set(RES_HEADER_ERROR_MESSAGE, ciErr.Error())
@@ -429,37 +1557,69 @@ func (s *AgentServer) doExecuteCommand(w http.ResponseWriter, r *http.Request, r
 		s.explainRequest(w, ib, ci)
 		return
 	}
-	var ob bytes.Buffer
-	var eb bytes.Buffer
-	var ow, ew io.Writer
-	if s.outputCombined {
-		ow = &ob
-		ew = &ob
-	} else {
-		ow = &ob
-		ew = &eb
+	var cache *ResultCache
+	var cacheKey string
+	if !expOut && !expErr {
+		cache = s.getResultCache(resourceName, r.Method)
+	}
+	var body []byte
+	if ir != nil && ((cache != nil && cache.HasBody()) || s.reqRestrictor.HasSingleFlightBody(resourceName, strings.ToUpper(r.Method))) {
+		body, _ = ioutil.ReadAll(ir)
+		ir = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if cache != nil {
+		cacheKey = cache.Key(r, body)
+		if cached := cache.Get(cacheKey); cached != nil {
+			w.Header().Set(RES_HEADER_CACHE, "HIT")
+			w.Header().Set("Age", fmt.Sprintf("%d", int64(time.Since(cached.StoredAt).Seconds())))
+			if s.writeNotModified(w, r, resourceName, cached.ETag) {
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Write(cached.Stdout)
+			return
+		}
+		w.Header().Set(RES_HEADER_CACHE, "MISS")
 	}
-	var state *invokers.ExecutionState
-	var err error
 
-	if s.reqRestrictor.HasSemaphore() {
-		if err := s.reqRestrictor.Acquire(1); err != nil {
+	if s.reqRestrictor.HasLimiters() {
+		release, err := s.reqRestrictor.Acquire(r.Context(), resourceName, strings.ToUpper(r.Method))
+		if err != nil {
+			if limitErr, ok := err.(*LimitError); ok {
+				writeLimitError(w, limitErr)
+				return
+			}
 			w.Header().Set("Content-Type", "text/plain")
 			w.WriteHeader(http.StatusInternalServerError)
 			io.WriteString(w, fmt.Sprintf("Failed to acquire permits, error: [%v]", err))
 			return
 		}
-		defer s.reqRestrictor.Release(1)
+		defer release()
 	}
 
-	if s.reqRestrictor.HasSingleFlight() {
-		_state, _err, _ := s.reqRestrictor.FilterByDigest(r, func() (interface{}, error) {
-			return s.executor.Run(ir, ci, ow, ew)
-		})
-		state = _state.(*invokers.ExecutionState)
-		err = _err
+	// the output is captured inside of the action, so that the followers of a single-flight
+	// group receive the same stdout, stderr and error as the leader
+	run := func() (interface{}, error) {
+		return s.runCommand(ir, ci), nil
+	}
+	var result *commandResult
+	if s.reqRestrictor.HasSingleFlight(resourceName, strings.ToUpper(r.Method)) {
+		var out interface{}
+		out, _, flightShared = s.reqRestrictor.FilterByDigest(r, resourceName, body, run)
+		result = out.(*commandResult)
 	} else {
-		state, err = s.executor.Run(ir, ci, ow, ew)
+		out, _ := run()
+		result = out.(*commandResult)
+	}
+	state, err := result.state, result.err
+	exitCode = commandExitCode(state, err)
+	ob := bytes.NewBuffer(result.stdout)
+	eb := bytes.NewBuffer(result.stderr)
+
+	if limitErr, ok := err.(*LimitError); ok {
+		writeLimitError(w, limitErr)
+		return
 	}
 
 	if state != nil && state.IsTimeout {
@@ -474,7 +1634,7 @@ func (s *AgentServer) doExecuteCommand(w http.ResponseWriter, r *http.Request, r
 			w.Header().Set("Content-Type", "text/plain")
 			writeHeaderExecDuration(w, state)
 			w.WriteHeader(http.StatusInternalServerError)
-			s.explainResult(w, ib, ci, err, &ob, &eb)
+			s.explainResult(w, ib, ci, err, ob, eb)
 			return
 		}
 		w.Header().Set("Content-Type", "text/plain")
@@ -488,17 +1648,149 @@ func (s *AgentServer) doExecuteCommand(w http.ResponseWriter, r *http.Request, r
 			w.Header().Set("Content-Type", "text/plain")
 			writeHeaderExecDuration(w, state)
 			w.WriteHeader(http.StatusResetContent)
-			s.explainResult(w, ib, ci, err, &ob, &eb)
+			s.explainResult(w, ib, ci, err, ob, eb)
 			return
 		}
-		w.Header().Set("Content-Type", "text/plain")
+		if cache != nil && state != nil {
+			cache.Put(cacheKey, ob.Bytes(), state.Duration)
+		}
 		writeHeaderExecDuration(w, state)
+		if r.Method == http.MethodGet {
+			if s.writeNotModified(w, r, resourceName, ComputeETag(ob.Bytes())) {
+				return
+			}
+		} else if cacheControl, ok := s.getCacheControl(resourceName); ok {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusOK)
 		io.WriteString(w, string(ob.Bytes()))
 		return
 	}
 }
 
+// responseRecorder captures the status code & the size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	written int64
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(data)
+	rr.written += int64(n)
+	return n, err
+}
+
+// Status() returns 200 if nothing has been written, as net/http does.
+func (rr *responseRecorder) Status() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
+
+type healthStatus struct {
+	Ready bool `json:"ready"`
+	Alive bool `json:"alive"`
+	ConcurrentLimits []*ConcurrencyStats `json:"concurrentLimits,omitempty"`
+	CircuitBreakers []*CircuitBreakerStats `json:"circuitBreakers,omitempty"`
+}
+
+func (s *AgentServer) getCircuitBreakerStats() []*CircuitBreakerStats {
+	names := make([]string, 0, len(s.circuitBreakers))
+	for name := range s.circuitBreakers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]*CircuitBreakerStats, 0, len(names))
+	for _, name := range names {
+		stats = append(stats, s.circuitBreakers[name].Stats())
+	}
+	return stats
+}
+
+type commandResult struct {
+	state *invokers.ExecutionState
+	stdout []byte
+	stderr []byte
+	err error
+}
+
+func (s *AgentServer) runCommand(ir io.Reader, ci *invokers.CommandInvocation) *commandResult {
+	var ob bytes.Buffer
+	var eb bytes.Buffer
+	var ow, ew io.Writer
+	if s.outputCombined {
+		ow = &ob
+		ew = &ob
+	} else {
+		ow = &ob
+		ew = &eb
+	}
+	release, err := s.lockManager.Acquire(ci.Context, ci)
+	if err != nil {
+		return &commandResult{ err: err }
+	}
+	defer release()
+	var done func(failed bool)
+	if breaker, ok := s.circuitBreakers[normalizeResourceName(ci.ResourceName)]; ok {
+		if done, err = breaker.Allow(); err != nil {
+			return &commandResult{ err: err }
+		}
+	}
+	state, err := s.executor.Run(ir, ci, ow, ew)
+	if done != nil {
+		done(err != nil || (state != nil && state.IsTimeout))
+	}
+	return &commandResult{
+		state: state,
+		stdout: ob.Bytes(),
+		stderr: eb.Bytes(),
+		err: err,
+	}
+}
+
+// writeNotModified() sets the ETag & Cache-Control headers of a successful response and
+// responds 304 Not Modified if the output matches the If-None-Match header of the request.
+func (s *AgentServer) writeNotModified(w http.ResponseWriter, r *http.Request, resourceName string, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if cacheControl, ok := s.getCacheControl(resourceName); ok {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if MatchETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+func (s *AgentServer) getCacheControl(resourceName string) (string, bool) {
+	if len(resourceName) == 0 {
+		resourceName = invokers.MAIN_RESOURCE
+	}
+	cacheControl, ok := s.cacheControls[resourceName]
+	return cacheControl, ok
+}
+
+func writeLimitError(w http.ResponseWriter, limitErr *LimitError) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(limitErr.RetryAfter.Seconds()))))
+	w.Header().Set(RES_HEADER_ERROR_MESSAGE, limitErr.Error())
+	w.WriteHeader(limitErr.StatusCode)
+	io.WriteString(w, limitErr.Error())
+}
+
 func writeHeaderExecDuration(w http.ResponseWriter, state *invokers.ExecutionState) {
 	if state == nil || state.Duration == 0 {
 		return
@@ -554,24 +1846,13 @@ func (s *AgentServer) buildCommandInvocation(r *http.Request, resourceName strin
 		loq.String("resourceName", resourceName),
 		loq.String("methodName", methodName),
 		loq.String("requestId", requestId))
-	// prepare environment variables
-	envs := os.Environ()
-	// import the release information
-	if s.options != nil {
-		edition := map[string]string {
-			"revision": s.options.GetRevision(),
-			"version": s.options.GetVersion(),
-		}
-		if str, err := json.Marshal(edition); err == nil {
-			envs = append(envs, fmt.Sprintf("%s=%s", OPWIRE_EDITION_PREFIX, str))
-		}
-	}
 	// build the request query & data
-	if encoded, err := s.reqSerializer.Encode(r, fromExecUrl); err == nil {
-		envs = append(envs, fmt.Sprintf("%s=%s", OPWIRE_REQUEST_PREFIX, encoded))
-	} else {
+	encoded, err := s.reqSerializer.Encode(r, fromExecUrl)
+	if err != nil {
 		return nil, err
 	}
+	// prepare environment variables
+	envs := s.buildCommandEnvs(encoded)
 	// create a new CommandInvocation
 	ci := &invokers.CommandInvocation{
 		Envs: envs,
@@ -594,6 +1875,23 @@ func (s *AgentServer) buildCommandInvocation(r *http.Request, resourceName strin
 	return ci, nil
 }
 
+func (s *AgentServer) buildCommandEnvs(encodedRequest []byte) []string {
+	envs := os.Environ()
+	// import the release information
+	if s.options != nil {
+		edition := map[string]string {
+			"revision": s.options.GetRevision(),
+			"version": s.options.GetVersion(),
+		}
+		if str, err := json.Marshal(edition); err == nil {
+			envs = append(envs, fmt.Sprintf("%s=%s", OPWIRE_EDITION_PREFIX, str))
+		}
+	}
+	// import the request query & data
+	envs = append(envs, fmt.Sprintf("%s=%s", OPWIRE_REQUEST_PREFIX, encodedRequest))
+	return envs
+}
+
 type teeReadCloser struct {
 	io.Reader
 	io.Closer
@@ -701,6 +1999,97 @@ func (s *AgentServer) unlockService() (error) {
 	return nil
 }
 
+func buildAuthenticator(conf *config.Configuration) (*Authenticator, error) {
+	authConf := conf.GetAuth()
+	providers := make([]AuthProvider, 0)
+	if jwtConf := authConf.GetJWT(); jwtConf.GetEnabled() {
+		verifier, err := NewJWTVerifier(jwtConf)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, verifier)
+	}
+	if basicConf := authConf.GetBasic(); basicConf.GetEnabled() {
+		provider, err := NewBasicAuthProvider(basicConf.GetHtpasswdFile())
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	if apiKeysConf := authConf.GetApiKeys(); apiKeysConf.GetEnabled() {
+		keys := make([]*ApiKey, 0)
+		for _, keyConf := range apiKeysConf.GetKeys() {
+			expiresAt, err := keyConf.GetExpiresAt()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, &ApiKey{
+				Name: keyConf.GetName(),
+				Key: keyConf.GetKey(),
+				Labels: keyConf.GetLabels(),
+				ExpiresAt: expiresAt,
+			})
+		}
+		provider, err := NewApiKeyProvider(apiKeysConf.GetHeader(), keys, apiKeysConf.GetKeysFile())
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return NewAuthenticator(authConf.GetRealm(), authConf.GetEnabled(), providers...), nil
+}
+
+func buildTracer(conf *config.Configuration, logger *loq.Logger) (*Tracer, error) {
+	tracingConf := conf.GetTracing()
+	if !tracingConf.GetEnabled() {
+		return nil, nil
+	}
+	exporters := make([]SpanExporter, 0, 2)
+	if otlpConf := tracingConf.GetOtlp(); otlpConf != nil {
+		exporter, err := NewOtlpExporter(otlpConf)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+	if fileConf := tracingConf.GetFile(); fileConf != nil {
+		exporter, err := NewFileSpanExporter(fileConf.GetPath())
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+	return NewTracer(logger, tracingConf, exporters...)
+}
+
+func buildAuthorizer(conf *config.Configuration) (*Authorizer, error) {
+	authzConf := conf.GetAuthorization()
+	roles := make(map[string][]*AccessRule)
+	for roleName, roleConf := range authzConf.GetRoles() {
+		rules := make([]*AccessRule, 0)
+		for _, ruleConf := range roleConf.GetRules() {
+			rules = append(rules, &AccessRule{
+				Resources: ruleConf.GetResources(),
+				Methods: ruleConf.GetMethods(),
+				Control: ruleConf.GetControl(),
+			})
+		}
+		roles[roleName] = rules
+	}
+	bindings := make([]*RoleBinding, 0)
+	for _, bindingConf := range authzConf.GetBindings() {
+		bindings = append(bindings, &RoleBinding{
+			Role: bindingConf.GetRole(),
+			Principals: bindingConf.GetPrincipals(),
+			Claims: bindingConf.GetClaims(),
+			ApiKeyLabels: bindingConf.GetApiKeyLabels(),
+			CertSubjects: bindingConf.GetCertSubjects(),
+			Anonymous: bindingConf.GetAnonymous(),
+		})
+	}
+	return NewAuthorizer(roles, bindings)
+}
+
 func buildHttpAddr(conf *config.Configuration) string {
 	c := conf.GetHttpServer()
 	host := c.GetHost()
@@ -765,6 +2154,8 @@ func filterDuplicatedPatterns(patterns map[string][]string) []string {
 const CTRL_BASEURL string = `/_`
 const EXEC_BASEURL string = `/-`
 const DEFAULT_PORT uint = 17779
+const DEFAULT_SHUTDOWN_TIMEOUT time.Duration = 10 * time.Second
+const DEFAULT_KILL_GRACE_PERIOD time.Duration = 5 * time.Second
 const OPWIRE_EDITION_PREFIX string = "OPWIRE_EDITION"
 const OPWIRE_EDITION_PREFIX_PLUS string = OPWIRE_EDITION_PREFIX + "="
 const OPWIRE_REQUEST_PREFIX string = "OPWIRE_REQUEST"
@@ -780,3 +2171,4 @@ const REQ_HEADER_EXPLAIN_FAILURE string = "Opwire-Explain-Failure"
 
 const RES_HEADER_ERROR_MESSAGE string = "X-Error-Message"
 const RES_HEADER_EXEC_DURATION string = "X-Exec-Duration"
+const RES_HEADER_CACHE string = "X-Cache"
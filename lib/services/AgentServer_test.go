@@ -1,7 +1,14 @@
 package services
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 	"github.com/stretchr/testify/assert"
 	"github.com/opwire/opwire-agent/lib/utils"
 )
@@ -21,6 +28,286 @@ func TestNewAgentServer(t *testing.T) {
 		assert.NotNil(t, s.stateStore)
 		assert.NotNil(t, s.executor)
 	})
+
+	for _, tc := range []struct {
+		name string
+		config string
+		expectedError string
+	}{
+		{
+			name: "invalid lock timeout",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"backup": {
+						"default": { "command": "echo" },
+						"locks": [ "db-maintenance" ],
+						"lock-timeout": ""
+					}
+				}
+			}`,
+			expectedError: "lock-timeout",
+		},
+		{
+			name: "unsupported lock mode",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"backup": {
+						"default": { "command": "echo" },
+						"locks": [ "db-maintenance:readonly" ]
+					}
+				}
+			}`,
+			expectedError: "locks",
+		},
+		{
+			name: "proxy protocol without trusted proxies",
+			config: `{
+				"version": "1.0.0",
+				"http-server": {
+					"proxy-protocol": true
+				}
+			}`,
+			expectedError: "trusted-proxies",
+		},
+		{
+			name: "malformed client certificate pattern",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"payments": {
+						"default": { "command": "echo" },
+						"client-cert": {
+							"subjects": [ "orders-[service" ]
+						}
+					}
+				}
+			}`,
+			expectedError: "malformed",
+		},
+		{
+			name: "undeclared authentication provider",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"deploy": {
+						"default": { "command": "echo" },
+						"auth": {
+							"enabled": true,
+							"providers": [ "jwt" ]
+						}
+					}
+				}
+			}`,
+			expectedError: "jwt",
+		},
+		{
+			name: "unset signature secret",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"github": {
+						"default": { "command": "echo" },
+						"signature": {
+							"header": "X-Hub-Signature-256",
+							"secret-env": "OPWIRE_TEST_UNSET_WEBHOOK_SECRET"
+						}
+					}
+				}
+			}`,
+			expectedError: "Signature verifier",
+		},
+		{
+			name: "unparsable access list address",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"reports": {
+						"default": { "command": "echo" },
+						"access": {
+							"allow": [ "999.1.1.1/99" ]
+						}
+					}
+				}
+			}`,
+			expectedError: "Access lists",
+		},
+		{
+			name: "empty cache ttl",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"reports": {
+						"default": { "command": "echo" },
+						"cache": {
+							"ttl": ""
+						}
+					}
+				}
+			}`,
+			expectedError: "ttl",
+		},
+		{
+			name: "concurrent limit without a total",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"reports": {
+						"default": { "command": "echo" },
+						"concurrent-limit": {
+							"enabled": true
+						}
+					}
+				}
+			}`,
+			expectedError: "Concurrent limit",
+		},
+		{
+			name: "empty concurrent limit max-wait of a method",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"reports": {
+						"default": { "command": "echo" },
+						"methods": {
+							"POST": {
+								"command": "echo",
+								"concurrent-limit": {
+									"total": 1,
+									"max-wait": ""
+								}
+							}
+						}
+					}
+				}
+			}`,
+			expectedError: "max-wait",
+		},
+		{
+			name: "zero rate limit",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"reports": {
+						"default": { "command": "echo" },
+						"rate-limit": {
+							"rate": 0
+						}
+					}
+				}
+			}`,
+			expectedError: "rate",
+		},
+		{
+			name: "empty circuit breaker window",
+			config: `{
+				"version": "1.0.0",
+				"resources": {
+					"reports": {
+						"default": { "command": "echo" },
+						"circuit-breaker": {
+							"window": ""
+						}
+					}
+				}
+			}`,
+			expectedError: "window",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name + " refuses to start", func(t *testing.T) {
+			configPath, cleanup := writeAgentConfig(t, tc.config)
+			defer cleanup()
+
+			s, err := NewAgentServer(&AgentServerOptionsTest{ ConfigPath: configPath })
+			assert.Nil(t, s)
+			if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), tc.expectedError)
+			}
+		})
+	}
+
+	t.Run("health endpoint follows the control access lists", func(t *testing.T) {
+		configPath, cleanup := writeAgentConfig(t, `{
+			"version": "1.0.0",
+			"access": {
+				"control": {
+					"allow": [ "127.0.0.1" ]
+				}
+			}
+		}`)
+		defer cleanup()
+
+		s, err := NewAgentServer(&AgentServerOptionsTest{ ConfigPath: configPath })
+		assert.Nil(t, err)
+		for remoteAddr, allowed := range map[string]bool{ "127.0.0.1:40000": true, "192.0.2.10:40000": false } {
+			r := httptest.NewRequest("GET", "/_/health", nil)
+			r.RemoteAddr = remoteAddr
+			w := httptest.NewRecorder()
+			s.httpRouter.ServeHTTP(w, r)
+			assert.Equal(t, allowed, w.Code != http.StatusForbidden, remoteAddr)
+		}
+	})
+}
+
+// writeAgentConfig() writes a configuration file into a temporary directory.
+func writeAgentConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "opwire-agent")
+	assert.Nil(t, err)
+	configPath := filepath.Join(dir, "opwire-agent.json")
+	assert.Nil(t, ioutil.WriteFile(configPath, []byte(content), 0644))
+	return configPath, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAgentServer_Shutdown(t *testing.T) {
+	t.Run("shutdown returns as soon as nothing is running", func(t *testing.T) {
+		s, err := NewAgentServer(&AgentServerOptionsTest{})
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+		defer cancel()
+		startTime := time.Now()
+		assert.Nil(t, s.Shutdown(ctx))
+		assert.True(t, time.Since(startTime) < time.Second)
+		assert.False(t, s.isReady())
+	})
+
+	t.Run("shutdown makes Start() return", func(t *testing.T) {
+		configPath, cleanup := writeAgentConfig(t, `{
+			"version": "1.0.0",
+			"http-server": {
+				"listeners": [
+					{ "name": "default", "address": "127.0.0.1:0" }
+				]
+			}
+		}`)
+		defer cleanup()
+
+		s, err := NewAgentServer(&AgentServerOptionsTest{ ConfigPath: configPath })
+		assert.Nil(t, err)
+
+		started := make(chan error, 1)
+		go func() {
+			started <- s.Start()
+		}()
+		for i := 0; i < 100 && !s.isReady(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.True(t, s.isReady())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+		defer cancel()
+		assert.Nil(t, s.Shutdown(ctx))
+		select {
+		case err := <-started:
+			assert.Nil(t, err)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "Start() has not returned after Shutdown()")
+		}
+	})
 }
 
 type AgentServerOptionsTest struct {
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Principal is the authenticated identity of a request, it is passed to the commands in the
+// principal field of the request packet.
+type Principal struct {
+	Provider string `json:"provider"`
+	Name string `json:"name"`
+	Labels []string `json:"labels,omitempty"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// AuthProvider verifies the credentials of a kind. Authenticate() returns a nil principal
+// without any error when the request does not carry the credentials of the provider.
+type AuthProvider interface {
+	GetName() string
+	Authenticate(r *http.Request) (*Principal, error)
+	Challenge(realm string) string
+}
+
+// Authenticator verifies the credentials with the providers which are accepted by the
+// resource (all of the providers by default). The resources without their own policy, and
+// the control endpoints, follow the global requirement.
+type Authenticator struct {
+	realm string
+	providers []AuthProvider
+	defaultPolicy *authPolicy
+	policies map[string]*authPolicy
+}
+
+type authPolicy struct {
+	required bool
+	providers []AuthProvider
+}
+
+type principalContextKey struct{}
+
+func NewAuthenticator(realm string, required bool, providers ...AuthProvider) *Authenticator {
+	a := new(Authenticator)
+	a.realm = realm
+	a.providers = providers
+	a.defaultPolicy = &authPolicy{ required: required, providers: providers }
+	a.policies = make(map[string]*authPolicy)
+	return a
+}
+
+// Register() declares whether the resource requires the authentication, and which providers
+// are accepted (an empty list accepts all of them).
+func (a *Authenticator) Register(resourceName string, required bool, providerNames []string) error {
+	policy := &authPolicy{ required: required, providers: a.providers }
+	if len(providerNames) > 0 {
+		policy.providers = make([]AuthProvider, 0)
+		for _, name := range providerNames {
+			provider := a.findProvider(name)
+			if provider == nil {
+				return fmt.Errorf("Authentication provider [%s] is not declared", name)
+			}
+			policy.providers = append(policy.providers, provider)
+		}
+	}
+	a.policies[normalizeResourceName(resourceName)] = policy
+	return nil
+}
+
+func (a *Authenticator) IsRequired(resourceName string) bool {
+	return a.findPolicy(resourceName).required
+}
+
+// Authenticate() returns a nil principal when the resource does not require the authentication.
+func (a *Authenticator) Authenticate(r *http.Request, resourceName string) (*Principal, error) {
+	policy := a.findPolicy(resourceName)
+	if !policy.required {
+		return nil, nil
+	}
+	for _, provider := range policy.providers {
+		principal, err := provider.Authenticate(r)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] %s", provider.GetName(), err.Error())
+		}
+		if principal != nil {
+			return principal, nil
+		}
+	}
+	return nil, errCredentialsRequired
+}
+
+// PublicMessage() returns the message of an authentication error which is sent to the client,
+// the details (e.g. an unknown user) are only logged.
+func (a *Authenticator) PublicMessage(err error) string {
+	if err == errCredentialsRequired {
+		return err.Error()
+	}
+	return AUTH_MESSAGE_INVALID_CREDENTIALS
+}
+
+var errCredentialsRequired = fmt.Errorf("Credentials are required")
+
+// WriteChallenge() sets the WWW-Authenticate headers of the providers accepted by the resource.
+func (a *Authenticator) WriteChallenge(w http.ResponseWriter, resourceName string) {
+	for _, provider := range a.findPolicy(resourceName).providers {
+		if challenge := provider.Challenge(a.realm); len(challenge) > 0 {
+			w.Header().Add("WWW-Authenticate", challenge)
+		}
+	}
+}
+
+func (a *Authenticator) findPolicy(resourceName string) *authPolicy {
+	if policy, ok := a.policies[normalizeResourceName(resourceName)]; ok {
+		return policy
+	}
+	return a.defaultPolicy
+}
+
+func (a *Authenticator) findProvider(name string) AuthProvider {
+	for _, provider := range a.providers {
+		if provider.GetName() == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+func withPrincipal(r *http.Request, principal *Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal))
+}
+
+func extractPrincipal(r *http.Request) *Principal {
+	if principal, ok := r.Context().Value(principalContextKey{}).(*Principal); ok {
+		return principal
+	}
+	return nil
+}
+
+// ApiKey is an entry of the static API keys, the keys file contains a JSON array of entries.
+type ApiKey struct {
+	Name string `json:"name"`
+	Key string `json:"key"`
+	Labels []string `json:"labels"`
+	ExpiresAt *time.Time `json:"expires-at"`
+}
+
+// ApiKeyProvider authenticates the requests with a static API key in a header.
+type ApiKeyProvider struct {
+	header string
+	keys []*ApiKey
+}
+
+func NewApiKeyProvider(header string, keys []*ApiKey, keysFile string) (*ApiKeyProvider, error) {
+	p := new(ApiKeyProvider)
+	p.header = header
+	if len(p.header) == 0 {
+		p.header = DEFAULT_API_KEY_HEADER
+	}
+	p.keys = append(p.keys, keys...)
+	if len(keysFile) > 0 {
+		data, err := ioutil.ReadFile(keysFile)
+		if err != nil {
+			return nil, err
+		}
+		fileKeys := make([]*ApiKey, 0)
+		if err := json.Unmarshal(data, &fileKeys); err != nil {
+			return nil, fmt.Errorf("API keys file [%s] is malformed: %s", keysFile, err.Error())
+		}
+		p.keys = append(p.keys, fileKeys...)
+	}
+	for _, key := range p.keys {
+		if key == nil || len(key.Name) == 0 || len(key.Key) == 0 {
+			return nil, fmt.Errorf("API key must declare both of name and key")
+		}
+	}
+	return p, nil
+}
+
+func (p *ApiKeyProvider) GetName() string {
+	return AUTH_PROVIDER_API_KEY
+}
+
+func (p *ApiKeyProvider) Authenticate(r *http.Request) (*Principal, error) {
+	value := r.Header.Get(p.header)
+	if len(value) == 0 {
+		return nil, nil
+	}
+	var found *ApiKey
+	for _, key := range p.keys {
+		// all of the keys are compared to not leak the position of the matched one
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(value)) == 1 {
+			found = key
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("API key is invalid")
+	}
+	if found.ExpiresAt != nil && time.Now().After(*found.ExpiresAt) {
+		return nil, fmt.Errorf("API key [%s] has expired", found.Name)
+	}
+	return &Principal{ Provider: AUTH_PROVIDER_API_KEY, Name: found.Name, Labels: found.Labels }, nil
+}
+
+func (p *ApiKeyProvider) Challenge(realm string) string {
+	return ""
+}
+
+// BasicAuthProvider authenticates the requests with the users of a htpasswd file, only the
+// bcrypt hashes ($2y$, $2a$, $2b$) are supported. The password of an unknown user is compared
+// with a dummy hash, so that the response time does not reveal the existing users.
+type BasicAuthProvider struct {
+	users map[string][]byte
+	dummyHash []byte
+}
+
+func NewBasicAuthProvider(htpasswdFile string) (*BasicAuthProvider, error) {
+	file, err := os.Open(htpasswdFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	p := new(BasicAuthProvider)
+	p.users = make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("htpasswd file [%s] is malformed at line %d", htpasswdFile, lineNo)
+		}
+		if !strings.HasPrefix(parts[1], "$2") {
+			return nil, fmt.Errorf("htpasswd file [%s] has an unsupported hash at line %d, bcrypt is required", htpasswdFile, lineNo)
+		}
+		p.users[parts[0]] = []byte(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	cost := bcrypt.DefaultCost
+	for _, hash := range p.users {
+		if c, err := bcrypt.Cost(hash); err == nil && c > cost {
+			cost = c
+		}
+	}
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte(AUTH_PROVIDER_BASIC), cost)
+	if err != nil {
+		return nil, err
+	}
+	p.dummyHash = dummyHash
+	return p, nil
+}
+
+func (p *BasicAuthProvider) GetName() string {
+	return AUTH_PROVIDER_BASIC
+}
+
+func (p *BasicAuthProvider) Authenticate(r *http.Request) (*Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	hash, found := p.users[username]
+	if !found {
+		bcrypt.CompareHashAndPassword(p.dummyHash, []byte(password))
+		return nil, fmt.Errorf("User [%s] is unknown", username)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return nil, fmt.Errorf("Password of user [%s] is invalid", username)
+	}
+	return &Principal{ Provider: AUTH_PROVIDER_BASIC, Name: username }, nil
+}
+
+func (p *BasicAuthProvider) Challenge(realm string) string {
+	return fmt.Sprintf("Basic realm=%q", realm)
+}
+
+const AUTH_PROVIDER_API_KEY string = "api-key"
+const AUTH_PROVIDER_BASIC string = "basic"
+const AUTH_PROVIDER_JWT string = "jwt"
+const DEFAULT_API_KEY_HEADER string = "X-Api-Key"
+const AUTH_CONTROL_RESOURCE string = ":control:"
+const AUTH_MESSAGE_INVALID_CREDENTIALS string = "Credentials are invalid"
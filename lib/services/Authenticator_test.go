@@ -0,0 +1,134 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestApiKeyProvider(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	p, err := NewApiKeyProvider("", []*ApiKey{
+		&ApiKey{ Name: "ci", Key: "s3cr3t", Labels: []string{ "deployer" } },
+		&ApiKey{ Name: "legacy", Key: "0ld", ExpiresAt: &expired },
+	}, "")
+	assert.Nil(t, err)
+
+	t.Run("request without the header has no credentials", func(t *testing.T) {
+		principal, err := p.Authenticate(httptest.NewRequest("GET", "/", nil))
+		assert.Nil(t, principal)
+		assert.Nil(t, err)
+	})
+
+	t.Run("valid key returns the principal with the labels", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Api-Key", "s3cr3t")
+		principal, err := p.Authenticate(r)
+		assert.Nil(t, err)
+		assert.Equal(t, &Principal{ Provider: AUTH_PROVIDER_API_KEY, Name: "ci", Labels: []string{ "deployer" } }, principal)
+	})
+
+	t.Run("unknown or expired keys are rejected", func(t *testing.T) {
+		for _, key := range []string{ "unknown", "0ld" } {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Api-Key", key)
+			principal, err := p.Authenticate(r)
+			assert.Nil(t, principal)
+			assert.NotNil(t, err)
+		}
+	})
+
+	t.Run("keys are loaded from a file", func(t *testing.T) {
+		dir, _ := ioutil.TempDir("", "opwire-auth")
+		defer os.RemoveAll(dir)
+		keysFile := filepath.Join(dir, "api-keys.json")
+		ioutil.WriteFile(keysFile, []byte(`[{"name": "reporter", "key": "r3p0rt", "expires-at": "2999-01-01T00:00:00Z"}]`), 0600)
+		fp, err := NewApiKeyProvider("X-Token", nil, keysFile)
+		assert.Nil(t, err)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Token", "r3p0rt")
+		principal, err := fp.Authenticate(r)
+		assert.Nil(t, err)
+		assert.Equal(t, "reporter", principal.Name)
+	})
+}
+
+func TestBasicAuthProvider(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "opwire-auth")
+	defer os.RemoveAll(dir)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pa55"), bcrypt.MinCost)
+	htpasswdFile := filepath.Join(dir, "htpasswd")
+	ioutil.WriteFile(htpasswdFile, []byte("# operators\nalice:" + string(hash) + "\n"), 0600)
+
+	p, err := NewBasicAuthProvider(htpasswdFile)
+	assert.Nil(t, err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "pa55")
+	principal, err := p.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{ Provider: AUTH_PROVIDER_BASIC, Name: "alice" }, principal)
+
+	r.SetBasicAuth("alice", "wrong")
+	_, err = p.Authenticate(r)
+	assert.NotNil(t, err)
+
+	r.SetBasicAuth("bob", "pa55")
+	_, err = p.Authenticate(r)
+	assert.NotNil(t, err)
+	assert.Equal(t, AUTH_MESSAGE_INVALID_CREDENTIALS, NewAuthenticator("", true, p).PublicMessage(err))
+
+	t.Run("non-bcrypt hashes are refused", func(t *testing.T) {
+		ioutil.WriteFile(htpasswdFile, []byte("alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600)
+		_, err := NewBasicAuthProvider(htpasswdFile)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestAuthenticator(t *testing.T) {
+	apiKeys, _ := NewApiKeyProvider("", []*ApiKey{ &ApiKey{ Name: "ci", Key: "s3cr3t" } }, "")
+	jwt, _ := NewJWTVerifier(&JWTOptionsTest{ Secret: "hmac-secret" })
+	a := NewAuthenticator("opwire", true, jwt, apiKeys)
+	assert.Nil(t, a.Register("public", false, nil))
+	assert.Nil(t, a.Register("tokens-only", true, []string{ AUTH_PROVIDER_JWT }))
+	assert.NotNil(t, a.Register("unknown", true, []string{ AUTH_PROVIDER_BASIC }))
+
+	withKey := httptest.NewRequest("GET", "/", nil)
+	withKey.Header.Set("X-Api-Key", "s3cr3t")
+
+	t.Run("resources follow the global requirement by default", func(t *testing.T) {
+		principal, err := a.Authenticate(withKey, "reports")
+		assert.Nil(t, err)
+		assert.Equal(t, "ci", principal.Name)
+		_, err = a.Authenticate(httptest.NewRequest("GET", "/", nil), AUTH_CONTROL_RESOURCE)
+		assert.NotNil(t, err)
+		assert.Equal(t, "Credentials are required", a.PublicMessage(err))
+	})
+
+	t.Run("public resource does not require credentials", func(t *testing.T) {
+		principal, err := a.Authenticate(httptest.NewRequest("GET", "/", nil), "public")
+		assert.Nil(t, principal)
+		assert.Nil(t, err)
+	})
+
+	t.Run("resource accepts its own providers only", func(t *testing.T) {
+		_, err := a.Authenticate(withKey, "tokens-only")
+		assert.NotNil(t, err)
+		assert.Equal(t, "Credentials are required", a.PublicMessage(err))
+		w := httptest.NewRecorder()
+		a.WriteChallenge(w, "tokens-only")
+		assert.Equal(t, []string{ `Bearer realm="opwire"` }, w.Header()["Www-Authenticate"])
+	})
+
+	t.Run("principal is stored in the request context", func(t *testing.T) {
+		principal := &Principal{ Provider: AUTH_PROVIDER_API_KEY, Name: "ci" }
+		r := withPrincipal(httptest.NewRequest("GET", "/", nil), principal)
+		assert.Equal(t, principal, extractPrincipal(r))
+		assert.Nil(t, extractPrincipal(httptest.NewRequest("GET", "/", nil)))
+	})
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"github.com/opwire/opwire-agent/lib/invokers"
+)
+
+// Authorizer grants the roles to the callers with the role bindings, and checks the rules of
+// the roles against the resources & methods, or against the control endpoints.
+type Authorizer struct {
+	roles map[string][]*AccessRule
+	bindings []*RoleBinding
+}
+
+// AccessRule allows the methods on the resources (the main resource is named "main-resource")
+// and/or the control endpoints (e.g. "lock", "cache/purge"). The patterns accept wildcards.
+type AccessRule struct {
+	Resources []string
+	Methods []string
+	Control []string
+}
+
+// RoleBinding grants a role to the callers matching one of its selectors, an anonymous
+// binding grants it to the callers without any principal or client certificate.
+type RoleBinding struct {
+	Role string
+	Principals []string
+	Claims map[string]string
+	ApiKeyLabels []string
+	CertSubjects []string
+	Anonymous bool
+}
+
+func NewAuthorizer(roles map[string][]*AccessRule, bindings []*RoleBinding) (*Authorizer, error) {
+	a := new(Authorizer)
+	a.roles = make(map[string][]*AccessRule)
+	for role, rules := range roles {
+		for _, rule := range rules {
+			for _, pattern := range append(append(append([]string{}, rule.Resources...), rule.Methods...), rule.Control...) {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("Role [%s] has a malformed pattern [%s]", role, pattern)
+				}
+			}
+		}
+		a.roles[role] = rules
+	}
+	for _, binding := range bindings {
+		if _, ok := a.roles[binding.Role]; !ok {
+			return nil, fmt.Errorf("Role binding refers to an undeclared role [%s]", binding.Role)
+		}
+		a.bindings = append(a.bindings, binding)
+	}
+	return a, nil
+}
+
+// Roles() returns the sorted names of the roles granted to the caller.
+func (a *Authorizer) Roles(principal *Principal, identity *ClientIdentity) []string {
+	granted := make(map[string]bool)
+	for _, binding := range a.bindings {
+		if !granted[binding.Role] && binding.matches(principal, identity) {
+			granted[binding.Role] = true
+		}
+	}
+	roles := make([]string, 0, len(granted))
+	for role := range granted {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Allow() checks whether one of the roles allows the method on the resource.
+func (a *Authorizer) Allow(roles []string, resourceName string, method string) bool {
+	if normalizeResourceName(resourceName) == invokers.MAIN_RESOURCE {
+		resourceName = AUTHZ_MAIN_RESOURCE
+	}
+	method = strings.ToUpper(method)
+	return a.anyRule(roles, func(rule *AccessRule) bool {
+		return matchAnyPattern(rule.Resources, resourceName) && matchAnyPattern(rule.Methods, method)
+	})
+}
+
+// AllowControl() checks whether one of the roles allows the control endpoint.
+func (a *Authorizer) AllowControl(roles []string, endpoint string) bool {
+	return a.anyRule(roles, func(rule *AccessRule) bool {
+		return matchAnyPattern(rule.Control, endpoint)
+	})
+}
+
+func (a *Authorizer) anyRule(roles []string, match func(*AccessRule) bool) bool {
+	for _, role := range roles {
+		for _, rule := range a.roles[role] {
+			if match(rule) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (b *RoleBinding) matches(principal *Principal, identity *ClientIdentity) bool {
+	if principal == nil && identity == nil {
+		return b.Anonymous
+	}
+	if principal != nil {
+		if matchAnyPattern(b.Principals, principal.Name) {
+			return true
+		}
+		if principal.Provider == AUTH_PROVIDER_API_KEY && matchAnyPattern(b.ApiKeyLabels, principal.Labels...) {
+			return true
+		}
+		if len(b.Claims) > 0 && matchClaims(b.Claims, principal.Claims) {
+			return true
+		}
+	}
+	if identity != nil && matchAnyPattern(b.CertSubjects, identity.Subject, identity.CommonName) {
+		return true
+	}
+	return false
+}
+
+// matchClaims() requires all of the expected claims, an array claim matches when it contains
+// the expected value (e.g. "groups": ["ops", "dev"]).
+func matchClaims(expected map[string]string, claims map[string]interface{}) bool {
+	if claims == nil {
+		return false
+	}
+	for name, value := range expected {
+		found := false
+		switch claim := claims[name].(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range claim {
+				if fmt.Sprint(item) == value {
+					found = true
+					break
+				}
+			}
+		default:
+			found = fmt.Sprint(claim) == value
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+const AUTHZ_MAIN_RESOURCE string = "main-resource"
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizer(t *testing.T) {
+	a, err := NewAuthorizer(map[string][]*AccessRule{
+		"ops": []*AccessRule{
+			&AccessRule{ Resources: []string{ "product" }, Methods: []string{ "GET", "DELETE" } },
+			&AccessRule{ Control: []string{ "lock", "unlock" } },
+		},
+		"reporter": []*AccessRule{
+			&AccessRule{ Resources: []string{ "products", "main-resource" }, Methods: []string{ "GET" } },
+		},
+		"admin": []*AccessRule{
+			&AccessRule{ Resources: []string{ "*" }, Methods: []string{ "*" }, Control: []string{ "*" } },
+		},
+	}, []*RoleBinding{
+		&RoleBinding{ Role: "ops", Claims: map[string]string{ "groups": "ops" } },
+		&RoleBinding{ Role: "reporter", ApiKeyLabels: []string{ "reporting" } },
+		&RoleBinding{ Role: "admin", Principals: []string{ "alice" } },
+		&RoleBinding{ Role: "ops", CertSubjects: []string{ "ops-*" } },
+	})
+	assert.Nil(t, err)
+
+	t.Run("roles are granted by the JWT claims", func(t *testing.T) {
+		claims := make(map[string]interface{})
+		json.Unmarshal([]byte(`{"sub": "bob", "groups": ["dev", "ops"]}`), &claims)
+		roles := a.Roles(&Principal{ Provider: AUTH_PROVIDER_JWT, Name: "bob", Claims: claims }, nil)
+		assert.Equal(t, []string{ "ops" }, roles)
+		assert.True(t, a.Allow(roles, "product", "delete"))
+		assert.False(t, a.Allow(roles, "product", "POST"))
+		assert.False(t, a.Allow(roles, "products", "GET"))
+		assert.True(t, a.AllowControl(roles, "lock"))
+		assert.False(t, a.AllowControl(roles, "cache/purge"))
+	})
+
+	t.Run("roles are granted by the API key labels", func(t *testing.T) {
+		roles := a.Roles(&Principal{ Provider: AUTH_PROVIDER_API_KEY, Name: "bot", Labels: []string{ "reporting" } }, nil)
+		assert.Equal(t, []string{ "reporter" }, roles)
+		assert.True(t, a.Allow(roles, "products", "GET"))
+		assert.True(t, a.Allow(roles, "", "GET"))
+		assert.False(t, a.Allow(roles, "products", "DELETE"))
+	})
+
+	t.Run("roles are granted by the principal names and the certificate subjects", func(t *testing.T) {
+		roles := a.Roles(&Principal{ Provider: AUTH_PROVIDER_BASIC, Name: "alice" }, &ClientIdentity{ Subject: "CN=ops-runner", CommonName: "ops-runner" })
+		assert.Equal(t, []string{ "admin", "ops" }, roles)
+		assert.True(t, a.AllowControl(roles, "cache/purge"))
+	})
+
+	t.Run("caller without any role is denied", func(t *testing.T) {
+		roles := a.Roles(nil, nil)
+		assert.Equal(t, []string{}, roles)
+		assert.False(t, a.Allow(roles, "products", "GET"))
+		assert.False(t, a.AllowControl(roles, "lock"))
+	})
+
+	t.Run("anonymous callers are granted the roles of the anonymous bindings only", func(t *testing.T) {
+		b, err := NewAuthorizer(map[string][]*AccessRule{
+			"public": []*AccessRule{
+				&AccessRule{ Resources: []string{ "status" }, Methods: []string{ "GET" } },
+			},
+		}, []*RoleBinding{
+			&RoleBinding{ Role: "public", Anonymous: true },
+		})
+		assert.Nil(t, err)
+		roles := b.Roles(nil, nil)
+		assert.Equal(t, []string{ "public" }, roles)
+		assert.True(t, b.Allow(roles, "status", "GET"))
+		assert.False(t, b.AllowControl(roles, "restart"))
+		assert.Equal(t, []string{}, b.Roles(&Principal{ Provider: AUTH_PROVIDER_BASIC, Name: "bob" }, nil))
+	})
+
+	t.Run("binding to an undeclared role is refused", func(t *testing.T) {
+		_, err := NewAuthorizer(map[string][]*AccessRule{}, []*RoleBinding{ &RoleBinding{ Role: "ghost" } })
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"net/http"
+	"github.com/opwire/opwire-agent/lib/invokers"
+)
+
+// Broker consumes messages from a message source and hands them over to the handler,
+// the message is acknowledged when the handler succeeds, otherwise it is dead-lettered.
+type Broker interface {
+	GetName() string
+	Start(handler BrokerHandler) error
+	Stop() error
+}
+
+type BrokerHandler func(msg *BrokerMessage) *BrokerResult
+
+type BrokerMessage struct {
+	Id string
+	Header http.Header
+	Body []byte
+	Origin *RequestOrigin
+}
+
+type BrokerResult struct {
+	State *invokers.ExecutionState
+	Stdout []byte
+	Stderr []byte
+	Error error
+	Retry bool
+}
+
+func (r *BrokerResult) Failed() bool {
+	return r == nil || r.Error != nil || (r.State != nil && r.State.IsTimeout)
+}
+
+func (r *BrokerResult) ErrorMessage() string {
+	if r == nil {
+		return "Message has not been processed"
+	}
+	if r.Error != nil {
+		return r.Error.Error()
+	}
+	if r.State != nil && r.State.IsTimeout {
+		return "Running processes are killed"
+	}
+	return ""
+}
+
+const BROKER_TYPE_NATS string = "nats"
+const BROKER_TYPE_SPOOL string = "spool"
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"github.com/fsnotify/fsnotify"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+// CertReloader serves the TLS certificate through tls.Config.GetCertificate, so that the
+// certificate can be replaced (when its files change, or on SIGHUP) without restarting the
+// listener. The established connections keep their certificate.
+type CertReloader struct {
+	certFile string
+	keyFile string
+	lock sync.RWMutex
+	cert *tls.Certificate
+	watcher *fsnotify.Watcher
+	stopChan chan struct{}
+	stopped sync.WaitGroup
+	logger *loq.Logger
+}
+
+type TLSOptions interface {
+	GetCertFile() string
+	GetKeyFile() string
+	GetMinVersion() string
+	GetCipherSuites() []string
+	GetClientCAFile() string
+	GetClientAuth() string
+}
+
+func NewCertReloader(certFile string, keyFile string, logger *loq.Logger) (*CertReloader, error) {
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil, fmt.Errorf("TLS must declare both of cert-file and key-file")
+	}
+	c := new(CertReloader)
+	c.certFile = certFile
+	c.keyFile = keyFile
+	c.logger = logger
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload() loads the certificate from disk, the current certificate is kept if it fails.
+func (c *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+// Watch() reloads the certificate when its files are changed. The directories are watched
+// instead of the files, because the certificates are usually replaced by renaming.
+func (c *CertReloader) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	files := make(map[string]bool)
+	for _, file := range []string{ c.certFile, c.keyFile } {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+		files[abs] = true
+		if err := watcher.Add(filepath.Dir(abs)); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	c.watcher = watcher
+	c.stopChan = make(chan struct{})
+	c.stopped.Add(1)
+	go func() {
+		defer c.stopped.Done()
+		// the cert & key files are often written one after another, the reloading is delayed
+		var timer <-chan time.Time
+		for {
+			select {
+			case <-c.stopChan:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if abs, err := filepath.Abs(event.Name); err == nil && files[abs] {
+					timer = time.After(DEFAULT_CERT_RELOAD_DELAY)
+				}
+			case <-timer:
+				timer = nil
+				c.reloadAndLog("files changed")
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				c.logger.Log(loq.ErrorLevel, "Watching certificate files failed", loq.Error(err))
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *CertReloader) Stop() error {
+	if c.stopChan == nil {
+		return nil
+	}
+	close(c.stopChan)
+	err := c.watcher.Close()
+	c.stopped.Wait()
+	c.stopChan = nil
+	return err
+}
+
+func (c *CertReloader) reloadAndLog(reason string) {
+	if err := c.Reload(); err != nil {
+		c.logger.Log(loq.ErrorLevel, "Reloading certificate failed, the current one is kept",
+			loq.String("reason", reason),
+			loq.Error(err))
+		return
+	}
+	c.logger.Log(loq.InfoLevel, "Certificate has been reloaded",
+		loq.String("reason", reason),
+		loq.String("certFile", c.certFile))
+}
+
+// BuildTLSConfig() creates the server TLS configuration with the minimum version and the
+// cipher suites policy (the cipher suites are applied to TLS 1.2 and older only), and the
+// verification of the client certificates against the client CA bundle.
+func BuildTLSConfig(opts TLSOptions, reloader *CertReloader) (*tls.Config, error) {
+	cfg := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion: tls.VersionTLS12,
+	}
+	if v := opts.GetMinVersion(); len(v) > 0 {
+		version, ok := tlsVersions[v]
+		if !ok {
+			return nil, fmt.Errorf("TLS version [%s] is not supported", v)
+		}
+		cfg.MinVersion = version
+	}
+	for _, name := range opts.GetCipherSuites() {
+		id, ok := tlsCipherSuites[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("TLS cipher suite [%s] is not supported", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	clientAuth, ok := tlsClientAuthTypes[opts.GetClientAuth()]
+	if !ok {
+		return nil, fmt.Errorf("TLS client-auth [%s] is not supported", opts.GetClientAuth())
+	}
+	if clientAuth != tls.NoClientCert {
+		pool, err := loadCertPool(opts.GetClientCAFile())
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = clientAuth
+	}
+	return cfg, nil
+}
+
+// loadCertPool() loads the PEM encoded CA bundle which verifies the client certificates.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	if len(caFile) == 0 {
+		return nil, fmt.Errorf("TLS client authentication requires a client-ca-file")
+	}
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("Client CA file [%s] does not contain any PEM certificate", caFile)
+	}
+	return pool, nil
+}
+
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"": tls.NoClientCert,
+	"none": tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA": tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA": tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256": tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384": tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA": tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA": tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA": tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305": tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+const DEFAULT_CERT_RELOAD_DELAY time.Duration = 200 * time.Millisecond
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+func TestCertReloader(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+	dir, _ := ioutil.TempDir("", "opwire-certs")
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	writeTestCertificate(t, certFile, keyFile, "first")
+	c, err := NewCertReloader(certFile, keyFile, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", getTestCertificateName(t, c))
+
+	t.Run("certificate is reloaded when the files change", func(t *testing.T) {
+		assert.Nil(t, c.Watch())
+		defer c.Stop()
+		writeTestCertificate(t, certFile, keyFile, "second")
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) && getTestCertificateName(t, c) != "second" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		assert.Equal(t, "second", getTestCertificateName(t, c))
+	})
+
+	t.Run("current certificate is kept when reloading fails", func(t *testing.T) {
+		ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+		assert.NotNil(t, c.Reload())
+		assert.Equal(t, "second", getTestCertificateName(t, c))
+	})
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	cfg, err := BuildTLSConfig(&TLSOptionsTest{ MinVersion: "1.3" }, &CertReloader{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+
+	cfg, err = BuildTLSConfig(&TLSOptionsTest{ CipherSuites: []string{ "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" } }, &CertReloader{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, []uint16{ tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 }, cfg.CipherSuites)
+
+	_, err = BuildTLSConfig(&TLSOptionsTest{ CipherSuites: []string{ "TLS_UNKNOWN" } }, &CertReloader{})
+	assert.NotNil(t, err)
+}
+
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{ CommonName: commonName },
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{ Type: "EC PRIVATE KEY", Bytes: keyDer }), 0600)
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{ Type: "CERTIFICATE", Bytes: der }), 0644)
+}
+
+func getTestCertificateName(t *testing.T, c *CertReloader) string {
+	cert, _ := c.GetCertificate(nil)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.Nil(t, err)
+	return parsed.Subject.CommonName
+}
+
+type TLSOptionsTest struct {
+	MinVersion string
+	CipherSuites []string
+	ClientCAFile string
+	ClientAuth string
+}
+
+func (o *TLSOptionsTest) GetCertFile() string {
+	return ""
+}
+
+func (o *TLSOptionsTest) GetKeyFile() string {
+	return ""
+}
+
+func (o *TLSOptionsTest) GetMinVersion() string {
+	return o.MinVersion
+}
+
+func (o *TLSOptionsTest) GetCipherSuites() []string {
+	return o.CipherSuites
+}
+
+func (o *TLSOptionsTest) GetClientCAFile() string {
+	return o.ClientCAFile
+}
+
+func (o *TLSOptionsTest) GetClientAuth() string {
+	return o.ClientAuth
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"net/http"
+	"sync"
+	"time"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+// CircuitBreaker stops spawning the commands of a resource whose dependency is down. After
+// failure-threshold failures (or timeouts) within the window, the breaker opens and rejects
+// the requests for the cool-down period, then it half-opens and lets the trial requests pass:
+// the breaker closes when all of trials succeed, or opens again when one of them fails.
+type CircuitBreaker struct {
+	name string
+	threshold int
+	window time.Duration
+	coolDown time.Duration
+	trials int
+	lock sync.Mutex
+	state string
+	failures []time.Time
+	openedAt time.Time
+	trialsRunning int
+	trialsPassed int
+	logger *loq.Logger
+}
+
+type CircuitBreakerOptions interface {
+	GetFailureThreshold() int
+	GetWindow() (time.Duration, error)
+	GetCoolDown() (time.Duration, error)
+	GetHalfOpenTrials() int
+}
+
+type CircuitBreakerStats struct {
+	Name string `json:"name"`
+	State string `json:"state"`
+	Failures int `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+}
+
+func NewCircuitBreaker(name string, logger *loq.Logger, opts CircuitBreakerOptions) (*CircuitBreaker, error) {
+	b := new(CircuitBreaker)
+	b.name = name
+	b.logger = logger
+	b.state = CIRCUIT_CLOSED
+	b.threshold = DEFAULT_CIRCUIT_FAILURE_THRESHOLD
+	b.window = DEFAULT_CIRCUIT_WINDOW
+	b.coolDown = DEFAULT_CIRCUIT_COOL_DOWN
+	b.trials = 1
+	if opts != nil {
+		if opts.GetFailureThreshold() > 0 {
+			b.threshold = opts.GetFailureThreshold()
+		}
+		window, err := opts.GetWindow()
+		if err != nil {
+			return nil, err
+		}
+		if window > 0 {
+			b.window = window
+		}
+		coolDown, err := opts.GetCoolDown()
+		if err != nil {
+			return nil, err
+		}
+		if coolDown > 0 {
+			b.coolDown = coolDown
+		}
+		if opts.GetHalfOpenTrials() > 0 {
+			b.trials = opts.GetHalfOpenTrials()
+		}
+	}
+	return b, nil
+}
+
+// Allow() checks whether a command can be spawned, the returned function must be called
+// with the outcome of the command.
+func (b *CircuitBreaker) Allow() (func(failed bool), error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	if b.state == CIRCUIT_OPEN {
+		if remaining := b.openedAt.Add(b.coolDown).Sub(now); remaining > 0 {
+			return nil, b.reject(remaining)
+		}
+		b.transit(CIRCUIT_HALF_OPEN)
+		b.trialsRunning = 0
+		b.trialsPassed = 0
+	}
+	if b.state == CIRCUIT_HALF_OPEN {
+		if b.trialsRunning + b.trialsPassed >= b.trials {
+			return nil, b.reject(time.Second)
+		}
+		b.trialsRunning++
+		return func(failed bool) {
+			b.recordTrial(failed)
+		}, nil
+	}
+	return func(failed bool) {
+		if failed {
+			b.recordFailure()
+		}
+	}, nil
+}
+
+func (b *CircuitBreaker) Stats() *CircuitBreakerStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	stats := &CircuitBreakerStats{
+		Name: b.name,
+		State: b.state,
+		Failures: len(b.recentFailures(time.Now())),
+	}
+	if b.state != CIRCUIT_CLOSED {
+		openedAt := b.openedAt
+		stats.OpenedAt = &openedAt
+	}
+	return stats
+}
+
+func (b *CircuitBreaker) recordFailure() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	b.failures = append(b.recentFailures(now), now)
+	if b.state == CIRCUIT_CLOSED && len(b.failures) >= b.threshold {
+		b.open(now)
+	}
+}
+
+func (b *CircuitBreaker) recordTrial(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state != CIRCUIT_HALF_OPEN {
+		return
+	}
+	b.trialsRunning--
+	if failed {
+		b.open(time.Now())
+		return
+	}
+	b.trialsPassed++
+	if b.trialsPassed >= b.trials {
+		b.failures = nil
+		b.transit(CIRCUIT_CLOSED)
+	}
+}
+
+func (b *CircuitBreaker) recentFailures(now time.Time) []time.Time {
+	from := 0
+	for from < len(b.failures) && now.Sub(b.failures[from]) > b.window {
+		from++
+	}
+	return b.failures[from:]
+}
+
+func (b *CircuitBreaker) open(now time.Time) {
+	b.openedAt = now
+	b.transit(CIRCUIT_OPEN)
+}
+
+func (b *CircuitBreaker) transit(state string) {
+	if b.state == state {
+		return
+	}
+	b.logger.Log(loq.WarnLevel, "Circuit breaker has changed its state",
+		loq.String("resourceName", b.name),
+		loq.String("from", b.state),
+		loq.String("to", state))
+	b.state = state
+}
+
+func (b *CircuitBreaker) reject(retryAfter time.Duration) *LimitError {
+	return &LimitError{
+		Limiter: "circuit/" + b.name,
+		Reason: "the circuit breaker is " + b.state,
+		StatusCode: http.StatusServiceUnavailable,
+		RetryAfter: retryAfter,
+	}
+}
+
+const CIRCUIT_CLOSED string = "closed"
+const CIRCUIT_OPEN string = "open"
+const CIRCUIT_HALF_OPEN string = "half-open"
+const DEFAULT_CIRCUIT_FAILURE_THRESHOLD int = 5
+const DEFAULT_CIRCUIT_WINDOW time.Duration = 60 * time.Second
+const DEFAULT_CIRCUIT_COOL_DOWN time.Duration = 30 * time.Second
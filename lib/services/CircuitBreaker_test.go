@@ -0,0 +1,99 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+
+	t.Run("breaker opens after the failures and closes after the trials", func(t *testing.T) {
+		b, _ := NewCircuitBreaker("reports", logger, &CircuitBreakerOptionsTest{
+			Threshold: 2,
+			Window: time.Minute,
+			CoolDown: 50 * time.Millisecond,
+			Trials: 2,
+		})
+		for i := 0; i < 2; i++ {
+			done, err := b.Allow()
+			assert.Nil(t, err)
+			done(true)
+		}
+		assert.Equal(t, CIRCUIT_OPEN, b.Stats().State)
+
+		_, err := b.Allow()
+		limitErr, ok := err.(*LimitError)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusServiceUnavailable, limitErr.StatusCode)
+		assert.True(t, limitErr.RetryAfter > 0)
+
+		time.Sleep(60 * time.Millisecond)
+		trial1, err := b.Allow()
+		assert.Nil(t, err)
+		assert.Equal(t, CIRCUIT_HALF_OPEN, b.Stats().State)
+		trial2, err := b.Allow()
+		assert.Nil(t, err)
+		_, err = b.Allow()
+		assert.NotNil(t, err)
+		trial1(false)
+		assert.Equal(t, CIRCUIT_HALF_OPEN, b.Stats().State)
+		trial2(false)
+		assert.Equal(t, CIRCUIT_CLOSED, b.Stats().State)
+		assert.Equal(t, 0, b.Stats().Failures)
+	})
+
+	t.Run("breaker opens again when a trial fails", func(t *testing.T) {
+		b, _ := NewCircuitBreaker("reports", logger, &CircuitBreakerOptionsTest{
+			Threshold: 1,
+			CoolDown: 20 * time.Millisecond,
+		})
+		done, _ := b.Allow()
+		done(true)
+		time.Sleep(30 * time.Millisecond)
+		trial, err := b.Allow()
+		assert.Nil(t, err)
+		trial(true)
+		assert.Equal(t, CIRCUIT_OPEN, b.Stats().State)
+	})
+
+	t.Run("failures out of the window are not counted", func(t *testing.T) {
+		b, _ := NewCircuitBreaker("reports", logger, &CircuitBreakerOptionsTest{
+			Threshold: 2,
+			Window: 20 * time.Millisecond,
+		})
+		done, _ := b.Allow()
+		done(true)
+		time.Sleep(30 * time.Millisecond)
+		done, _ = b.Allow()
+		done(true)
+		assert.Equal(t, CIRCUIT_CLOSED, b.Stats().State)
+		assert.Equal(t, 1, b.Stats().Failures)
+	})
+}
+
+type CircuitBreakerOptionsTest struct {
+	Threshold int
+	Window time.Duration
+	CoolDown time.Duration
+	Trials int
+}
+
+func (o *CircuitBreakerOptionsTest) GetFailureThreshold() int {
+	return o.Threshold
+}
+
+func (o *CircuitBreakerOptionsTest) GetWindow() (time.Duration, error) {
+	return o.Window, nil
+}
+
+func (o *CircuitBreakerOptionsTest) GetCoolDown() (time.Duration, error) {
+	return o.CoolDown, nil
+}
+
+func (o *CircuitBreakerOptionsTest) GetHalfOpenTrials() int {
+	return o.Trials
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ClientIdentity describes the verified certificate of a mutual TLS client, it is passed to
+// the commands in the clientCert field of the request packet.
+type ClientIdentity struct {
+	Subject string `json:"subject"`
+	CommonName string `json:"commonName,omitempty"`
+	Issuer string `json:"issuer"`
+	SerialNumber string `json:"serialNumber"`
+	Fingerprint string `json:"fingerprint"`
+	DNSNames []string `json:"dnsNames,omitempty"`
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+	URIs []string `json:"uris,omitempty"`
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+}
+
+type ClientCertOptions interface {
+	GetSubjects() []string
+	GetSANs() []string
+}
+
+// ClientCertPolicy allows a resource to the clients whose certificate subject (the full
+// distinguished name or the common name) or one of the SANs matches a pattern of the allowlist.
+// The patterns are matched with the path.Match() syntax, e.g. "*.internal.example.com".
+type ClientCertPolicy struct {
+	subjects []string
+	sans []string
+}
+
+func NewClientCertPolicy(opts ClientCertOptions) (*ClientCertPolicy, error) {
+	p := new(ClientCertPolicy)
+	for _, pattern := range opts.GetSubjects() {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Subject pattern [%s] is malformed", pattern)
+		}
+		p.subjects = append(p.subjects, pattern)
+	}
+	for _, pattern := range opts.GetSANs() {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("SAN pattern [%s] is malformed", pattern)
+		}
+		p.sans = append(p.sans, pattern)
+	}
+	return p, nil
+}
+
+// Allow() returns false when the client has not presented a verified certificate.
+func (p *ClientCertPolicy) Allow(id *ClientIdentity) bool {
+	if id == nil {
+		return false
+	}
+	if matchAnyPattern(p.subjects, id.Subject, id.CommonName) {
+		return true
+	}
+	sans := make([]string, 0)
+	sans = append(sans, id.DNSNames...)
+	sans = append(sans, id.EmailAddresses...)
+	sans = append(sans, id.URIs...)
+	sans = append(sans, id.IPAddresses...)
+	return matchAnyPattern(p.sans, sans...)
+}
+
+// ExtractClientIdentity() returns the identity of the leaf certificate of the verified chain,
+// or nil when the connection is not a TLS one or the client certificate is not verified.
+func ExtractClientIdentity(r *http.Request) *ClientIdentity {
+	if r == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return NewClientIdentity(r.TLS.VerifiedChains[0][0])
+}
+
+func NewClientIdentity(cert *x509.Certificate) *ClientIdentity {
+	id := &ClientIdentity{
+		Subject: cert.Subject.String(),
+		CommonName: cert.Subject.CommonName,
+		Issuer: cert.Issuer.String(),
+		SerialNumber: cert.SerialNumber.String(),
+		Fingerprint: fmt.Sprintf("%x", sha256.Sum256(cert.Raw)),
+		DNSNames: cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		id.IPAddresses = append(id.IPAddresses, ip.String())
+	}
+	return id
+}
+
+func matchAnyPattern(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if len(value) == 0 {
+				continue
+			}
+			// a single wildcard matches any value, even those containing slashes
+			if pattern == "*" {
+				return true
+			}
+			if matched, _ := path.Match(pattern, value); matched {
+				return true
+			}
+			if strings.EqualFold(pattern, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCertPolicy(t *testing.T) {
+	identity := &ClientIdentity{
+		Subject: "CN=billing,O=Acme",
+		CommonName: "billing",
+		DNSNames: []string{ "billing.internal.example.com" },
+		URIs: []string{ "spiffe://example.com/ns/payments/sa/billing" },
+	}
+
+	t.Run("subject matches the common name or the distinguished name", func(t *testing.T) {
+		p, err := NewClientCertPolicy(&ClientCertOptionsTest{ Subjects: []string{ "billing" } })
+		assert.Nil(t, err)
+		assert.True(t, p.Allow(identity))
+		p, _ = NewClientCertPolicy(&ClientCertOptionsTest{ Subjects: []string{ "CN=*,O=Acme" } })
+		assert.True(t, p.Allow(identity))
+		p, _ = NewClientCertPolicy(&ClientCertOptionsTest{ Subjects: []string{ "orders" } })
+		assert.False(t, p.Allow(identity))
+	})
+
+	t.Run("SANs are matched with wildcard patterns", func(t *testing.T) {
+		p, _ := NewClientCertPolicy(&ClientCertOptionsTest{ SANs: []string{ "*.internal.example.com" } })
+		assert.True(t, p.Allow(identity))
+		p, _ = NewClientCertPolicy(&ClientCertOptionsTest{ SANs: []string{ "spiffe://example.com/ns/payments/sa/*" } })
+		assert.True(t, p.Allow(identity))
+		p, _ = NewClientCertPolicy(&ClientCertOptionsTest{ SANs: []string{ "*.public.example.com" } })
+		assert.False(t, p.Allow(identity))
+	})
+
+	t.Run("request without a verified certificate is rejected", func(t *testing.T) {
+		p, _ := NewClientCertPolicy(&ClientCertOptionsTest{ Subjects: []string{ "*" } })
+		assert.False(t, p.Allow(nil))
+	})
+
+	t.Run("malformed pattern is refused", func(t *testing.T) {
+		_, err := NewClientCertPolicy(&ClientCertOptionsTest{ SANs: []string{ "[billing" } })
+		assert.NotNil(t, err)
+	})
+}
+
+func TestBuildTLSConfig_ClientAuth(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "opwire-ca")
+	defer os.RemoveAll(dir)
+	caCert, caKey := createTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{ Type: "CERTIFICATE", Bytes: caCert.Raw }), 0644)
+
+	_, err := BuildTLSConfig(&TLSOptionsTest{ ClientAuth: "require" }, &CertReloader{})
+	assert.NotNil(t, err)
+
+	cfg, err := BuildTLSConfig(&TLSOptionsTest{ ClientCAFile: caFile, ClientAuth: "require" }, &CertReloader{})
+	assert.Nil(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if identity := ExtractClientIdentity(r); identity != nil {
+			w.Write([]byte(identity.CommonName))
+		}
+	}))
+	server.TLS = cfg
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	_, err = client.Get(server.URL)
+	assert.NotNil(t, err)
+
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{ createTestClientCert(t, caCert, caKey, "billing") }
+	res, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "billing", string(body))
+}
+
+func createTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{ CommonName: "opwire-test-ca" },
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IsCA: true,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert, key
+}
+
+func createTestClientCert(t *testing.T, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{ CommonName: commonName },
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{ x509.ExtKeyUsageClientAuth },
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	assert.Nil(t, err)
+	return tls.Certificate{ Certificate: [][]byte{ der }, PrivateKey: key }
+}
+
+type ClientCertOptionsTest struct {
+	Subjects []string
+	SANs []string
+}
+
+func (o *ClientCertOptionsTest) GetSubjects() []string {
+	return o.Subjects
+}
+
+func (o *ClientCertOptionsTest) GetSANs() []string {
+	return o.SANs
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPResolver determines the IP address of the client behind the trusted proxies. The
+// X-Forwarded-For & Forwarded (RFC 7239) headers are honored only when the request comes
+// from a trusted proxy, and the chain is walked from the nearest hop to the first untrusted one.
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+type clientIPContextKey struct{}
+
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	c := new(ClientIPResolver)
+	for _, proxy := range trustedProxies {
+		ipnet, err := parseIPNet(proxy)
+		if err != nil {
+			return nil, err
+		}
+		c.trusted = append(c.trusted, ipnet)
+	}
+	return c, nil
+}
+
+func (c *ClientIPResolver) IsTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range c.trusted {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ClientIPResolver) Resolve(r *http.Request) net.IP {
+	remoteIP := parseRemoteIP(r.RemoteAddr)
+	if remoteIP == nil || !c.IsTrusted(remoteIP) {
+		return remoteIP
+	}
+	hops := parseForwardedHeader(r.Header["Forwarded"])
+	if len(hops) == 0 {
+		hops = parseXForwardedFor(r.Header["X-Forwarded-For"])
+	}
+	client := remoteIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if hop == nil {
+			// an obfuscated or invalid hop, the chain cannot be trusted anymore
+			break
+		}
+		client = hop
+		if !c.IsTrusted(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// Handler() stores the resolved client IP into the request context.
+func (c *ClientIPResolver) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := c.Resolve(r); ip != nil {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPContextKey{}, ip))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func parseIPNet(proxy string) (*net.IPNet, error) {
+	if strings.Contains(proxy, "/") {
+		_, ipnet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		return ipnet, nil
+	}
+	ip := net.ParseIP(proxy)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is neither an IP address nor a CIDR", proxy)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{ IP: ip4, Mask: net.CIDRMask(32, 32) }, nil
+	}
+	return &net.IPNet{ IP: ip, Mask: net.CIDRMask(128, 128) }, nil
+}
+
+func parseRemoteIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func parseXForwardedFor(values []string) []net.IP {
+	hops := make([]net.IP, 0)
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if len(item) == 0 {
+				continue
+			}
+			hops = append(hops, parseNodeIP(item))
+		}
+	}
+	return hops
+}
+
+// parseForwardedHeader() extracts the "for" parameters of the Forwarded header (RFC 7239).
+func parseForwardedHeader(values []string) []net.IP {
+	hops := make([]net.IP, 0)
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) < 4 || !strings.EqualFold(pair[:4], "for=") {
+					continue
+				}
+				hops = append(hops, parseNodeIP(strings.Trim(pair[4:], `"`)))
+			}
+		}
+	}
+	return hops
+}
+
+// parseNodeIP() parses a node of the forwarded chain: "192.0.2.60", "192.0.2.60:4711",
+// "[2001:db8::17]:4711" or "2001:db8::17". The obfuscated identifiers return nil.
+func parseNodeIP(node string) net.IP {
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIPResolver_Resolve(t *testing.T) {
+	c, err := NewClientIPResolver([]string{ "10.0.0.0/8", "192.168.1.1" })
+	assert.Nil(t, err)
+
+	t.Run("headers from untrusted peers are ignored", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "203.0.113.5:4000"
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+		assert.Equal(t, "203.0.113.5", c.Resolve(r).String())
+	})
+	t.Run("X-Forwarded-For is walked to the first untrusted hop", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.2:4000"
+		r.Header.Add("X-Forwarded-For", "198.51.100.7, 198.51.100.1")
+		r.Header.Add("X-Forwarded-For", "192.168.1.1")
+		assert.Equal(t, "198.51.100.1", c.Resolve(r).String())
+	})
+	t.Run("Forwarded header takes precedence", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.2:4000"
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+		r.Header.Set("Forwarded", `for="[2001:db8::17]:4711";proto=https, for=10.1.1.1`)
+		assert.Equal(t, "2001:db8::17", c.Resolve(r).String())
+	})
+	t.Run("obfuscated hops stop the chain", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.2:4000"
+		r.Header.Set("Forwarded", `for=198.51.100.1, for=_hidden`)
+		assert.Equal(t, "10.0.0.2", c.Resolve(r).String())
+	})
+	t.Run("resolved IP is used by extractUserIP", func(t *testing.T) {
+		var resolved string
+		handler := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, _ := extractUserIP(r)
+			resolved = ip.String()
+		}))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.2:4000"
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		assert.Equal(t, "198.51.100.1", resolved)
+	})
+	t.Run("invalid trusted proxy is rejected", func(t *testing.T) {
+		_, err := NewClientIPResolver([]string{ "10.0.0.0/33" })
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+	"golang.org/x/sync/semaphore"
+)
+
+// ConcurrencyLimiter bounds the number of running commands, the requests exceeding the
+// limit are queued (up to max-queue) and wait for a permit at most max-wait.
+type ConcurrencyLimiter struct {
+	name string
+	semaphore *semaphore.Weighted
+	total int
+	maxQueue int
+	maxWait time.Duration
+	active int32
+	queued int32
+}
+
+type ConcurrentLimitOptions interface {
+	GetEnabled() bool
+	GetTotal() int
+	GetMaxQueue() int
+	GetMaxWait() (time.Duration, error)
+}
+
+type ConcurrencyStats struct {
+	Name string `json:"name"`
+	Total int `json:"total"`
+	Active int32 `json:"active"`
+	Queued int32 `json:"queued"`
+	MaxQueue int `json:"maxQueue"`
+}
+
+// LimitError is returned when a request is rejected by a limiter (concurrent limit, lock or circuit breaker).
+type LimitError struct {
+	Limiter string
+	Reason string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("Request is rejected by [%s]: %s", e.Limiter, e.Reason)
+}
+
+func NewConcurrencyLimiter(name string, opts ConcurrentLimitOptions) (*ConcurrencyLimiter, error) {
+	if opts == nil || opts.GetTotal() <= 0 {
+		return nil, fmt.Errorf("Concurrent limit [%s] must declare a positive total", name)
+	}
+	maxWait, err := opts.GetMaxWait()
+	if err != nil {
+		return nil, err
+	}
+	l := new(ConcurrencyLimiter)
+	l.name = name
+	l.total = opts.GetTotal()
+	l.maxQueue = opts.GetMaxQueue()
+	l.maxWait = maxWait
+	l.semaphore = semaphore.NewWeighted(int64(l.total))
+	return l, nil
+}
+
+// Acquire() waits for a permit until the request context is done or max-wait is elapsed.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) error {
+	if l.semaphore.TryAcquire(1) {
+		atomic.AddInt32(&l.active, 1)
+		return nil
+	}
+	queued := atomic.AddInt32(&l.queued, 1)
+	defer atomic.AddInt32(&l.queued, -1)
+	if l.maxQueue >= 0 && int(queued) > l.maxQueue {
+		return &LimitError{
+			Limiter: l.name,
+			Reason: "the wait queue is full",
+			StatusCode: http.StatusTooManyRequests,
+			RetryAfter: l.retryAfter(),
+		}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	waitCtx := ctx
+	if l.maxWait > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, l.maxWait)
+		defer cancel()
+	}
+	if err := l.semaphore.Acquire(waitCtx, 1); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &LimitError{
+			Limiter: l.name,
+			Reason: "the waiting time is over",
+			StatusCode: http.StatusServiceUnavailable,
+			RetryAfter: l.retryAfter(),
+		}
+	}
+	atomic.AddInt32(&l.active, 1)
+	return nil
+}
+
+func (l *ConcurrencyLimiter) Release() {
+	atomic.AddInt32(&l.active, -1)
+	l.semaphore.Release(1)
+}
+
+func (l *ConcurrencyLimiter) Stats() *ConcurrencyStats {
+	return &ConcurrencyStats{
+		Name: l.name,
+		Total: l.total,
+		Active: atomic.LoadInt32(&l.active),
+		Queued: atomic.LoadInt32(&l.queued),
+		MaxQueue: l.maxQueue,
+	}
+}
+
+func (l *ConcurrencyLimiter) retryAfter() time.Duration {
+	if l.maxWait > time.Second {
+		return l.maxWait
+	}
+	return time.Second
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+func TestConcurrencyLimiter_Acquire(t *testing.T) {
+	t.Run("request is rejected with 429 when the queue is full", func(t *testing.T) {
+		l, _ := NewConcurrencyLimiter("test", &ConcurrentLimitOptionsTest{ Total: 1, MaxQueue: 0 })
+		assert.Nil(t, l.Acquire(context.Background()))
+		err := l.Acquire(context.Background())
+		limitErr, ok := err.(*LimitError)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusTooManyRequests, limitErr.StatusCode)
+		assert.Equal(t, time.Second, limitErr.RetryAfter)
+		l.Release()
+		assert.Nil(t, l.Acquire(context.Background()))
+	})
+	t.Run("request is rejected with 503 when the waiting time is over", func(t *testing.T) {
+		l, _ := NewConcurrencyLimiter("test", &ConcurrentLimitOptionsTest{ Total: 1, MaxQueue: -1, MaxWait: 20 * time.Millisecond })
+		assert.Nil(t, l.Acquire(context.Background()))
+		err := l.Acquire(context.Background())
+		limitErr, ok := err.(*LimitError)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusServiceUnavailable, limitErr.StatusCode)
+		assert.Equal(t, int32(0), l.Stats().Queued)
+	})
+	t.Run("waiting is canceled by the request context", func(t *testing.T) {
+		l, _ := NewConcurrencyLimiter("test", &ConcurrentLimitOptionsTest{ Total: 1, MaxQueue: -1 })
+		assert.Nil(t, l.Acquire(context.Background()))
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			assert.Equal(t, int32(1), l.Stats().Queued)
+			cancel()
+		}()
+		assert.Equal(t, context.Canceled, l.Acquire(ctx))
+	})
+}
+
+func TestReqRestrictor_Acquire(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+	rr, _ := NewReqRestrictor(logger, nil)
+	assert.False(t, rr.HasLimiters())
+	rr.RegisterLimiter(&ConcurrentLimitOptionsTest{ Enabled: true, Total: 2, MaxQueue: 0 }, "reports", "")
+	rr.RegisterLimiter(&ConcurrentLimitOptionsTest{ Enabled: true, Total: 1, MaxQueue: 0 }, "reports", "POST")
+	assert.True(t, rr.HasLimiters())
+
+	release1, err := rr.Acquire(context.Background(), "reports", "POST")
+	assert.Nil(t, err)
+	_, err = rr.Acquire(context.Background(), "reports", "POST")
+	assert.NotNil(t, err)
+	release2, err := rr.Acquire(context.Background(), "reports", "GET")
+	assert.Nil(t, err)
+	_, err = rr.Acquire(context.Background(), "reports", "GET")
+	assert.NotNil(t, err)
+	// other resources are not limited
+	release3, err := rr.Acquire(context.Background(), "products", "GET")
+	assert.Nil(t, err)
+
+	stats := rr.LimiterStats()
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, "reports", stats[0].Name)
+	assert.Equal(t, int32(2), stats[0].Active)
+	assert.Equal(t, "reports/POST", stats[1].Name)
+	assert.Equal(t, int32(1), stats[1].Active)
+
+	release1()
+	release2()
+	release3()
+	assert.Equal(t, int32(0), rr.LimiterStats()[0].Active)
+}
+
+type ConcurrentLimitOptionsTest struct {
+	Enabled bool
+	Total int
+	MaxQueue int
+	MaxWait time.Duration
+}
+
+func (o *ConcurrentLimitOptionsTest) GetEnabled() bool {
+	return o.Enabled
+}
+
+func (o *ConcurrentLimitOptionsTest) GetTotal() int {
+	return o.Total
+}
+
+func (o *ConcurrentLimitOptionsTest) GetMaxQueue() int {
+	return o.MaxQueue
+}
+
+func (o *ConcurrentLimitOptionsTest) GetMaxWait() (time.Duration, error) {
+	return o.MaxWait, nil
+}
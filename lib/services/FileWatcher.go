@@ -0,0 +1,299 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"github.com/fsnotify/fsnotify"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+// FileWatcher triggers a resource when files appear or change under the watched directory
+// (using inotify on Linux). The events of a file are debounced and delivered as a message
+// without body, the file path and the event type are passed through the request origin.
+type FileWatcher struct {
+	name string
+	directory string
+	patterns []string
+	events fsnotify.Op
+	debounce time.Duration
+	doneDirectory string
+	failedDirectory string
+	watcher *fsnotify.Watcher
+	handler BrokerHandler
+	pending map[string]*pendingFileEvent
+	lock sync.Mutex
+	running sync.WaitGroup
+	stopChan chan struct{}
+	logger *loq.Logger
+}
+
+type FileWatcherOptions interface {
+	GetDirectory() string
+	GetPatterns() []string
+	GetEvents() []string
+	GetDebounce() (time.Duration, error)
+	GetDoneDirectory() string
+	GetFailedDirectory() string
+}
+
+type pendingFileEvent struct {
+	timer *time.Timer
+	ops fsnotify.Op
+}
+
+var watchEventTypes = []struct {
+	name string
+	op fsnotify.Op
+}{
+	{ "create", fsnotify.Create },
+	{ "write", fsnotify.Write },
+	{ "remove", fsnotify.Remove },
+	{ "rename", fsnotify.Rename },
+	{ "chmod", fsnotify.Chmod },
+}
+
+func NewFileWatcher(name string, logger *loq.Logger, opts FileWatcherOptions) (*FileWatcher, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("FileWatcherOptions must not be nil")
+	}
+	if len(opts.GetDirectory()) == 0 {
+		return nil, fmt.Errorf("Watcher [%s] must declare a directory", name)
+	}
+	w := new(FileWatcher)
+	w.name = name
+	w.logger = logger
+	w.directory = opts.GetDirectory()
+	w.patterns = opts.GetPatterns()
+	for _, pattern := range w.patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Watcher [%s] has an invalid pattern [%s]", name, pattern)
+		}
+	}
+	for _, eventName := range opts.GetEvents() {
+		op, ok := findWatchEventOp(eventName)
+		if !ok {
+			return nil, fmt.Errorf("Watcher [%s] has an unsupported event [%s]", name, eventName)
+		}
+		w.events |= op
+	}
+	w.debounce = DEFAULT_WATCH_DEBOUNCE
+	if debounce, err := opts.GetDebounce(); debounce > 0 && err == nil {
+		w.debounce = debounce
+	}
+	w.doneDirectory = resolveWatchDirectory(w.directory, opts.GetDoneDirectory())
+	w.failedDirectory = resolveWatchDirectory(w.directory, opts.GetFailedDirectory())
+	return w, nil
+}
+
+func (w *FileWatcher) GetName() string {
+	return w.name
+}
+
+func (w *FileWatcher) Start(handler BrokerHandler) error {
+	if handler == nil {
+		return fmt.Errorf("BrokerHandler must not be nil")
+	}
+	for _, dir := range []string{ w.doneDirectory, w.failedDirectory } {
+		if len(dir) > 0 {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(w.directory); err != nil {
+		watcher.Close()
+		return err
+	}
+	w.watcher = watcher
+	w.handler = handler
+	w.pending = make(map[string]*pendingFileEvent)
+	w.stopChan = make(chan struct{})
+	w.running.Add(1)
+	go func() {
+		defer w.running.Done()
+		for {
+			select {
+			case <-w.stopChan:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				w.receive(event)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				w.logger.Log(loq.ErrorLevel, "Watching directory failed", loq.String("watcher", w.name), loq.Error(err))
+			}
+		}
+	}()
+	w.logger.Log(loq.InfoLevel, "File watcher is watching directory",
+		loq.String("watcher", w.name),
+		loq.String("directory", w.directory))
+	return nil
+}
+
+// Stop() cancels the pending events and waits for the running commands to be completed.
+func (w *FileWatcher) Stop() error {
+	if w.stopChan == nil {
+		return nil
+	}
+	close(w.stopChan)
+	err := w.watcher.Close()
+	w.lock.Lock()
+	for path, p := range w.pending {
+		if p.timer.Stop() {
+			delete(w.pending, path)
+			w.running.Done()
+		}
+	}
+	w.lock.Unlock()
+	w.running.Wait()
+	w.stopChan = nil
+	return err
+}
+
+func (w *FileWatcher) receive(event fsnotify.Event) {
+	if event.Op & w.events == 0 || !w.accepts(event.Name) {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.schedule(event.Name, event.Op, w.debounce)
+}
+
+// rearm() keeps a deferred event pending (e.g. while the agent is locked), it is fired again
+// after the retry delay unless the watcher is stopped.
+func (w *FileWatcher) rearm(path string, ops fsnotify.Op) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	select {
+	case <-w.stopChan:
+		return
+	default:
+	}
+	delay := DEFAULT_WATCH_RETRY_DELAY
+	if w.debounce > delay {
+		delay = w.debounce
+	}
+	w.schedule(path, ops, delay)
+}
+
+// schedule() merges the operations into the pending event of the path, or arms a new one.
+func (w *FileWatcher) schedule(path string, ops fsnotify.Op, delay time.Duration) {
+	if p, ok := w.pending[path]; ok && p.timer.Stop() {
+		p.ops |= ops
+		p.timer.Reset(delay)
+		return
+	}
+	p := &pendingFileEvent{ ops: ops }
+	w.running.Add(1)
+	p.timer = time.AfterFunc(delay, func() {
+		defer w.running.Done()
+		w.fire(path, p)
+	})
+	w.pending[path] = p
+}
+
+func (w *FileWatcher) accepts(path string) bool {
+	if len(w.patterns) == 0 {
+		return true
+	}
+	name := filepath.Base(path)
+	for _, pattern := range w.patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *FileWatcher) fire(path string, p *pendingFileEvent) {
+	w.lock.Lock()
+	if w.pending[path] == p {
+		delete(w.pending, path)
+	}
+	w.lock.Unlock()
+
+	eventName := ""
+	for _, t := range watchEventTypes {
+		if p.ops & w.events & t.op != 0 {
+			eventName = t.name
+			break
+		}
+	}
+
+	exists := false
+	if stat, err := os.Stat(path); err == nil {
+		if stat.IsDir() {
+			return
+		}
+		exists = true
+	}
+	if !exists && (eventName == "create" || eventName == "write") {
+		return
+	}
+
+	result := w.handler(&BrokerMessage{
+		Id: path,
+		Header: http.Header{},
+		Origin: &RequestOrigin{
+			Kind: ORIGIN_KIND_WATCH,
+			Name: w.name,
+			File: path,
+			Event: eventName,
+		},
+	})
+
+	if result != nil && result.Retry {
+		w.logger.Log(loq.WarnLevel, "The file event is deferred",
+			loq.String("watcher", w.name),
+			loq.String("file", path),
+			loq.String("event", eventName))
+		w.rearm(path, p.ops)
+		return
+	}
+
+	targetDir := w.doneDirectory
+	if result.Failed() {
+		targetDir = w.failedDirectory
+		w.logger.Log(loq.ErrorLevel, "The file event has failed",
+			loq.String("watcher", w.name),
+			loq.String("file", path),
+			loq.String("event", eventName),
+			loq.String("error", result.ErrorMessage()))
+	}
+	if exists && len(targetDir) > 0 {
+		if err := os.Rename(path, filepath.Join(targetDir, filepath.Base(path))); err != nil {
+			w.logger.Log(loq.ErrorLevel, "Moving processed file failed", loq.String("watcher", w.name), loq.Error(err))
+		}
+	}
+}
+
+func findWatchEventOp(eventName string) (fsnotify.Op, bool) {
+	for _, t := range watchEventTypes {
+		if t.name == eventName {
+			return t.op, true
+		}
+	}
+	return 0, false
+}
+
+func resolveWatchDirectory(baseDir string, dir string) string {
+	if len(dir) == 0 || filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(baseDir, dir)
+}
+
+const DEFAULT_WATCH_DEBOUNCE time.Duration = 500 * time.Millisecond
+const DEFAULT_WATCH_RETRY_DELAY time.Duration = time.Second
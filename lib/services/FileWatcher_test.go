@@ -0,0 +1,143 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+func TestFileWatcher(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+
+	dir, err := ioutil.TempDir("", "opwire-watch")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	watcher, err := NewFileWatcher("inbox", logger, &FileWatcherOptionsTest{
+		Directory: dir,
+		Patterns: []string{ "*.csv" },
+		Events: []string{ "create", "write" },
+		Debounce: 100 * time.Millisecond,
+		DoneDirectory: "done",
+		FailedDirectory: "failed",
+	})
+	assert.Nil(t, err)
+
+	received := make(chan *RequestOrigin, 10)
+	locked := true
+	err = watcher.Start(func(msg *BrokerMessage) *BrokerResult {
+		received <- msg.Origin
+		if filepath.Base(msg.Origin.File) == "locked.csv" && locked {
+			locked = false
+			return &BrokerResult{ Error: fmt.Errorf("Agent is not ready to serve"), Retry: true }
+		}
+		if filepath.Base(msg.Origin.File) == "broken.csv" {
+			return &BrokerResult{ Error: fmt.Errorf("exit status 2") }
+		}
+		return &BrokerResult{}
+	})
+	assert.Nil(t, err)
+	defer watcher.Stop()
+
+	waitFor := func() *RequestOrigin {
+		select {
+		case origin := <-received:
+			return origin
+		case <-time.After(3 * time.Second):
+			return nil
+		}
+	}
+
+	t.Run("events are debounced and the processed file is moved to done", func(t *testing.T) {
+		path := filepath.Join(dir, "upload.csv")
+		f, _ := os.Create(path)
+		for i := 0; i < 5; i++ {
+			f.WriteString("a,b,c\n")
+		}
+		f.Close()
+		origin := waitFor()
+		assert.NotNil(t, origin)
+		assert.Equal(t, ORIGIN_KIND_WATCH, origin.Kind)
+		assert.Equal(t, path, origin.File)
+		assert.Equal(t, "create", origin.Event)
+		assert.Nil(t, waitFor())
+		assert.True(t, fileExists(filepath.Join(dir, "done", "upload.csv")))
+		assert.False(t, fileExists(path))
+	})
+
+	t.Run("failed file is moved to failed", func(t *testing.T) {
+		ioutil.WriteFile(filepath.Join(dir, "broken.csv"), []byte("x"), 0644)
+		assert.NotNil(t, waitFor())
+		time.Sleep(50 * time.Millisecond)
+		assert.True(t, fileExists(filepath.Join(dir, "failed", "broken.csv")))
+	})
+
+	t.Run("deferred event is fired again", func(t *testing.T) {
+		path := filepath.Join(dir, "locked.csv")
+		ioutil.WriteFile(path, []byte("x"), 0644)
+		assert.NotNil(t, waitFor())
+		assert.True(t, fileExists(path))
+		origin := waitFor()
+		if assert.NotNil(t, origin) {
+			assert.Equal(t, "create", origin.Event)
+		}
+		time.Sleep(50 * time.Millisecond)
+		assert.True(t, fileExists(filepath.Join(dir, "done", "locked.csv")))
+	})
+
+	t.Run("files that do not match the patterns are ignored", func(t *testing.T) {
+		ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+		assert.Nil(t, waitFor())
+		assert.True(t, fileExists(filepath.Join(dir, "notes.txt")))
+	})
+}
+
+func TestNewFileWatcher(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+	t.Run("unsupported event", func(t *testing.T) {
+		_, err := NewFileWatcher("inbox", logger, &FileWatcherOptionsTest{ Directory: "/tmp", Events: []string{ "open" } })
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid glob pattern", func(t *testing.T) {
+		_, err := NewFileWatcher("inbox", logger, &FileWatcherOptionsTest{ Directory: "/tmp", Patterns: []string{ "[a-" } })
+		assert.NotNil(t, err)
+	})
+}
+
+type FileWatcherOptionsTest struct {
+	Directory string
+	Patterns []string
+	Events []string
+	Debounce time.Duration
+	DoneDirectory string
+	FailedDirectory string
+}
+
+func (o *FileWatcherOptionsTest) GetDirectory() string {
+	return o.Directory
+}
+
+func (o *FileWatcherOptionsTest) GetPatterns() []string {
+	return o.Patterns
+}
+
+func (o *FileWatcherOptionsTest) GetEvents() []string {
+	return o.Events
+}
+
+func (o *FileWatcherOptionsTest) GetDebounce() (time.Duration, error) {
+	return o.Debounce, nil
+}
+
+func (o *FileWatcherOptionsTest) GetDoneDirectory() string {
+	return o.DoneDirectory
+}
+
+func (o *FileWatcherOptionsTest) GetFailedDirectory() string {
+	return o.FailedDirectory
+}
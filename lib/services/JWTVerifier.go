@@ -0,0 +1,399 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWTVerifier authenticates the requests with the bearer tokens, the signatures are verified
+// with a HMAC secret, the RSA/EC public keys of a PEM file or the keys of a local JWKS file.
+// The tokens must not be expired, the issuer and the audience are checked when declared.
+type JWTVerifier struct {
+	algorithms map[string]bool
+	secret []byte
+	keys []*jwtKey
+	issuer string
+	audience string
+	leeway time.Duration
+	principalClaim string
+}
+
+type JWTOptions interface {
+	GetAlgorithms() []string
+	GetSecret() string
+	GetSecretFile() string
+	GetPublicKeyFile() string
+	GetJwksFile() string
+	GetIssuer() string
+	GetAudience() string
+	GetLeeway() (time.Duration, error)
+	GetPrincipalClaim() string
+}
+
+type jwtKey struct {
+	kid string
+	key crypto.PublicKey
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwkEntry struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N string `json:"n"`
+	E string `json:"e"`
+	Crv string `json:"crv"`
+	X string `json:"x"`
+	Y string `json:"y"`
+	K string `json:"k"`
+}
+
+func NewJWTVerifier(opts JWTOptions) (*JWTVerifier, error) {
+	v := new(JWTVerifier)
+	v.issuer = opts.GetIssuer()
+	v.audience = opts.GetAudience()
+	v.principalClaim = opts.GetPrincipalClaim()
+	leeway, err := opts.GetLeeway()
+	if err != nil {
+		return nil, err
+	}
+	v.leeway = leeway
+	v.secret = []byte(opts.GetSecret())
+	if file := opts.GetSecretFile(); len(file) > 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		v.secret = bytes.TrimSpace(data)
+	}
+	if file := opts.GetPublicKeyFile(); len(file) > 0 {
+		keys, err := loadPEMPublicKeys(file)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if file := opts.GetJwksFile(); len(file) > 0 {
+		keys, secret, err := loadJWKS(file)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+		if len(v.secret) == 0 {
+			v.secret = secret
+		}
+	}
+	if len(v.secret) == 0 && len(v.keys) == 0 {
+		return nil, fmt.Errorf("JWT verification requires a secret, a public key or a JWKS file")
+	}
+	v.algorithms = make(map[string]bool)
+	algorithms := opts.GetAlgorithms()
+	if len(algorithms) == 0 {
+		// the algorithms are derived from the kinds of the declared keys
+		if len(v.secret) > 0 {
+			algorithms = append(algorithms, "HS256", "HS384", "HS512")
+		}
+		if len(v.keys) > 0 {
+			algorithms = append(algorithms, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+		}
+	}
+	for _, alg := range algorithms {
+		if _, ok := jwtHashes[alg]; !ok {
+			return nil, fmt.Errorf("JWT algorithm [%s] is not supported", alg)
+		}
+		v.algorithms[alg] = true
+	}
+	return v, nil
+}
+
+func (v *JWTVerifier) GetName() string {
+	return AUTH_PROVIDER_JWT
+}
+
+func (v *JWTVerifier) Authenticate(r *http.Request) (*Principal, error) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return nil, nil
+	}
+	claims, err := v.Verify(strings.TrimSpace(authorization[7:]))
+	if err != nil {
+		return nil, err
+	}
+	name, _ := claims[v.principalClaim].(string)
+	if len(name) == 0 {
+		return nil, fmt.Errorf("Token does not have the claim [%s]", v.principalClaim)
+	}
+	return &Principal{ Provider: AUTH_PROVIDER_JWT, Name: name, Claims: claims }, nil
+}
+
+func (v *JWTVerifier) Challenge(realm string) string {
+	return fmt.Sprintf("Bearer realm=%q", realm)
+}
+
+// Verify() checks the signature & the registered claims of a token and returns its claims.
+func (v *JWTVerifier) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Token is malformed")
+	}
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Token header is malformed")
+	}
+	header := &jwtHeader{}
+	if err := json.Unmarshal(headerData, header); err != nil {
+		return nil, fmt.Errorf("Token header is malformed")
+	}
+	if !v.algorithms[header.Alg] {
+		return nil, fmt.Errorf("Token algorithm [%s] is not accepted", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Token signature is malformed")
+	}
+	if !v.verifySignature(header, []byte(parts[0] + "." + parts[1]), signature) {
+		return nil, fmt.Errorf("Token signature is invalid")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Token payload is malformed")
+	}
+	claims := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("Token payload is malformed")
+	}
+	if err := v.verifyClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) verifySignature(header *jwtHeader, signed []byte, signature []byte) bool {
+	hash := jwtHashes[header.Alg]
+	if strings.HasPrefix(header.Alg, "HS") {
+		if len(v.secret) == 0 {
+			return false
+		}
+		mac := hmac.New(hash.New, v.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	for _, k := range v.keys {
+		if len(header.Kid) > 0 && len(k.kid) > 0 && header.Kid != k.kid {
+			continue
+		}
+		switch key := k.key.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(header.Alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+				return true
+			}
+			if strings.HasPrefix(header.Alg, "PS") && rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{ SaltLength: rsa.PSSSaltLengthEqualsHash }) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if !strings.HasPrefix(header.Alg, "ES") {
+				continue
+			}
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if len(signature) != 2 * size {
+				continue
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *JWTVerifier) verifyClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("Token does not have an expiry")
+	}
+	if now.After(time.Unix(exp, 0).Add(v.leeway)) {
+		return fmt.Errorf("Token has expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.leeway).Before(time.Unix(nbf, 0)) {
+		return fmt.Errorf("Token is not valid yet")
+	}
+	if len(v.issuer) > 0 {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return fmt.Errorf("Token issuer is not accepted")
+		}
+	}
+	if len(v.audience) > 0 && !hasAudience(claims["aud"], v.audience) {
+		return fmt.Errorf("Token audience is not accepted")
+	}
+	return nil
+}
+
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	number, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	if value, err := number.Int64(); err == nil {
+		return value, true
+	}
+	if value, err := number.Float64(); err == nil {
+		return int64(value), true
+	}
+	return 0, false
+}
+
+// hasAudience() accepts the aud claim as a string or an array of strings (RFC 7519).
+func hasAudience(aud interface{}, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func loadPEMPublicKeys(file string) ([]*jwtKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*jwtKey, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, &jwtKey{ key: key })
+		case "RSA PUBLIC KEY":
+			key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, &jwtKey{ key: key })
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, &jwtKey{ key: cert.PublicKey })
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Public key file [%s] does not contain any PEM public key", file)
+	}
+	return keys, nil
+}
+
+// loadJWKS() loads the RSA & EC public keys of a JWKS file, and the first symmetric key.
+func loadJWKS(file string) ([]*jwtKey, []byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	jwks := struct {
+		Keys []*jwkEntry `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, nil, fmt.Errorf("JWKS file [%s] is malformed: %s", file, err.Error())
+	}
+	keys := make([]*jwtKey, 0)
+	var secret []byte
+	for _, jwk := range jwks.Keys {
+		if jwk == nil || (len(jwk.Use) > 0 && jwk.Use != "sig") {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				return nil, nil, fmt.Errorf("JWKS key [%s] is malformed", jwk.Kid)
+			}
+			keys = append(keys, &jwtKey{ kid: jwk.Kid, key: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}})
+		case "EC":
+			curve, ok := jwkCurves[jwk.Crv]
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if !ok || errX != nil || errY != nil {
+				return nil, nil, fmt.Errorf("JWKS key [%s] is malformed", jwk.Kid)
+			}
+			keys = append(keys, &jwtKey{ kid: jwk.Kid, key: &ecdsa.PublicKey{
+				Curve: curve,
+				X: new(big.Int).SetBytes(x),
+				Y: new(big.Int).SetBytes(y),
+			}})
+		case "oct":
+			k, err := base64.RawURLEncoding.DecodeString(jwk.K)
+			if err != nil {
+				return nil, nil, fmt.Errorf("JWKS key [%s] is malformed", jwk.Kid)
+			}
+			if secret == nil {
+				secret = k
+			}
+		}
+	}
+	return keys, secret, nil
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+var jwkCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
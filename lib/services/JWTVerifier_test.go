@@ -0,0 +1,178 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWTVerifier_HMAC(t *testing.T) {
+	v, err := NewJWTVerifier(&JWTOptionsTest{ Secret: "hmac-secret", Issuer: "https://idp.example.com", Audience: "opwire" })
+	assert.Nil(t, err)
+	exp := time.Now().Add(time.Hour).Unix()
+
+	t.Run("valid token returns the principal and the claims", func(t *testing.T) {
+		token := signTestHMAC("hmac-secret", map[string]interface{}{
+			"sub": "alice", "iss": "https://idp.example.com", "aud": []string{ "opwire", "other" }, "exp": exp, "role": "admin",
+		})
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer " + token)
+		principal, err := v.Authenticate(r)
+		assert.Nil(t, err)
+		assert.Equal(t, AUTH_PROVIDER_JWT, principal.Provider)
+		assert.Equal(t, "alice", principal.Name)
+		assert.Equal(t, "admin", principal.Claims["role"])
+	})
+
+	t.Run("request without bearer token has no credentials", func(t *testing.T) {
+		principal, err := v.Authenticate(httptest.NewRequest("GET", "/", nil))
+		assert.Nil(t, principal)
+		assert.Nil(t, err)
+	})
+
+	t.Run("invalid tokens are rejected", func(t *testing.T) {
+		tokens := map[string]string{
+			"wrong secret": signTestHMAC("other-secret", map[string]interface{}{ "sub": "alice", "iss": "https://idp.example.com", "aud": "opwire", "exp": exp }),
+			"expired": signTestHMAC("hmac-secret", map[string]interface{}{ "sub": "alice", "iss": "https://idp.example.com", "aud": "opwire", "exp": time.Now().Add(-time.Minute).Unix() }),
+			"without expiry": signTestHMAC("hmac-secret", map[string]interface{}{ "sub": "alice", "iss": "https://idp.example.com", "aud": "opwire" }),
+			"wrong issuer": signTestHMAC("hmac-secret", map[string]interface{}{ "sub": "alice", "iss": "https://evil.example.com", "aud": "opwire", "exp": exp }),
+			"wrong audience": signTestHMAC("hmac-secret", map[string]interface{}{ "sub": "alice", "iss": "https://idp.example.com", "aud": "other", "exp": exp }),
+			"none algorithm": encodeTestSegment(map[string]interface{}{ "alg": "none" }) + "." + encodeTestSegment(map[string]interface{}{ "sub": "alice", "exp": exp }) + ".",
+			"malformed": "not-a-token",
+		}
+		for name, token := range tokens {
+			_, err := v.Verify(token)
+			assert.NotNil(t, err, name)
+		}
+	})
+}
+
+func TestJWTVerifier_PublicKeys(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "opwire-jwt")
+	defer os.RemoveAll(dir)
+	claims := map[string]interface{}{ "sub": "svc-reports", "exp": time.Now().Add(time.Hour).Unix() }
+
+	t.Run("RS256 token is verified with a PEM public key", func(t *testing.T) {
+		key, _ := rsa.GenerateKey(rand.Reader, 2048)
+		der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		pemFile := filepath.Join(dir, "public.pem")
+		ioutil.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{ Type: "PUBLIC KEY", Bytes: der }), 0644)
+		v, err := NewJWTVerifier(&JWTOptionsTest{ PublicKeyFile: pemFile })
+		assert.Nil(t, err)
+
+		signed := encodeTestSegment(map[string]interface{}{ "alg": "RS256", "typ": "JWT" }) + "." + encodeTestSegment(claims)
+		digest := sha256.Sum256([]byte(signed))
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		verified, err := v.Verify(signed + "." + base64.RawURLEncoding.EncodeToString(signature))
+		assert.Nil(t, err)
+		assert.Equal(t, "svc-reports", verified["sub"])
+
+		// a HMAC token signed with the public key must not be accepted
+		_, err = v.Verify(signTestHMAC(string(pem.EncodeToMemory(&pem.Block{ Type: "PUBLIC KEY", Bytes: der })), claims))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ES256 token is verified with a key of the JWKS file", func(t *testing.T) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		jwks := fmt.Sprintf(`{"keys": [{"kty": "EC", "kid": "k1", "crv": "P-256", "x": %q, "y": %q}]}`,
+			base64.RawURLEncoding.EncodeToString(padTestBytes(key.X.Bytes(), 32)),
+			base64.RawURLEncoding.EncodeToString(padTestBytes(key.Y.Bytes(), 32)))
+		jwksFile := filepath.Join(dir, "jwks.json")
+		ioutil.WriteFile(jwksFile, []byte(jwks), 0644)
+		v, err := NewJWTVerifier(&JWTOptionsTest{ JwksFile: jwksFile })
+		assert.Nil(t, err)
+
+		for kid, valid := range map[string]bool{ "k1": true, "k2": false } {
+			signed := encodeTestSegment(map[string]interface{}{ "alg": "ES256", "kid": kid }) + "." + encodeTestSegment(claims)
+			digest := sha256.Sum256([]byte(signed))
+			r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+			signature := append(padTestBytes(r.Bytes(), 32), padTestBytes(s.Bytes(), 32)...)
+			_, err = v.Verify(signed + "." + base64.RawURLEncoding.EncodeToString(signature))
+			assert.Equal(t, valid, err == nil, kid)
+		}
+	})
+
+	t.Run("verifier requires a key", func(t *testing.T) {
+		_, err := NewJWTVerifier(&JWTOptionsTest{})
+		assert.NotNil(t, err)
+	})
+}
+
+func signTestHMAC(secret string, claims map[string]interface{}) string {
+	signed := encodeTestSegment(map[string]interface{}{ "alg": "HS256", "typ": "JWT" }) + "." + encodeTestSegment(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodeTestSegment(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func padTestBytes(b []byte, size int) []byte {
+	padded := make([]byte, size)
+	copy(padded[size - len(b):], b)
+	return padded
+}
+
+type JWTOptionsTest struct {
+	Algorithms []string
+	Secret string
+	PublicKeyFile string
+	JwksFile string
+	Issuer string
+	Audience string
+}
+
+func (o *JWTOptionsTest) GetAlgorithms() []string {
+	return o.Algorithms
+}
+
+func (o *JWTOptionsTest) GetSecret() string {
+	return o.Secret
+}
+
+func (o *JWTOptionsTest) GetSecretFile() string {
+	return ""
+}
+
+func (o *JWTOptionsTest) GetPublicKeyFile() string {
+	return o.PublicKeyFile
+}
+
+func (o *JWTOptionsTest) GetJwksFile() string {
+	return o.JwksFile
+}
+
+func (o *JWTOptionsTest) GetIssuer() string {
+	return o.Issuer
+}
+
+func (o *JWTOptionsTest) GetAudience() string {
+	return o.Audience
+}
+
+func (o *JWTOptionsTest) GetLeeway() (time.Duration, error) {
+	return 0, nil
+}
+
+func (o *JWTOptionsTest) GetPrincipalClaim() string {
+	return "sub"
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"github.com/opwire/opwire-agent/lib/utils"
+)
+
+type ListenerOptions interface {
+	GetName() string
+	GetAddress() string
+	GetSocket() string
+	GetMode() (os.FileMode, error)
+	GetOwner() string
+	GetRoutes() []string
+}
+
+// listenerSpec describes a listener of the agent and the route groups it serves.
+type listenerSpec struct {
+	name string
+	network string
+	address string
+	mode os.FileMode
+	owner string
+	routes []string
+}
+
+func newListenerSpec(opts ListenerOptions) (*listenerSpec, error) {
+	spec := &listenerSpec{ name: opts.GetName(), network: "tcp", address: opts.GetAddress(), owner: opts.GetOwner() }
+	if socket := opts.GetSocket(); len(socket) > 0 {
+		spec.network = "unix"
+		spec.address = socket
+	}
+	if len(spec.address) == 0 {
+		return nil, fmt.Errorf("Listener [%s] must declare an address or a socket", spec.name)
+	}
+	mode, err := opts.GetMode()
+	if err != nil {
+		return nil, fmt.Errorf("Listener [%s] has a malformed mode: %s", spec.name, err)
+	}
+	spec.mode = mode
+	spec.routes = opts.GetRoutes()
+	if spec.routes == nil {
+		spec.routes = ALL_ROUTE_GROUPS
+	}
+	for _, group := range spec.routes {
+		if !utils.Contains(ALL_ROUTE_GROUPS, group) {
+			return nil, fmt.Errorf("Listener [%s] has an unknown route group [%s]", spec.name, group)
+		}
+	}
+	return spec, nil
+}
+
+// listen() opens the listener, a Unix domain socket replaces the stale socket file of a
+// previous run and receives the declared file mode & owner.
+func (l *listenerSpec) listen() (net.Listener, error) {
+	if l.network != "unix" {
+		return net.Listen(l.network, l.address)
+	}
+	if info, err := os.Lstat(l.address); err == nil && info.Mode() & os.ModeSocket != 0 {
+		os.Remove(l.address)
+	}
+	listener, err := net.Listen("unix", l.address)
+	if err != nil {
+		return nil, err
+	}
+	if l.mode != 0 {
+		if err := os.Chmod(l.address, l.mode); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	if len(l.owner) > 0 {
+		uid, gid, err := lookupOwner(l.owner)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		if err := os.Chown(l.address, uid, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// lookupOwner() resolves the "user", "user:group" or ":group" names (or numeric ids), -1
+// keeps the current id.
+func lookupOwner(owner string) (int, int, error) {
+	uid, gid := -1, -1
+	parts := strings.SplitN(owner, ":", 2)
+	if name := parts[0]; len(name) > 0 {
+		if id, err := strconv.Atoi(name); err == nil {
+			uid = id
+		} else {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return -1, -1, err
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+	if len(parts) > 1 && len(parts[1]) > 0 {
+		name := parts[1]
+		if id, err := strconv.Atoi(name); err == nil {
+			gid = id
+		} else {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return -1, -1, err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+	return uid, gid, nil
+}
+
+// routedListener tags the accepted connections with the index of the listener, so that the
+// requests are dispatched to the router of the listener's route groups.
+type routedListener struct {
+	net.Listener
+	index int
+}
+
+type routedConn struct {
+	net.Conn
+	index int
+}
+
+type routedAddr struct {
+	net.Addr
+	index int
+}
+
+func (l *routedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &routedConn{ Conn: conn, index: l.index }, nil
+}
+
+func (c *routedConn) LocalAddr() net.Addr {
+	return &routedAddr{ Addr: c.Conn.LocalAddr(), index: c.index }
+}
+
+// RemoteAddr() reports the peers of a Unix domain socket as the loopback address, they are
+// local processes and the IP access lists would reject them otherwise.
+func (c *routedConn) RemoteAddr() net.Addr {
+	if _, ok := c.Conn.LocalAddr().(*net.UnixAddr); ok {
+		return &net.TCPAddr{ IP: net.IPv4(127, 0, 0, 1) }
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// newRoutedHandler() dispatches the requests to the router of the listener which accepted
+// the connection, the other requests are served by the fallback handler.
+func newRoutedHandler(routers []http.Handler, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(*routedAddr); ok {
+			if addr.index >= 0 && addr.index < len(routers) {
+				routers[addr.index].ServeHTTP(w, r)
+				return
+			}
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+const ROUTE_GROUP_EXEC string = "exec"
+const ROUTE_GROUP_PATTERNS string = "patterns"
+const ROUTE_GROUP_CONTROL string = "control"
+const ROUTE_GROUP_STATIC string = "static"
+
+var ALL_ROUTE_GROUPS []string = []string{ ROUTE_GROUP_EXEC, ROUTE_GROUP_PATTERNS, ROUTE_GROUP_CONTROL, ROUTE_GROUP_STATIC }
+
+const DEFAULT_LISTENER_NAME string = "default"
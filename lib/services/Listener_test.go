@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewListenerSpec(t *testing.T) {
+	t.Run("listener serves all of the route groups by default", func(t *testing.T) {
+		spec, err := newListenerSpec(&ListenerOptionsTest{ Address: "127.0.0.1:17779" })
+		assert.Nil(t, err)
+		assert.Equal(t, "tcp", spec.network)
+		assert.Equal(t, ALL_ROUTE_GROUPS, spec.routes)
+	})
+	t.Run("socket listener is a Unix domain socket", func(t *testing.T) {
+		spec, err := newListenerSpec(&ListenerOptionsTest{ Socket: "/run/opwire/admin.sock", Mode: 0660, Routes: []string{ "control" } })
+		assert.Nil(t, err)
+		assert.Equal(t, "unix", spec.network)
+		assert.Equal(t, "/run/opwire/admin.sock", spec.address)
+		assert.Equal(t, os.FileMode(0660), spec.mode)
+	})
+	t.Run("invalid listeners are refused", func(t *testing.T) {
+		_, err := newListenerSpec(&ListenerOptionsTest{})
+		assert.NotNil(t, err)
+		_, err = newListenerSpec(&ListenerOptionsTest{ Address: ":8080", Routes: []string{ "admin" } })
+		assert.NotNil(t, err)
+	})
+}
+
+func TestRoutedListeners(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "opwire-listener")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "admin.sock")
+
+	// a stale socket file of a previous run is replaced
+	stale, err := net.Listen("unix", socket)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	admin := &listenerSpec{ name: "admin", network: "unix", address: socket, mode: 0600, routes: []string{ ROUTE_GROUP_CONTROL } }
+	public := &listenerSpec{ name: "public", network: "tcp", address: "127.0.0.1:0", routes: []string{ ROUTE_GROUP_EXEC } }
+
+	server := &http.Server{
+		Handler: newRoutedHandler([]http.Handler{
+			makeTestRouteHandler("control"),
+			makeTestRouteHandler("exec"),
+		}, makeTestRouteHandler("fallback")),
+	}
+	defer server.Close()
+
+	for i, spec := range []*listenerSpec{ admin, public } {
+		listener, err := spec.listen()
+		assert.Nil(t, err)
+		if spec == public {
+			public.address = listener.Addr().String()
+		}
+		go server.Serve(&routedListener{ Listener: listener, index: i })
+	}
+
+	info, err := os.Stat(socket)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	unixClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	assert.Equal(t, "control", getTestRouteBody(t, unixClient, "http://unix/_/health"))
+	assert.Equal(t, "exec", getTestRouteBody(t, http.DefaultClient, "http://" + public.address + "/-/"))
+}
+
+func TestRoutedListeners_UnixPeer(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "opwire-listener")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "admin.sock")
+
+	listener, err := net.Listen("unix", socket)
+	assert.Nil(t, err)
+	control, _ := NewAccessList(&AccessListOptionsTest{ Allow: []string{ "127.0.0.1" } })
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := AllowRequest(r, control); !ok {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			io.WriteString(w, r.RemoteAddr)
+		}),
+	}
+	defer server.Close()
+	go server.Serve(&routedListener{ Listener: listener, index: 0 })
+
+	unixClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	assert.Equal(t, "127.0.0.1:0", getTestRouteBody(t, unixClient, "http://unix/_/health"))
+}
+
+func makeTestRouteHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	})
+}
+
+func getTestRouteBody(t *testing.T, client *http.Client, url string) string {
+	res, err := client.Get(url)
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	return string(body)
+}
+
+type ListenerOptionsTest struct {
+	Name string
+	Address string
+	Socket string
+	Mode os.FileMode
+	Owner string
+	Routes []string
+}
+
+func (o *ListenerOptionsTest) GetName() string {
+	return o.Name
+}
+
+func (o *ListenerOptionsTest) GetAddress() string {
+	return o.Address
+}
+
+func (o *ListenerOptionsTest) GetSocket() string {
+	return o.Socket
+}
+
+func (o *ListenerOptionsTest) GetMode() (os.FileMode, error) {
+	return o.Mode, nil
+}
+
+func (o *ListenerOptionsTest) GetOwner() string {
+	return o.Owner
+}
+
+func (o *ListenerOptionsTest) GetRoutes() []string {
+	return o.Routes
+}
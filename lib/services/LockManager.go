@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"github.com/opwire/opwire-agent/lib/invokers"
+)
+
+// LockManager holds the named locks that are declared by the resources, so that the commands
+// of different resources (e.g. backup, restore and migrate) never overlap. A lock is either
+// exclusive (the default) or shared ("<name>:shared"), the shared holders may run together.
+type LockManager struct {
+	lock sync.Mutex
+	locks map[string]*namedLock
+	resources map[string]*resourceLocks
+	sequence uint64
+}
+
+type LockHolder struct {
+	Lock string `json:"lock"`
+	Mode string `json:"mode"`
+	ResourceName string `json:"resource"`
+	MethodName string `json:"method,omitempty"`
+	RequestId string `json:"requestId,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+type namedLock struct {
+	name string
+	readers int
+	writer bool
+	writersWaiting int
+	holders map[uint64]*LockHolder
+	changed chan struct{}
+}
+
+type resourceLocks struct {
+	specs []*lockSpec
+	timeout time.Duration
+}
+
+type lockSpec struct {
+	name string
+	shared bool
+}
+
+func NewLockManager() *LockManager {
+	m := new(LockManager)
+	m.locks = make(map[string]*namedLock)
+	m.resources = make(map[string]*resourceLocks)
+	return m
+}
+
+// Register() declares the locks of a resource. The locks are sorted by name, so that all of
+// resources acquire them in the same order and the acquisitions never deadlock.
+func (m *LockManager) Register(resourceName string, locks []string, timeout time.Duration) error {
+	if len(locks) == 0 {
+		return nil
+	}
+	modes := make(map[string]bool)
+	for _, lock := range locks {
+		name, shared, err := parseLockSpec(lock)
+		if err != nil {
+			return err
+		}
+		// the exclusive mode wins if a lock is declared twice
+		if prev, ok := modes[name]; ok {
+			shared = prev && shared
+		}
+		modes[name] = shared
+	}
+	specs := make([]*lockSpec, 0, len(modes))
+	for name, shared := range modes {
+		specs = append(specs, &lockSpec{ name: name, shared: shared })
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].name < specs[j].name
+	})
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.resources[normalizeResourceName(resourceName)] = &resourceLocks{ specs: specs, timeout: timeout }
+	return nil
+}
+
+// Acquire() takes all of the locks of the resource, or none of them. The waiting is bound to
+// the context and the lock-timeout of the resource.
+func (m *LockManager) Acquire(ctx context.Context, ci *invokers.CommandInvocation) (func(), error) {
+	m.lock.Lock()
+	rl, ok := m.resources[normalizeResourceName(ci.ResourceName)]
+	m.lock.Unlock()
+	if !ok {
+		return func() {}, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if rl.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rl.timeout)
+		defer cancel()
+	}
+	type acquisition struct {
+		lock *namedLock
+		id uint64
+		shared bool
+	}
+	acquired := make([]*acquisition, 0, len(rl.specs))
+	release := func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		for i := len(acquired) - 1; i >= 0; i-- {
+			acquired[i].lock.release(acquired[i].id, acquired[i].shared)
+		}
+	}
+	for _, spec := range rl.specs {
+		l, id, err := m.acquire(ctx, spec, ci)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		acquired = append(acquired, &acquisition{ lock: l, id: id, shared: spec.shared })
+	}
+	return release, nil
+}
+
+func (m *LockManager) acquire(ctx context.Context, spec *lockSpec, ci *invokers.CommandInvocation) (*namedLock, uint64, error) {
+	m.lock.Lock()
+	l, ok := m.locks[spec.name]
+	if !ok {
+		l = &namedLock{
+			name: spec.name,
+			holders: make(map[uint64]*LockHolder),
+			changed: make(chan struct{}),
+		}
+		m.locks[spec.name] = l
+	}
+	if !spec.shared {
+		l.writersWaiting++
+	}
+	for {
+		if l.available(spec.shared) {
+			if !spec.shared {
+				l.writersWaiting--
+				l.writer = true
+			} else {
+				l.readers++
+			}
+			m.sequence++
+			id := m.sequence
+			l.holders[id] = &LockHolder{
+				Lock: spec.name,
+				Mode: lockModeName(spec.shared),
+				ResourceName: normalizeResourceName(ci.ResourceName),
+				MethodName: ci.MethodName,
+				RequestId: ci.RequestId,
+				Since: time.Now(),
+			}
+			m.lock.Unlock()
+			return l, id, nil
+		}
+		changed := l.changed
+		m.lock.Unlock()
+		select {
+		case <-changed:
+			m.lock.Lock()
+		case <-ctx.Done():
+			m.lock.Lock()
+			if !spec.shared {
+				l.writersWaiting--
+				l.notify()
+			}
+			m.lock.Unlock()
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, 0, &LimitError{
+					Limiter: "lock/" + spec.name,
+					Reason: "the waiting time of lock is over",
+					StatusCode: http.StatusServiceUnavailable,
+					RetryAfter: time.Second,
+				}
+			}
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+// Holders() returns the current holders of all of locks.
+func (m *LockManager) Holders() []*LockHolder {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	holders := make([]*LockHolder, 0)
+	for _, l := range m.locks {
+		for _, holder := range l.holders {
+			holders = append(holders, holder)
+		}
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].Lock != holders[j].Lock {
+			return holders[i].Lock < holders[j].Lock
+		}
+		return holders[i].Since.Before(holders[j].Since)
+	})
+	return holders
+}
+
+// available() checks the lock state, the new shared holders give way to the waiting writers.
+func (l *namedLock) available(shared bool) bool {
+	if shared {
+		return !l.writer && l.writersWaiting == 0
+	}
+	return !l.writer && l.readers == 0
+}
+
+func (l *namedLock) release(id uint64, shared bool) {
+	delete(l.holders, id)
+	if shared {
+		l.readers--
+	} else {
+		l.writer = false
+	}
+	l.notify()
+}
+
+func (l *namedLock) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func parseLockSpec(spec string) (string, bool, error) {
+	name, mode := spec, LOCK_MODE_EXCLUSIVE
+	if pos := strings.LastIndex(spec, ":"); pos >= 0 {
+		name, mode = spec[:pos], spec[pos+1:]
+	}
+	if len(name) == 0 {
+		return "", false, fmt.Errorf("Lock [%s] must have a name", spec)
+	}
+	switch mode {
+	case LOCK_MODE_EXCLUSIVE:
+		return name, false, nil
+	case LOCK_MODE_SHARED:
+		return name, true, nil
+	}
+	return "", false, fmt.Errorf("Lock [%s] has an unsupported mode [%s]", spec, mode)
+}
+
+func lockModeName(shared bool) string {
+	if shared {
+		return LOCK_MODE_SHARED
+	}
+	return LOCK_MODE_EXCLUSIVE
+}
+
+func normalizeResourceName(resourceName string) string {
+	if len(resourceName) == 0 {
+		return invokers.MAIN_RESOURCE
+	}
+	return resourceName
+}
+
+const LOCK_MODE_EXCLUSIVE string = "exclusive"
+const LOCK_MODE_SHARED string = "shared"
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/opwire/opwire-agent/lib/invokers"
+)
+
+func TestLockManager_Acquire(t *testing.T) {
+	t.Run("exclusive locks are not overlapped across resources", func(t *testing.T) {
+		m := NewLockManager()
+		assert.Nil(t, m.Register("backup", []string{ "db-maintenance" }, 20 * time.Millisecond))
+		assert.Nil(t, m.Register("restore", []string{ "db-maintenance:exclusive" }, 20 * time.Millisecond))
+		release, err := m.Acquire(context.Background(), &invokers.CommandInvocation{ ResourceName: "backup", RequestId: "1" })
+		assert.Nil(t, err)
+		_, err = m.Acquire(context.Background(), &invokers.CommandInvocation{ ResourceName: "restore" })
+		limitErr, ok := err.(*LimitError)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusServiceUnavailable, limitErr.StatusCode)
+
+		holders := m.Holders()
+		assert.Equal(t, 1, len(holders))
+		assert.Equal(t, "db-maintenance", holders[0].Lock)
+		assert.Equal(t, "backup", holders[0].ResourceName)
+		assert.Equal(t, "1", holders[0].RequestId)
+
+		release()
+		release, err = m.Acquire(context.Background(), &invokers.CommandInvocation{ ResourceName: "restore" })
+		assert.Nil(t, err)
+		release()
+		assert.Equal(t, 0, len(m.Holders()))
+	})
+	t.Run("shared locks are held together", func(t *testing.T) {
+		m := NewLockManager()
+		m.Register("report", []string{ "db:shared" }, 20 * time.Millisecond)
+		m.Register("migrate", []string{ "db" }, 20 * time.Millisecond)
+		release1, err := m.Acquire(context.Background(), &invokers.CommandInvocation{ ResourceName: "report" })
+		assert.Nil(t, err)
+		release2, err := m.Acquire(context.Background(), &invokers.CommandInvocation{ ResourceName: "report" })
+		assert.Nil(t, err)
+		_, err = m.Acquire(context.Background(), &invokers.CommandInvocation{ ResourceName: "migrate" })
+		assert.NotNil(t, err)
+		release1()
+		release2()
+		release, err := m.Acquire(context.Background(), &invokers.CommandInvocation{ ResourceName: "migrate" })
+		assert.Nil(t, err)
+		release()
+	})
+	t.Run("resources without locks are not blocked", func(t *testing.T) {
+		m := NewLockManager()
+		release, err := m.Acquire(nil, &invokers.CommandInvocation{ ResourceName: "products" })
+		assert.Nil(t, err)
+		release()
+	})
+	t.Run("locks are acquired in the same order", func(t *testing.T) {
+		m := NewLockManager()
+		m.Register("first", []string{ "a", "b" }, 0)
+		m.Register("second", []string{ "b", "a" }, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			for _, name := range []string{ "first", "second" } {
+				wg.Add(1)
+				go func(name string) {
+					defer wg.Done()
+					release, err := m.Acquire(context.Background(), &invokers.CommandInvocation{ ResourceName: name })
+					if assert.Nil(t, err) {
+						release()
+					}
+				}(name)
+			}
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the acquisitions are deadlocked")
+		}
+	})
+	t.Run("invalid lock mode is rejected", func(t *testing.T) {
+		m := NewLockManager()
+		assert.NotNil(t, m.Register("backup", []string{ "db:unknown" }, 0))
+	})
+}
@@ -0,0 +1,389 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/opwire/opwire-agent/lib/invokers"
+)
+
+// MetricsRegistry collects the counters, gauges and histograms of the agent and renders
+// them in the Prometheus text exposition format (version 0.0.4).
+type MetricsRegistry struct {
+	families []*metricFamily
+	mutex sync.RWMutex
+}
+
+type metricFamily struct {
+	name string
+	help string
+	kind string
+	labels []string
+	buckets []float64
+	series map[string]*metricSeries
+	mutex sync.Mutex
+}
+
+type metricSeries struct {
+	values []string
+	value float64
+	counts []uint64
+	count uint64
+}
+
+type MetricCounter struct {
+	family *metricFamily
+}
+
+type MetricGauge struct {
+	family *metricFamily
+}
+
+type MetricHistogram struct {
+	family *metricFamily
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+func (m *MetricsRegistry) Counter(name string, help string, labels ...string) *MetricCounter {
+	return &MetricCounter{ family: m.register(name, help, METRIC_TYPE_COUNTER, nil, labels) }
+}
+
+func (m *MetricsRegistry) Gauge(name string, help string, labels ...string) *MetricGauge {
+	return &MetricGauge{ family: m.register(name, help, METRIC_TYPE_GAUGE, nil, labels) }
+}
+
+// Histogram() creates a histogram with the upper bounds of the buckets (in ascending order),
+// DEFAULT_METRIC_BUCKETS is used if buckets is empty.
+func (m *MetricsRegistry) Histogram(name string, help string, buckets []float64, labels ...string) *MetricHistogram {
+	if len(buckets) == 0 {
+		buckets = DEFAULT_METRIC_BUCKETS
+	}
+	return &MetricHistogram{ family: m.register(name, help, METRIC_TYPE_HISTOGRAM, buckets, labels) }
+}
+
+func (m *MetricsRegistry) register(name string, help string, kind string, buckets []float64, labels []string) *metricFamily {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, f := range m.families {
+		if f.name == name {
+			panic(fmt.Sprintf("Metric [%s] has already been registered", name))
+		}
+	}
+	f := &metricFamily{
+		name: name,
+		help: help,
+		kind: kind,
+		labels: labels,
+		buckets: buckets,
+		series: make(map[string]*metricSeries),
+	}
+	m.families = append(m.families, f)
+	return f
+}
+
+// WriteText() renders the families in their registration order, the series of a family are
+// sorted by their label values.
+func (m *MetricsRegistry) WriteText(w io.Writer) error {
+	m.mutex.RLock()
+	families := make([]*metricFamily, len(m.families))
+	copy(families, m.families)
+	m.mutex.RUnlock()
+	var buf bytes.Buffer
+	for _, f := range families {
+		f.writeText(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (c *MetricCounter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add() increases the counter, a negative delta is ignored.
+func (c *MetricCounter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.family.update(values, func(s *metricSeries) {
+		s.value += delta
+	})
+}
+
+func (g *MetricGauge) Set(value float64, values ...string) {
+	g.family.update(values, func(s *metricSeries) {
+		s.value = value
+	})
+}
+
+func (g *MetricGauge) Add(delta float64, values ...string) {
+	g.family.update(values, func(s *metricSeries) {
+		s.value += delta
+	})
+}
+
+func (h *MetricHistogram) Observe(value float64, values ...string) {
+	h.family.update(values, func(s *metricSeries) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.family.buckets))
+		}
+		for i, bound := range h.family.buckets {
+			if value <= bound {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.value += value
+	})
+}
+
+func (f *metricFamily) update(values []string, apply func(s *metricSeries)) {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("Metric [%s] expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{ values: append([]string(nil), values...) }
+		f.series[key] = s
+	}
+	apply(s)
+}
+
+func (f *metricFamily) writeText(buf *bytes.Buffer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeMetricHelp(f.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != METRIC_TYPE_HISTOGRAM {
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, formatMetricLabels(f.labels, s.values, "", ""), formatMetricValue(s.value))
+			continue
+		}
+		for i, bound := range f.buckets {
+			var count uint64
+			if s.counts != nil {
+				count = s.counts[i]
+			}
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatMetricLabels(f.labels, s.values, "le", formatMetricValue(bound)), count)
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatMetricLabels(f.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, formatMetricLabels(f.labels, s.values, "", ""), formatMetricValue(s.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", f.name, formatMetricLabels(f.labels, s.values, "", ""), s.count)
+	}
+}
+
+func formatMetricLabels(labels []string, values []string, extraLabel string, extraValue string) string {
+	pairs := make([]string, 0, len(labels) + 1)
+	for i, label := range labels {
+		pairs = append(pairs, label + `="` + escapeMetricLabel(values[i]) + `"`)
+	}
+	if len(extraLabel) > 0 {
+		pairs = append(pairs, extraLabel + `="` + extraValue + `"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var metricHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeMetricHelp(help string) string {
+	return metricHelpEscaper.Replace(help)
+}
+
+func escapeMetricLabel(value string) string {
+	return metricLabelEscaper.Replace(value)
+}
+
+// AgentMetrics holds the metrics of the agent, it is fed by the HTTP handlers, the
+// ReqRestrictor and the Executor (as an ExecutionObserver). All of its methods are no-op
+// on a nil receiver.
+type AgentMetrics struct {
+	registry *MetricsRegistry
+	requests *MetricCounter
+	requestDuration *MetricHistogram
+	executionDuration *MetricHistogram
+	executionsInFlight *MetricGauge
+	timeouts *MetricCounter
+	kills *MetricCounter
+	spawnFailures *MetricCounter
+	stdoutBytes *MetricCounter
+	stderrBytes *MetricCounter
+	limiterActive *MetricGauge
+	limiterQueued *MetricGauge
+	semaphoreWait *MetricHistogram
+	singleFlightShared *MetricCounter
+	resources map[string]bool
+	resourcesMutex sync.RWMutex
+}
+
+func NewAgentMetrics() *AgentMetrics {
+	r := NewMetricsRegistry()
+	return &AgentMetrics{
+		registry: r,
+		requests: r.Counter("opwire_requests_total",
+			"Number of the HTTP requests of the resources.", "resource", "method", "status"),
+		requestDuration: r.Histogram("opwire_request_duration_seconds",
+			"Duration of the HTTP requests of the resources.", nil, "resource", "method"),
+		executionDuration: r.Histogram("opwire_execution_duration_seconds",
+			"Duration of the command executions.", nil, "resource", "method"),
+		executionsInFlight: r.Gauge("opwire_executions_in_flight",
+			"Number of the command executions in progress.", "resource", "method"),
+		timeouts: r.Counter("opwire_execution_timeouts_total",
+			"Number of the command executions exceeding their timeout.", "resource", "method"),
+		kills: r.Counter("opwire_execution_kills_total",
+			"Number of the command executions killed, by reason (timeout or shutdown).", "resource", "method", "reason"),
+		spawnFailures: r.Counter("opwire_process_spawn_failures_total",
+			"Number of the processes which could not be started.", "resource", "method"),
+		stdoutBytes: r.Counter("opwire_execution_stdout_bytes_total",
+			"Number of the bytes written to stdout by the commands.", "resource", "method"),
+		stderrBytes: r.Counter("opwire_execution_stderr_bytes_total",
+			"Number of the bytes written to stderr by the commands.", "resource", "method"),
+		limiterActive: r.Gauge("opwire_concurrent_limit_active",
+			"Number of the permits held on the concurrent limiters.", "limiter"),
+		limiterQueued: r.Gauge("opwire_concurrent_limit_queued",
+			"Number of the requests queued on the concurrent limiters.", "limiter"),
+		semaphoreWait: r.Histogram("opwire_semaphore_wait_seconds",
+			"Time spent waiting for the concurrent limit permits.", nil, "resource", "method"),
+		singleFlightShared: r.Counter("opwire_singleflight_shared_total",
+			"Number of the requests served by the result of a duplicated request.", "resource", "method"),
+		resources: make(map[string]bool),
+	}
+}
+
+// AddResource() declares a registered resource, the requests of the other names are
+// recorded with the METRIC_LABEL_UNKNOWN label (the names come from the request path).
+func (m *AgentMetrics) AddResource(resourceName string) {
+	if m == nil {
+		return
+	}
+	m.resourcesMutex.Lock()
+	defer m.resourcesMutex.Unlock()
+	m.resources[normalizeResourceName(resourceName)] = true
+}
+
+func (m *AgentMetrics) ObserveRequest(resourceName string, methodName string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	resourceName = normalizeResourceName(resourceName)
+	m.resourcesMutex.RLock()
+	if !m.resources[resourceName] {
+		resourceName = METRIC_LABEL_UNKNOWN
+	}
+	m.resourcesMutex.RUnlock()
+	if !isMethodAccepted(methodName) {
+		methodName = METRIC_LABEL_UNKNOWN
+	}
+	m.requests.Inc(resourceName, methodName, strconv.Itoa(status))
+	m.requestDuration.Observe(duration.Seconds(), resourceName, methodName)
+}
+
+func (m *AgentMetrics) ObserveSemaphoreWait(resourceName string, methodName string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.semaphoreWait.Observe(duration.Seconds(), normalizeResourceName(resourceName), methodName)
+}
+
+func (m *AgentMetrics) ObserveSingleFlightShared(resourceName string, methodName string) {
+	if m == nil {
+		return
+	}
+	m.singleFlightShared.Inc(normalizeResourceName(resourceName), methodName)
+}
+
+func (m *AgentMetrics) ExecutionStarted(resourceName string, methodName string) {
+	if m == nil {
+		return
+	}
+	m.executionsInFlight.Add(1, normalizeResourceName(resourceName), methodName)
+}
+
+func (m *AgentMetrics) ExecutionFinished(resourceName string, methodName string, state *invokers.ExecutionState, err error) {
+	if m == nil {
+		return
+	}
+	resourceName = normalizeResourceName(resourceName)
+	m.executionsInFlight.Add(-1, resourceName, methodName)
+	if _, ok := err.(*invokers.SpawnError); ok {
+		m.spawnFailures.Inc(resourceName, methodName)
+	}
+	if state == nil {
+		return
+	}
+	m.executionDuration.Observe(state.Duration.Seconds(), resourceName, methodName)
+	m.stdoutBytes.Add(float64(state.StdoutBytes), resourceName, methodName)
+	m.stderrBytes.Add(float64(state.StderrBytes), resourceName, methodName)
+	if state.IsTimeout {
+		m.timeouts.Inc(resourceName, methodName)
+		m.kills.Inc(resourceName, methodName, "timeout")
+	}
+}
+
+func (m *AgentMetrics) ExecutionSignalled(resourceName string, methodName string, sig os.Signal, processes int) {
+	if m == nil || sig != os.Kill || processes == 0 {
+		return
+	}
+	m.kills.Inc(normalizeResourceName(resourceName), methodName, "shutdown")
+}
+
+// CollectLimiters() refreshes the gauges of the concurrent limiters, it is called before the
+// metrics are rendered.
+func (m *AgentMetrics) CollectLimiters(stats []*ConcurrencyStats) {
+	if m == nil {
+		return
+	}
+	for _, item := range stats {
+		m.limiterActive.Set(float64(item.Active), item.Name)
+		m.limiterQueued.Set(float64(item.Queued), item.Name)
+	}
+}
+
+func (m *AgentMetrics) WriteText(w io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	return m.registry.WriteText(w)
+}
+
+const METRIC_TYPE_COUNTER string = "counter"
+const METRIC_TYPE_GAUGE string = "gauge"
+const METRIC_TYPE_HISTOGRAM string = "histogram"
+const METRIC_LABEL_UNKNOWN string = "unknown"
+const METRICS_CONTENT_TYPE string = "text/plain; version=0.0.4; charset=utf-8"
+
+var DEFAULT_METRIC_BUCKETS = []float64{ .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10 }
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"github.com/opwire/opwire-agent/lib/invokers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsRegistry_WriteText(t *testing.T) {
+	r := NewMetricsRegistry()
+	counter := r.Counter("test_requests_total", "Number of the requests.", "resource", "status")
+	gauge := r.Gauge("test_in_flight", "Number of the \\ requests\nin progress.")
+	histogram := r.Histogram("test_duration_seconds", "Duration.", []float64{ 0.1, 1 }, "resource")
+
+	counter.Inc("sum", "200")
+	counter.Add(2, "sum", "200")
+	counter.Inc("a\"b", "500")
+	counter.Add(-1, "sum", "200")
+	gauge.Add(3)
+	gauge.Add(-1)
+	histogram.Observe(0.05, "sum")
+	histogram.Observe(0.5, "sum")
+	histogram.Observe(5, "sum")
+
+	var buf bytes.Buffer
+	assert.Nil(t, r.WriteText(&buf))
+	assert.Equal(t, strings.Join([]string{
+		`# HELP test_requests_total Number of the requests.`,
+		`# TYPE test_requests_total counter`,
+		`test_requests_total{resource="a\"b",status="500"} 1`,
+		`test_requests_total{resource="sum",status="200"} 3`,
+		`# HELP test_in_flight Number of the \\ requests\nin progress.`,
+		`# TYPE test_in_flight gauge`,
+		`test_in_flight 2`,
+		`# HELP test_duration_seconds Duration.`,
+		`# TYPE test_duration_seconds histogram`,
+		`test_duration_seconds_bucket{resource="sum",le="0.1"} 1`,
+		`test_duration_seconds_bucket{resource="sum",le="1"} 2`,
+		`test_duration_seconds_bucket{resource="sum",le="+Inf"} 3`,
+		`test_duration_seconds_sum{resource="sum"} 5.55`,
+		`test_duration_seconds_count{resource="sum"} 3`,
+		``,
+	}, "\n"), buf.String())
+}
+
+func TestMetricsRegistry_Register(t *testing.T) {
+	r := NewMetricsRegistry()
+	counter := r.Counter("test_total", "Total.", "resource")
+	assert.Panics(t, func() {
+		r.Gauge("test_total", "Duplicated.")
+	})
+	assert.Panics(t, func() {
+		counter.Inc("sum", "GET")
+	})
+}
+
+func TestAgentMetrics(t *testing.T) {
+	m := NewAgentMetrics()
+	m.AddResource("")
+	m.AddResource("sum")
+	m.ObserveRequest("", "GET", 200, 20 * time.Millisecond)
+	m.ObserveRequest("sum", "POST", 408, 2 * time.Second)
+	m.ObserveRequest("sum-1", "POST", 404, time.Millisecond)
+	m.ObserveRequest("sum-2", "PURGE", 404, time.Millisecond)
+	m.ExecutionStarted("sum", "POST")
+	m.ExecutionStarted("sum", "POST")
+	m.ExecutionFinished("sum", "POST", &invokers.ExecutionState{
+		IsTimeout: true,
+		Duration: 2 * time.Second,
+		StdoutBytes: 12,
+		StderrBytes: 3,
+	}, fmt.Errorf("signal: killed"))
+	m.ExecutionSignalled("sum", "POST", os.Kill, 2)
+	m.ExecutionSignalled("sum", "POST", os.Interrupt, 2)
+	m.ExecutionFinished("sum", "POST", nil, &invokers.SpawnError{ Err: fmt.Errorf("not found") })
+	m.ObserveSingleFlightShared("sum", "POST")
+	m.ObserveSemaphoreWait("sum", "POST", 30 * time.Millisecond)
+	m.CollectLimiters([]*ConcurrencyStats{ &ConcurrencyStats{ Name: GLOBAL_LIMITER, Active: 2, Queued: 5 } })
+
+	var buf bytes.Buffer
+	assert.Nil(t, m.WriteText(&buf))
+	text := buf.String()
+	for _, line := range []string{
+		`opwire_requests_total{resource=":default-resource:",method="GET",status="200"} 1`,
+		`opwire_requests_total{resource="sum",method="POST",status="408"} 1`,
+		`opwire_requests_total{resource="unknown",method="POST",status="404"} 1`,
+		`opwire_requests_total{resource="unknown",method="unknown",status="404"} 1`,
+		`opwire_request_duration_seconds_count{resource="sum",method="POST"} 1`,
+		`opwire_execution_duration_seconds_sum{resource="sum",method="POST"} 2`,
+		`opwire_executions_in_flight{resource="sum",method="POST"} 0`,
+		`opwire_execution_timeouts_total{resource="sum",method="POST"} 1`,
+		`opwire_execution_kills_total{resource="sum",method="POST",reason="shutdown"} 1`,
+		`opwire_execution_kills_total{resource="sum",method="POST",reason="timeout"} 1`,
+		`opwire_process_spawn_failures_total{resource="sum",method="POST"} 1`,
+		`opwire_execution_stdout_bytes_total{resource="sum",method="POST"} 12`,
+		`opwire_execution_stderr_bytes_total{resource="sum",method="POST"} 3`,
+		`opwire_concurrent_limit_active{limiter="` + GLOBAL_LIMITER + `"} 2`,
+		`opwire_concurrent_limit_queued{limiter="` + GLOBAL_LIMITER + `"} 5`,
+		`opwire_semaphore_wait_seconds_bucket{resource="sum",method="POST",le="0.05"} 1`,
+		`opwire_singleflight_shared_total{resource="sum",method="POST"} 1`,
+	} {
+		assert.Contains(t, text, line + "\n")
+	}
+
+	// a nil AgentMetrics is a no-op
+	var disabled *AgentMetrics
+	disabled.ObserveRequest("sum", "GET", 200, time.Second)
+	disabled.ExecutionStarted("sum", "GET")
+	assert.Nil(t, disabled.WriteText(&buf))
+}
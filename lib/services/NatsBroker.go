@@ -0,0 +1,209 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"github.com/nats-io/nats.go"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+type NatsBroker struct {
+	name string
+	url string
+	subject string
+	queueGroup string
+	deadLetterSubject string
+	conn *nats.Conn
+	logger *loq.Logger
+}
+
+type NatsBrokerOptions interface {
+	GetUrl() string
+	GetSubject() string
+	GetQueueGroup() string
+	GetDeadLetterSubject() string
+}
+
+func NewNatsBroker(name string, logger *loq.Logger, opts NatsBrokerOptions) (*NatsBroker, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("NatsBrokerOptions must not be nil")
+	}
+	if len(opts.GetSubject()) == 0 {
+		return nil, fmt.Errorf("Broker [%s] must declare a subject", name)
+	}
+	b := new(NatsBroker)
+	b.name = name
+	b.logger = logger
+	b.url = opts.GetUrl()
+	b.subject = opts.GetSubject()
+	b.queueGroup = opts.GetQueueGroup()
+	b.deadLetterSubject = opts.GetDeadLetterSubject()
+	return b, nil
+}
+
+func (b *NatsBroker) GetName() string {
+	return b.name
+}
+
+func (b *NatsBroker) Start(handler BrokerHandler) error {
+	if handler == nil {
+		return fmt.Errorf("BrokerHandler must not be nil")
+	}
+	conn, err := nats.Connect(b.url, nats.Name("opwire-agent/" + b.name))
+	if err != nil {
+		return err
+	}
+	cb := func(m *nats.Msg) {
+		b.process(m, handler)
+	}
+	if len(b.queueGroup) > 0 {
+		_, err = conn.QueueSubscribe(b.subject, b.queueGroup, cb)
+	} else {
+		_, err = conn.Subscribe(b.subject, cb)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	b.conn = conn
+	b.logger.Log(loq.InfoLevel, "NATS broker is consuming messages",
+		loq.String("broker", b.name),
+		loq.String("subject", b.subject),
+		loq.String("queueGroup", b.queueGroup))
+	return nil
+}
+
+// Stop() drains the subscriptions, so that the messages in progress are completed before closing.
+func (b *NatsBroker) Stop() error {
+	if b.conn == nil {
+		return nil
+	}
+	closed := make(chan struct{})
+	b.conn.SetClosedHandler(func(*nats.Conn) {
+		close(closed)
+	})
+	if err := b.conn.Drain(); err != nil {
+		b.conn.Close()
+		return err
+	}
+	<-closed
+	b.conn = nil
+	return nil
+}
+
+func (b *NatsBroker) process(m *nats.Msg, handler BrokerHandler) {
+	header := http.Header{}
+	for key, vals := range m.Header {
+		header[http.CanonicalHeaderKey(key)] = vals
+	}
+	msg := &BrokerMessage{
+		Id: header.Get(REQ_HEADER_REQUEST_ID_NAME),
+		Header: header,
+		Body: m.Data,
+		Origin: &RequestOrigin{
+			Kind: ORIGIN_KIND_NATS,
+			Name: b.name,
+			Subject: m.Subject,
+		},
+	}
+
+	result := handler(msg)
+
+	// the reply subject of a JetStream message receives the acknowledgements only
+	jetStream := strings.HasPrefix(m.Reply, NATS_JS_ACK_PREFIX)
+
+	// a deferred message (e.g. the agent is locked or draining) is not failed: a JetStream
+	// message is redelivered, a requester receives the error, and a message without a reply
+	// subject (nothing would redeliver it) is forwarded to the dead-letter subject
+	if result != nil && result.Retry {
+		errorMessage := result.ErrorMessage()
+		b.logger.Log(loq.WarnLevel, "The message is deferred",
+			loq.String("broker", b.name),
+			loq.String("subject", m.Subject),
+			loq.String("error", errorMessage))
+		if jetStream {
+			b.acknowledge(m, m.Nak)
+		} else if len(m.Reply) > 0 {
+			reply := nats.NewMsg(m.Reply)
+			reply.Header.Set(RES_HEADER_ERROR_MESSAGE, errorMessage)
+			b.publish(reply)
+		} else if !b.deadLetter(m, errorMessage) {
+			b.logger.Log(loq.ErrorLevel, "The deferred message is dropped, no dead-letter subject is declared",
+				loq.String("broker", b.name),
+				loq.String("subject", m.Subject))
+		}
+		return
+	}
+
+	if !result.Failed() {
+		if jetStream {
+			b.acknowledge(m, m.Ack)
+		} else if len(m.Reply) > 0 {
+			reply := nats.NewMsg(m.Reply)
+			reply.Data = result.Stdout
+			if result.State != nil && result.State.Duration > 0 {
+				reply.Header.Set(RES_HEADER_EXEC_DURATION, fmt.Sprintf("%f", result.State.Duration.Seconds()))
+			}
+			b.publish(reply)
+		}
+		return
+	}
+
+	errorMessage := result.ErrorMessage()
+	b.logger.Log(loq.ErrorLevel, "The message has failed",
+		loq.String("broker", b.name),
+		loq.String("subject", m.Subject),
+		loq.String("error", errorMessage))
+
+	if jetStream {
+		b.acknowledge(m, m.Term)
+	} else if len(m.Reply) > 0 {
+		reply := nats.NewMsg(m.Reply)
+		reply.Header.Set(RES_HEADER_ERROR_MESSAGE, errorMessage)
+		if result != nil {
+			reply.Data = result.Stderr
+		}
+		b.publish(reply)
+	}
+
+	b.deadLetter(m, errorMessage)
+}
+
+// deadLetter() forwards the message to the dead-letter subject, it returns false when no
+// dead-letter subject is declared.
+func (b *NatsBroker) deadLetter(m *nats.Msg, errorMessage string) bool {
+	if len(b.deadLetterSubject) == 0 {
+		return false
+	}
+	dead := nats.NewMsg(b.deadLetterSubject)
+	for key, vals := range m.Header {
+		dead.Header[key] = vals
+	}
+	dead.Header.Set(RES_HEADER_ERROR_MESSAGE, errorMessage)
+	dead.Header.Set(BROKER_HEADER_ORIGIN_SUBJECT, m.Subject)
+	dead.Data = m.Data
+	b.publish(dead)
+	return true
+}
+
+func (b *NatsBroker) acknowledge(m *nats.Msg, ack func(...nats.AckOpt) error) {
+	if err := ack(); err != nil {
+		b.logger.Log(loq.ErrorLevel, "Acknowledging message failed",
+			loq.String("broker", b.name),
+			loq.String("subject", m.Subject),
+			loq.Error(err))
+	}
+}
+
+func (b *NatsBroker) publish(m *nats.Msg) {
+	if err := b.conn.PublishMsg(m); err != nil {
+		b.logger.Log(loq.ErrorLevel, "Publishing message failed",
+			loq.String("broker", b.name),
+			loq.String("subject", m.Subject),
+			loq.Error(err))
+	}
+}
+
+const BROKER_HEADER_ORIGIN_SUBJECT string = "Opwire-Origin-Subject"
+const NATS_JS_ACK_PREFIX string = "$JS.ACK."
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/opwire/opwire-agent/lib/invokers"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+func TestNatsBroker(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{ Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true })
+	assert.Nil(t, err)
+	go ns.Start()
+	defer ns.Shutdown()
+	assert.True(t, ns.ReadyForConnections(5 * time.Second))
+
+	logger, _ := loq.NewLogger(nil)
+	url := ns.ClientURL()
+	broker, err := NewNatsBroker("orders", logger, &NatsBrokerOptionsTest{
+		Url: url,
+		Subject: "orders.create",
+		DeadLetterSubject: "orders.failed",
+	})
+	assert.Nil(t, err)
+
+	err = broker.Start(func(msg *BrokerMessage) *BrokerResult {
+		if msg.Header.Get("X-Locked") != "" {
+			return &BrokerResult{ Error: fmt.Errorf("Agent is not ready to serve"), Retry: true }
+		}
+		if msg.Header.Get("X-Fail") != "" {
+			return &BrokerResult{ Stderr: []byte("oops"), Error: fmt.Errorf("exit status 1") }
+		}
+		assert.Equal(t, ORIGIN_KIND_NATS, msg.Origin.Kind)
+		assert.Equal(t, "orders.create", msg.Origin.Subject)
+		return &BrokerResult{ State: &invokers.ExecutionState{}, Stdout: append([]byte("created: "), msg.Body...) }
+	})
+	assert.Nil(t, err)
+	defer broker.Stop()
+
+	client, err := nats.Connect(url)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	dead, err := client.SubscribeSync("orders.failed")
+	assert.Nil(t, err)
+	client.Flush()
+
+	t.Run("stdout is published to the reply subject", func(t *testing.T) {
+		reply, err := client.Request("orders.create", []byte("#1"), 2 * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "created: #1", string(reply.Data))
+		assert.Equal(t, "", reply.Header.Get(RES_HEADER_ERROR_MESSAGE))
+	})
+
+	t.Run("failures are published to the dead-letter subject", func(t *testing.T) {
+		msg := nats.NewMsg("orders.create")
+		msg.Header.Set("X-Fail", "yes")
+		msg.Data = []byte("#2")
+		msg.Reply = nats.NewInbox()
+		replies, _ := client.SubscribeSync(msg.Reply)
+		assert.Nil(t, client.PublishMsg(msg))
+
+		reply, err := replies.NextMsg(2 * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "oops", string(reply.Data))
+		assert.Equal(t, "exit status 1", reply.Header.Get(RES_HEADER_ERROR_MESSAGE))
+
+		failed, err := dead.NextMsg(2 * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "#2", string(failed.Data))
+		assert.Equal(t, "orders.create", failed.Header.Get(BROKER_HEADER_ORIGIN_SUBJECT))
+		assert.Equal(t, "exit status 1", failed.Header.Get(RES_HEADER_ERROR_MESSAGE))
+	})
+
+	t.Run("jetstream messages are acknowledged without the output", func(t *testing.T) {
+		acks, _ := client.SubscribeSync(NATS_JS_ACK_PREFIX + ">")
+		client.Flush()
+
+		msg := nats.NewMsg("orders.create")
+		msg.Data = []byte("#3")
+		msg.Reply = NATS_JS_ACK_PREFIX + "orders.consumer.1.3.3.0.0"
+		assert.Nil(t, client.PublishMsg(msg))
+		ack, err := acks.NextMsg(2 * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "+ACK", string(ack.Data))
+
+		msg = nats.NewMsg("orders.create")
+		msg.Header.Set("X-Fail", "yes")
+		msg.Data = []byte("#4")
+		msg.Reply = NATS_JS_ACK_PREFIX + "orders.consumer.1.4.4.0.0"
+		assert.Nil(t, client.PublishMsg(msg))
+		ack, err = acks.NextMsg(2 * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "+TERM", string(ack.Data))
+
+		failed, err := dead.NextMsg(2 * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "#4", string(failed.Data))
+
+		_, err = acks.NextMsg(200 * time.Millisecond)
+		assert.Equal(t, nats.ErrTimeout, err)
+		acks.Unsubscribe()
+	})
+
+	t.Run("deferred messages without a reply subject are dead-lettered", func(t *testing.T) {
+		msg := nats.NewMsg("orders.create")
+		msg.Header.Set("X-Locked", "yes")
+		msg.Data = []byte("#5")
+		assert.Nil(t, client.PublishMsg(msg))
+
+		deferred, err := dead.NextMsg(2 * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "#5", string(deferred.Data))
+		assert.Equal(t, "Agent is not ready to serve", deferred.Header.Get(RES_HEADER_ERROR_MESSAGE))
+	})
+
+	t.Run("deferred messages are redelivered or returned to the requester", func(t *testing.T) {
+		msg := nats.NewMsg("orders.create")
+		msg.Header.Set("X-Locked", "yes")
+		msg.Reply = nats.NewInbox()
+		replies, _ := client.SubscribeSync(msg.Reply)
+		assert.Nil(t, client.PublishMsg(msg))
+
+		reply, err := replies.NextMsg(2 * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "Agent is not ready to serve", reply.Header.Get(RES_HEADER_ERROR_MESSAGE))
+
+		// a JetStream message is negatively acknowledged, so that it is redelivered
+		acks, _ := client.SubscribeSync(NATS_JS_ACK_PREFIX + ">")
+		client.Flush()
+		msg = nats.NewMsg("orders.create")
+		msg.Header.Set("X-Locked", "yes")
+		msg.Reply = NATS_JS_ACK_PREFIX + "orders.consumer.1.1.1.0.0"
+		assert.Nil(t, client.PublishMsg(msg))
+		ack, err := acks.NextMsg(2 * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, "-NAK", string(ack.Data))
+
+		_, err = dead.NextMsg(200 * time.Millisecond)
+		assert.Equal(t, nats.ErrTimeout, err)
+	})
+}
+
+type NatsBrokerOptionsTest struct {
+	Url string
+	Subject string
+	QueueGroup string
+	DeadLetterSubject string
+}
+
+func (o *NatsBrokerOptionsTest) GetUrl() string {
+	return o.Url
+}
+
+func (o *NatsBrokerOptionsTest) GetSubject() string {
+	return o.Subject
+}
+
+func (o *NatsBrokerOptionsTest) GetQueueGroup() string {
+	return o.QueueGroup
+}
+
+func (o *NatsBrokerOptionsTest) GetDeadLetterSubject() string {
+	return o.DeadLetterSubject
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyProtocolListener accepts the connections which are prefixed with the HAProxy PROXY
+// protocol header (v1 or v2). The header is read lazily (on the first Read or RemoteAddr call)
+// so that a slow client never blocks Accept. The connections without the header are served as is.
+type ProxyProtocolListener struct {
+	net.Listener
+	resolver *ClientIPResolver
+	headerTimeout time.Duration
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+	resolver *ClientIPResolver
+	headerTimeout time.Duration
+	once sync.Once
+	remoteAddr net.Addr
+	err error
+}
+
+// NewProxyProtocolListener() wraps a listener, the headers are accepted only from the trusted
+// proxies of the resolver (from no peer if no proxy is trusted).
+func NewProxyProtocolListener(l net.Listener, resolver *ClientIPResolver) *ProxyProtocolListener {
+	return &ProxyProtocolListener{
+		Listener: l,
+		resolver: resolver,
+		headerTimeout: DEFAULT_PROXY_HEADER_TIMEOUT,
+	}
+}
+
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{
+		Conn: conn,
+		reader: bufio.NewReader(conn),
+		resolver: l.resolver,
+		headerTimeout: l.headerTimeout,
+	}, nil
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	if c.headerTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	addr, found, err := ReadProxyHeader(c.reader)
+	if err != nil {
+		c.err = err
+		c.Conn.Close()
+		return
+	}
+	if !found {
+		return
+	}
+	if c.resolver == nil || !c.resolver.IsTrusted(parseRemoteIP(c.Conn.RemoteAddr().String())) {
+		c.err = fmt.Errorf("PROXY header from an untrusted peer [%s]", c.Conn.RemoteAddr())
+		c.Conn.Close()
+		return
+	}
+	c.remoteAddr = addr
+}
+
+// ReadProxyHeader() consumes the PROXY protocol header if the stream starts with its signature.
+// The returned address is nil for the LOCAL command (v2) or the UNKNOWN protocol (v1).
+func ReadProxyHeader(r *bufio.Reader) (net.Addr, bool, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, false, nil
+	}
+	switch first[0] {
+	case proxyV1Signature[0]:
+		sig, err := r.Peek(len(proxyV1Signature))
+		if err == nil && bytes.Equal(sig, proxyV1Signature) {
+			addr, err := readProxyHeaderV1(r)
+			return addr, true, err
+		}
+	case proxyV2Signature[0]:
+		sig, err := r.Peek(len(proxyV2Signature))
+		if err == nil && bytes.Equal(sig, proxyV2Signature) {
+			addr, err := readProxyHeaderV2(r)
+			return addr, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// the v1 header is at most 107 bytes, including the CRLF
+	line := make([]byte, 0, 107)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= 107 {
+			return nil, fmt.Errorf("PROXY v1 header is too long")
+		}
+	}
+	fields := strings.Fields(strings.TrimRight(string(line), "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("PROXY v1 header is malformed")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("PROXY v1 header has an invalid source address")
+	}
+	return &net.TCPAddr{ IP: ip, Port: port }, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if verCmd >> 4 != 2 {
+		return nil, fmt.Errorf("PROXY v2 header has an unsupported version")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if verCmd & 0x0F == 0x00 {
+		// LOCAL command: the connection is established by the proxy itself
+		return nil, nil
+	}
+	switch family {
+	case 0x11, 0x12: // TCP4, UDP4
+		if length < 12 {
+			return nil, fmt.Errorf("PROXY v2 header is truncated")
+		}
+		return &net.TCPAddr{ IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10])) }, nil
+	case 0x21, 0x22: // TCP6, UDP6
+		if length < 36 {
+			return nil, fmt.Errorf("PROXY v2 header is truncated")
+		}
+		return &net.TCPAddr{ IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34])) }, nil
+	}
+	return nil, nil
+}
+
+var proxyV1Signature = []byte("PROXY ")
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const DEFAULT_PROXY_HEADER_TIMEOUT time.Duration = 5 * time.Second
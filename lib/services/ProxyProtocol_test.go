@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	t.Run("v1 header", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("PROXY TCP4 198.51.100.1 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+		addr, found, err := ReadProxyHeader(r)
+		assert.Nil(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "198.51.100.1:56324", addr.String())
+		rest, _ := ioutil.ReadAll(r)
+		assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+	})
+	t.Run("v1 header with unknown protocol", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\nGET /"))
+		addr, found, err := ReadProxyHeader(r)
+		assert.Nil(t, err)
+		assert.True(t, found)
+		assert.Nil(t, addr)
+	})
+	t.Run("v2 header", func(t *testing.T) {
+		var b bytes.Buffer
+		b.Write(proxyV2Signature)
+		b.Write([]byte{ 0x21, 0x11 })
+		binary.Write(&b, binary.BigEndian, uint16(12))
+		b.Write(net.ParseIP("198.51.100.1").To4())
+		b.Write(net.ParseIP("10.0.0.1").To4())
+		binary.Write(&b, binary.BigEndian, uint16(56324))
+		binary.Write(&b, binary.BigEndian, uint16(443))
+		b.WriteString("GET /")
+		r := bufio.NewReader(&b)
+		addr, found, err := ReadProxyHeader(r)
+		assert.Nil(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "198.51.100.1:56324", addr.String())
+		rest, _ := ioutil.ReadAll(r)
+		assert.Equal(t, "GET /", string(rest))
+	})
+	t.Run("plain connection", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n"))
+		_, found, err := ReadProxyHeader(r)
+		assert.Nil(t, err)
+		assert.False(t, found)
+		rest, _ := ioutil.ReadAll(r)
+		assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+	})
+	t.Run("malformed v1 header", func(t *testing.T) {
+		r := bufio.NewReader(strings.NewReader("PROXY TCP4 nonsense\r\n"))
+		_, _, err := ReadProxyHeader(r)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer inner.Close()
+	resolver, _ := NewClientIPResolver([]string{ "127.0.0.1" })
+	l := NewProxyProtocolListener(inner, resolver)
+
+	go func() {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err == nil {
+			conn.Write([]byte("PROXY TCP4 198.51.100.1 10.0.0.1 56324 443\r\nhello"))
+			conn.Close()
+		}
+	}()
+
+	conn, err := l.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, "198.51.100.1:56324", conn.RemoteAddr().String())
+	data, _ := ioutil.ReadAll(conn)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestProxyProtocolListener_Untrusted(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer inner.Close()
+	resolver, _ := NewClientIPResolver([]string{})
+	l := NewProxyProtocolListener(inner, resolver)
+
+	go func() {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err == nil {
+			conn.Write([]byte("PROXY TCP4 127.0.0.1 10.0.0.1 56324 443\r\nhello"))
+			conn.Close()
+		}
+	}()
+
+	conn, err := l.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = ioutil.ReadAll(conn)
+	assert.NotNil(t, err)
+}
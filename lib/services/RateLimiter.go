@@ -0,0 +1,215 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the request rate with token buckets (rate requests per second, up to
+// burst requests at once). The buckets are keyed by the client IP, by an API key header or
+// by the resource.
+type RateLimiter struct {
+	name string
+	rate float64
+	burst int
+	by string
+	header string
+	lock sync.Mutex
+	buckets map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type RateLimitOptions interface {
+	GetRate() float64
+	GetBurst() int
+	GetBy() string
+	GetHeader() string
+}
+
+type RateLimitResult struct {
+	Allowed bool
+	Limit int
+	Remaining int
+	Reset time.Duration
+}
+
+type tokenBucket struct {
+	tokens float64
+	updatedAt time.Time
+}
+
+func NewRateLimiter(name string, opts RateLimitOptions) (*RateLimiter, error) {
+	if opts == nil || opts.GetRate() <= 0 {
+		return nil, fmt.Errorf("Rate limit [%s] must declare a positive rate", name)
+	}
+	l := new(RateLimiter)
+	l.name = name
+	l.rate = opts.GetRate()
+	l.burst = opts.GetBurst()
+	if l.burst <= 0 {
+		l.burst = int(math.Max(1, math.Ceil(l.rate)))
+	}
+	l.by = opts.GetBy()
+	switch l.by {
+	case "":
+		l.by = RATE_LIMIT_BY_USERIP
+	case RATE_LIMIT_BY_USERIP, RATE_LIMIT_BY_HEADER, RATE_LIMIT_BY_RESOURCE:
+	default:
+		return nil, fmt.Errorf("Rate limit [%s] has an unsupported key [%s]", name, l.by)
+	}
+	l.header = opts.GetHeader()
+	if len(l.header) == 0 {
+		l.header = DEFAULT_RATE_LIMIT_HEADER
+	}
+	l.buckets = make(map[string]*tokenBucket)
+	l.lastSweep = time.Now()
+	return l, nil
+}
+
+// Key() determines the bucket of a request, the requests without the API key header are
+// keyed by the client IP.
+func (l *RateLimiter) Key(r *http.Request, resourceName string) string {
+	switch l.by {
+	case RATE_LIMIT_BY_RESOURCE:
+		return "resource:" + normalizeResourceName(resourceName)
+	case RATE_LIMIT_BY_HEADER:
+		if key := r.Header.Get(l.header); len(key) > 0 {
+			return "key:" + key
+		}
+	}
+	if userip, err := extractUserIP(r); err == nil && userip != nil {
+		return "ip:" + userip.String()
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// Take() consumes a token from the bucket of the key.
+func (l *RateLimiter) Take(key string) *RateLimitResult {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{ tokens: float64(l.burst), updatedAt: now }
+		l.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(l.burst), b.tokens + now.Sub(b.updatedAt).Seconds() * l.rate)
+		b.updatedAt = now
+	}
+	result := &RateLimitResult{ Limit: l.burst }
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	if result.Allowed {
+		// the time to refill the bucket completely
+		result.Reset = time.Duration((float64(l.burst) - b.tokens) / l.rate * float64(time.Second))
+	} else {
+		// the time to get the next token
+		result.Reset = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	return result
+}
+
+// Refund() gives back a token taken from the bucket of the key.
+func (l *RateLimiter) Refund(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = math.Min(float64(l.burst), b.tokens + 1)
+	}
+}
+
+// sweep() drops the buckets that have been refilled completely, at most once a minute.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens + now.Sub(b.updatedAt).Seconds() * l.rate >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ReqRateLimiter holds the global rate limit and the rate limits of the resources.
+type ReqRateLimiter struct {
+	limiter *RateLimiter
+	limiters map[string]*RateLimiter
+}
+
+func NewReqRateLimiter(opts RateLimitOptions, enabled bool) (*ReqRateLimiter, error) {
+	rl := new(ReqRateLimiter)
+	rl.limiters = make(map[string]*RateLimiter)
+	if enabled {
+		limiter, err := NewRateLimiter(GLOBAL_LIMITER, opts)
+		if err != nil {
+			return nil, err
+		}
+		rl.limiter = limiter
+	}
+	return rl, nil
+}
+
+func (rl *ReqRateLimiter) Register(opts RateLimitOptions, resourceName string) error {
+	name := normalizeResourceName(resourceName)
+	limiter, err := NewRateLimiter(name, opts)
+	if err != nil {
+		return err
+	}
+	rl.limiters[name] = limiter
+	return nil
+}
+
+// Allow() checks the global and the resource rate limits, it returns the result of the
+// rejecting limit, or the most restrictive one if the request is allowed. The tokens taken
+// from the other limits are refunded when the request is rejected.
+func (rl *ReqRateLimiter) Allow(r *http.Request, resourceName string) *RateLimitResult {
+	var result *RateLimitResult
+	var taken []func()
+	for _, limiter := range []*RateLimiter{ rl.limiter, rl.limiters[normalizeResourceName(resourceName)] } {
+		if limiter == nil {
+			continue
+		}
+		key := limiter.Key(r, resourceName)
+		current := limiter.Take(key)
+		if !current.Allowed {
+			for _, refund := range taken {
+				refund()
+			}
+			return current
+		}
+		taken = append(taken, refundOf(limiter, key))
+		if result == nil || current.Remaining < result.Remaining {
+			result = current
+		}
+	}
+	return result
+}
+
+func refundOf(limiter *RateLimiter, key string) func() {
+	return func() {
+		limiter.Refund(key)
+	}
+}
+
+// WriteHeaders() sets the RateLimit-* headers of the response.
+func (r *RateLimitResult) WriteHeaders(w http.ResponseWriter) {
+	w.Header().Set(RES_HEADER_RATE_LIMIT_LIMIT, fmt.Sprintf("%d", r.Limit))
+	w.Header().Set(RES_HEADER_RATE_LIMIT_REMAINING, fmt.Sprintf("%d", r.Remaining))
+	w.Header().Set(RES_HEADER_RATE_LIMIT_RESET, fmt.Sprintf("%d", int64(math.Ceil(r.Reset.Seconds()))))
+}
+
+const RATE_LIMIT_BY_USERIP string = "userip"
+const RATE_LIMIT_BY_HEADER string = "header"
+const RATE_LIMIT_BY_RESOURCE string = "resource"
+const DEFAULT_RATE_LIMIT_HEADER string = "X-Api-Key"
+const RES_HEADER_RATE_LIMIT_LIMIT string = "RateLimit-Limit"
+const RES_HEADER_RATE_LIMIT_REMAINING string = "RateLimit-Remaining"
+const RES_HEADER_RATE_LIMIT_RESET string = "RateLimit-Reset"
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Take(t *testing.T) {
+	l, _ := NewRateLimiter("test", &RateLimitOptionsTest{ Rate: 20, Burst: 2 })
+	r1 := l.Take("a")
+	assert.True(t, r1.Allowed)
+	assert.Equal(t, 2, r1.Limit)
+	assert.Equal(t, 1, r1.Remaining)
+	assert.True(t, l.Take("a").Allowed)
+	r3 := l.Take("a")
+	assert.False(t, r3.Allowed)
+	assert.Equal(t, 0, r3.Remaining)
+	assert.True(t, r3.Reset > 0 && r3.Reset <= 50 * time.Millisecond)
+	// the buckets are separated by keys
+	assert.True(t, l.Take("b").Allowed)
+	// the bucket is refilled by the rate
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, l.Take("a").Allowed)
+}
+
+func TestRateLimiter_Key(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/reports", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+
+	byIP, _ := NewRateLimiter("test", &RateLimitOptionsTest{ Rate: 1 })
+	assert.Equal(t, "ip:10.0.0.1", byIP.Key(r, "reports"))
+
+	byHeader, _ := NewRateLimiter("test", &RateLimitOptionsTest{ Rate: 1, By: RATE_LIMIT_BY_HEADER })
+	assert.Equal(t, "ip:10.0.0.1", byHeader.Key(r, "reports"))
+	r.Header.Set("X-Api-Key", "secret")
+	assert.Equal(t, "key:secret", byHeader.Key(r, "reports"))
+
+	byResource, _ := NewRateLimiter("test", &RateLimitOptionsTest{ Rate: 1, By: RATE_LIMIT_BY_RESOURCE })
+	assert.Equal(t, "resource:reports", byResource.Key(r, "reports"))
+
+	_, err := NewRateLimiter("test", &RateLimitOptionsTest{ Rate: 1, By: "unknown" })
+	assert.NotNil(t, err)
+	_, err = NewRateLimiter("test", &RateLimitOptionsTest{})
+	assert.NotNil(t, err)
+}
+
+func TestReqRateLimiter_Allow(t *testing.T) {
+	rl, _ := NewReqRateLimiter(&RateLimitOptionsTest{ Rate: 1, Burst: 3 }, true)
+	rl.Register(&RateLimitOptionsTest{ Rate: 1, Burst: 1, By: RATE_LIMIT_BY_RESOURCE }, "reports")
+	r, _ := http.NewRequest("GET", "/reports", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+
+	result := rl.Allow(r, "reports")
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 1, result.Limit)
+	assert.False(t, rl.Allow(r, "reports").Allowed)
+	// the global limit is still available for the other resources, the token of the
+	// rejected request has been refunded
+	result = rl.Allow(r, "products")
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 3, result.Limit)
+	assert.Equal(t, 1, result.Remaining)
+
+	disabled, _ := NewReqRateLimiter(nil, false)
+	assert.Nil(t, disabled.Allow(r, "products"))
+}
+
+type RateLimitOptionsTest struct {
+	Rate float64
+	Burst int
+	By string
+	Header string
+}
+
+func (o *RateLimitOptionsTest) GetRate() float64 {
+	return o.Rate
+}
+
+func (o *RateLimitOptionsTest) GetBurst() int {
+	return o.Burst
+}
+
+func (o *RateLimitOptionsTest) GetBy() string {
+	return o.By
+}
+
+func (o *RateLimitOptionsTest) GetHeader() string {
+	return o.Header
+}
@@ -7,23 +7,31 @@ import (
 	"net"
 	"net/http"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 	loq "github.com/opwire/opwire-agent/lib/logging"
-	"golang.org/x/sync/semaphore"
 	"golang.org/x/sync/singleflight"
 )
 
 type ReqRestrictor struct {
-	context context.Context
-	semaphore *semaphore.Weighted
+	limiter *ConcurrencyLimiter
+	limiters map[string]*ConcurrencyLimiter
+	limitersLock sync.RWMutex
 	flightGroup *singleflight.Group
 	flightPattern *SingleFlightPattern
+	flights map[string]*flightFilter
+	metrics *AgentMetrics
+	tracer *Tracer
 	logger *loq.Logger
 }
 
 type ReqRestrictorOptions interface {
 	ConcurrentLimitEnabled() bool
 	ConcurrentLimitTotal() int
+	ConcurrentLimitMaxQueue() int
+	ConcurrentLimitMaxWait() (time.Duration, error)
 	SingleFlightEnabled() bool
 	SingleFlightReqIdName() string
 	SingleFlightByMethod() bool
@@ -34,6 +42,23 @@ type ReqRestrictorOptions interface {
 	SingleFlightByUserIP() bool
 }
 
+type SingleFlightOptions interface {
+	GetEnabled() bool
+	GetReqIdName() string
+	GetByMethod() bool
+	GetByPath() bool
+	GetByHeaders() []string
+	GetByQueries() []string
+	GetByBody() bool
+	GetByUserIP() bool
+}
+
+// flightFilter is the single-flight of a resource (or a method), a nil group disables it.
+type flightFilter struct {
+	group *singleflight.Group
+	pattern *SingleFlightPattern
+}
+
 type SingleFlightPattern struct {
 	ReqIdName string
 	HasMethod bool
@@ -49,20 +74,28 @@ func NewReqRestrictor(logger *loq.Logger, opts ReqRestrictorOptions) (*ReqRestri
 
 	rr.logger = logger
 
-	rr.context = context.TODO()
+	rr.limiters = make(map[string]*ConcurrencyLimiter)
+	rr.flights = make(map[string]*flightFilter)
 
-	// create the semaphore
-	limitEnabled := false
-	limitTotal := 0
-	if opts != nil {
-		limitEnabled = opts.ConcurrentLimitEnabled()
-		limitTotal = opts.ConcurrentLimitTotal()
-	}
-	if limitTotal == 0 {
-		limitTotal = runtime.GOMAXPROCS(0)
-	}
-	if limitEnabled && limitTotal > 0 {
-		rr.semaphore = semaphore.NewWeighted(int64(limitTotal))
+	// create the global limiter
+	if opts != nil && opts.ConcurrentLimitEnabled() {
+		limitTotal := opts.ConcurrentLimitTotal()
+		if limitTotal == 0 {
+			limitTotal = runtime.GOMAXPROCS(0)
+		}
+		maxWait, err := opts.ConcurrentLimitMaxWait()
+		if err != nil {
+			return nil, err
+		}
+		limiter, err := NewConcurrencyLimiter(GLOBAL_LIMITER, &globalLimitOptions{
+			total: limitTotal,
+			maxQueue: opts.ConcurrentLimitMaxQueue(),
+			maxWait: maxWait,
+		})
+		if err != nil {
+			return nil, err
+		}
+		rr.limiter = limiter
 	}
 
 	// create the singleflight
@@ -90,56 +123,223 @@ func NewReqRestrictor(logger *loq.Logger, opts ReqRestrictorOptions) (*ReqRestri
 	return rr, nil
 }
 
-func (rr *ReqRestrictor) HasSemaphore() bool {
-	return rr.semaphore != nil
+// SetMetrics() enables the semaphore wait & single-flight metrics.
+func (rr *ReqRestrictor) SetMetrics(metrics *AgentMetrics) {
+	rr.metrics = metrics
+}
+
+// SetTracer() enables the spans of the semaphore & single-flight waits.
+func (rr *ReqRestrictor) SetTracer(tracer *Tracer) {
+	rr.tracer = tracer
 }
 
-func (rr *ReqRestrictor) Acquire(weight int) error {
-	if rr.semaphore == nil {
+// RegisterLimiter() creates a limiter for a resource, or for a method of the resource.
+func (rr *ReqRestrictor) RegisterLimiter(opts ConcurrentLimitOptions, resourceName string, methodName string) error {
+	if opts == nil || !opts.GetEnabled() {
 		return nil
 	}
-	return rr.semaphore.Acquire(rr.context, int64(weight))
+	name := limiterName(resourceName, methodName)
+	limiter, err := NewConcurrencyLimiter(name, opts)
+	if err != nil {
+		return err
+	}
+	rr.limitersLock.Lock()
+	defer rr.limitersLock.Unlock()
+	rr.limiters[name] = limiter
+	return nil
+}
+
+func (rr *ReqRestrictor) HasLimiters() bool {
+	rr.limitersLock.RLock()
+	defer rr.limitersLock.RUnlock()
+	return rr.limiter != nil || len(rr.limiters) > 0
+}
+
+// Acquire() takes the permits of the method, the resource and the global limiters (in this
+// order, so that a request waiting for a slow resource does not hold a global permit). The
+// waiting is bound to the context, the returned function releases all of the permits.
+func (rr *ReqRestrictor) Acquire(ctx context.Context, resourceName string, methodName string) (func(), error) {
+	rr.limitersLock.RLock()
+	chain := make([]*ConcurrencyLimiter, 0, 3)
+	for _, name := range []string{ limiterName(resourceName, methodName), limiterName(resourceName, "") } {
+		if limiter, ok := rr.limiters[name]; ok {
+			chain = append(chain, limiter)
+		}
+	}
+	if rr.limiter != nil {
+		chain = append(chain, rr.limiter)
+	}
+	rr.limitersLock.RUnlock()
+
+	startTime := time.Now()
+	var span *Span
+	if len(chain) > 0 {
+		ctx, span = rr.tracer.StartSpan(ctx, "semaphore wait", SPAN_KIND_INTERNAL)
+		span.SetAttribute("opwire.resource", normalizeResourceName(resourceName))
+		span.SetAttribute("opwire.method", methodName)
+	}
+	defer func() {
+		rr.metrics.ObserveSemaphoreWait(resourceName, methodName, time.Since(startTime))
+		span.End()
+	}()
+
+	acquired := make([]*ConcurrencyLimiter, 0, len(chain))
+	release := func() {
+		for i := len(acquired) - 1; i >= 0; i-- {
+			acquired[i].Release()
+		}
+	}
+	for _, limiter := range chain {
+		if err := limiter.Acquire(ctx); err != nil {
+			span.SetError(err)
+			release()
+			return nil, err
+		}
+		acquired = append(acquired, limiter)
+	}
+	return release, nil
+}
+
+func (rr *ReqRestrictor) LimiterStats() []*ConcurrencyStats {
+	rr.limitersLock.RLock()
+	defer rr.limitersLock.RUnlock()
+	stats := make([]*ConcurrencyStats, 0, len(rr.limiters) + 1)
+	if rr.limiter != nil {
+		stats = append(stats, rr.limiter.Stats())
+	}
+	names := make([]string, 0, len(rr.limiters))
+	for name := range rr.limiters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stats = append(stats, rr.limiters[name].Stats())
+	}
+	return stats
 }
 
-func (rr *ReqRestrictor) Release(weight int) {
-	if rr.semaphore == nil {
+func limiterName(resourceName string, methodName string) string {
+	resourceName = normalizeResourceName(resourceName)
+	if len(methodName) == 0 {
+		return resourceName
+	}
+	return resourceName + "/" + methodName
+}
+
+type globalLimitOptions struct {
+	total int
+	maxQueue int
+	maxWait time.Duration
+}
+
+func (o *globalLimitOptions) GetEnabled() bool {
+	return true
+}
+
+func (o *globalLimitOptions) GetTotal() int {
+	return o.total
+}
+
+func (o *globalLimitOptions) GetMaxQueue() int {
+	return o.maxQueue
+}
+
+func (o *globalLimitOptions) GetMaxWait() (time.Duration, error) {
+	return o.maxWait, nil
+}
+
+// RegisterSingleFlight() overrides the global single-flight for a resource, or for a method of
+// the resource. Each override has its own group, so that the keys of resources never collide.
+func (rr *ReqRestrictor) RegisterSingleFlight(opts SingleFlightOptions, resourceName string, methodName string) {
+	if opts == nil {
 		return
 	}
-	rr.semaphore.Release(int64(weight))
+	f := &flightFilter{}
+	if opts.GetEnabled() {
+		f.group = new(singleflight.Group)
+		f.pattern = &SingleFlightPattern{
+			ReqIdName: opts.GetReqIdName(),
+			HasMethod: opts.GetByMethod(),
+			HasPath: opts.GetByPath(),
+			HasHeaders: opts.GetByHeaders(),
+			HasQueries: opts.GetByQueries(),
+			HasBody: opts.GetByBody(),
+			HasUserIP: opts.GetByUserIP(),
+		}
+	}
+	rr.flights[limiterName(resourceName, methodName)] = f
 }
 
-func (rr *ReqRestrictor) HasSingleFlight() bool {
-	return rr.flightGroup != nil && rr.flightPattern != nil
+// findFlight() resolves the single-flight of a request: the method override, then the
+// resource override, then the global one.
+func (rr *ReqRestrictor) findFlight(resourceName string, methodName string) (*singleflight.Group, *SingleFlightPattern) {
+	for _, name := range []string{ limiterName(resourceName, methodName), limiterName(resourceName, "") } {
+		if f, ok := rr.flights[name]; ok {
+			return f.group, f.pattern
+		}
+	}
+	return rr.flightGroup, rr.flightPattern
+}
+
+func (rr *ReqRestrictor) HasSingleFlight(resourceName string, methodName string) bool {
+	group, pattern := rr.findFlight(resourceName, methodName)
+	return group != nil && pattern != nil
 }
 
 func (rr *ReqRestrictor) Filter(groupKey string, action func() (interface{}, error)) (interface{}, error, bool) {
-	if !rr.HasSingleFlight() {
+	if rr.flightGroup == nil {
 		out, err := action()
 		return out, err, false
 	}
 	return rr.flightGroup.Do(groupKey, action)
 }
 
-func (rr *ReqRestrictor) FilterByDigest(r *http.Request, action func() (interface{}, error)) (interface{}, error, bool) {
+func (rr *ReqRestrictor) HasSingleFlightBody(resourceName string, methodName string) bool {
+	group, pattern := rr.findFlight(resourceName, methodName)
+	return group != nil && pattern != nil && pattern.HasBody
+}
+
+// FilterByDigest() groups the duplicated requests, the body is the buffered request body
+// and it is used only if the pattern has by-body enabled.
+func (rr *ReqRestrictor) FilterByDigest(r *http.Request, resourceName string, body []byte, action func() (interface{}, error)) (interface{}, error, bool) {
 	var (
 		out interface{}
 		err error
 		shared bool
 	)
-	if !rr.HasSingleFlight() {
+	methodName := strings.ToUpper(r.Method)
+	group, p := rr.findFlight(resourceName, methodName)
+	if group == nil || p == nil {
 		out, err = action()
 		return out, err, false
 	}
-	p := rr.flightPattern
+	// the leader of a group runs the action, the others receive its (shared) result
+	executed := false
+	leaderAction := action
+	action = func() (interface{}, error) {
+		executed = true
+		return leaderAction()
+	}
+	_, span := rr.tracer.StartSpan(r.Context(), "single-flight wait", SPAN_KIND_INTERNAL)
+	span.SetAttribute("opwire.resource", normalizeResourceName(resourceName))
+	span.SetAttribute("opwire.method", methodName)
+	defer func() {
+		if shared && !executed {
+			rr.metrics.ObserveSingleFlightShared(resourceName, methodName)
+		}
+		span.SetAttribute("opwire.singleflight.shared", shared)
+		span.SetAttribute("opwire.singleflight.leader", executed)
+		span.End()
+	}()
 	if len(p.ReqIdName) > 0 {
 		reqId := r.Header.Get(p.ReqIdName)
 		if len(reqId) > 0 {
-			out, err, shared = rr.flightGroup.Do(reqId, action)
+			out, err, shared = group.Do(reqId, action)
 			return rr.LogResult(reqId, out, err, shared)
 		}
 	}
-	groupKey := rr.Digest(r)
-	out, err, shared = rr.flightGroup.Do(groupKey, action)
+	groupKey := DigestRequest(r, p, body)
+	out, err, shared = group.Do(groupKey, action)
 	return rr.LogResult(groupKey, out, err, shared)
 }
 
@@ -148,9 +348,23 @@ func (rr *ReqRestrictor) LogResult(groupKey string, state interface{}, err error
 	return state, err, shared
 }
 
-func (rr *ReqRestrictor) Digest(r *http.Request) string {
+func (rr *ReqRestrictor) Digest(r *http.Request, body []byte) string {
+	return DigestRequest(r, rr.flightPattern, body)
+}
+
+// DigestRequest() builds the single-flight key of a request from the dimensions of the pattern.
+func DigestRequest(r *http.Request, p *SingleFlightPattern, body []byte) string {
+	return digestRequest(r, p, body, false)
+}
+
+// DigestCacheKey() builds the cache key of a request, all the values of the headers & the
+// queries are hashed along with their names, so that the distinct requests never collide.
+func DigestCacheKey(r *http.Request, p *SingleFlightPattern, body []byte) string {
+	return digestRequest(r, p, body, true)
+}
+
+func digestRequest(r *http.Request, p *SingleFlightPattern, body []byte, exact bool) string {
 	o := []string{}
-	p := rr.flightPattern
 	if p == nil {
 		p = &SingleFlightPattern{}
 	}
@@ -165,8 +379,11 @@ func (rr *ReqRestrictor) Digest(r *http.Request) string {
 	if p.HasHeaders != nil {
 		headers := r.Header
 		for _, key := range p.HasHeaders {
-			val := headers.Get(key)
-			if len(val) > 0 {
+			if exact {
+				if vals := headers[http.CanonicalHeaderKey(key)]; len(vals) > 0 {
+					fmt.Fprintf(h, "%s=%q\n", key, vals)
+				}
+			} else if val := headers.Get(key); len(val) > 0 {
 				h.Write([]byte(val))
 			}
 		}
@@ -174,12 +391,18 @@ func (rr *ReqRestrictor) Digest(r *http.Request) string {
 	if p.HasQueries != nil {
 		queries := r.URL.Query()
 		for _, key := range p.HasQueries {
-			val := queries.Get(key)
-			if len(val) > 0 {
+			if exact {
+				if vals := queries[key]; len(vals) > 0 {
+					fmt.Fprintf(h, "%s=%q\n", key, vals)
+				}
+			} else if val := queries.Get(key); len(val) > 0 {
 				h.Write([]byte(val))
 			}
 		}
 	}
+	if p.HasBody && body != nil {
+		h.Write(body)
+	}
 	s := h.Sum(nil)
 	o = append(o, fmt.Sprintf("%x", s))
 
@@ -190,11 +413,20 @@ func (rr *ReqRestrictor) Digest(r *http.Request) string {
 		}
 	}
 
+	// the outputs are never shared between the principals
+	if principal := extractPrincipal(r); principal != nil {
+		o = append(o, principal.Provider + ":" + principal.Name)
+	}
+
 	return strings.Join(o, "|")
 }
 
-// extractUserIP() extracts the user IP address from req, if present.
+// extractUserIP() extracts the user IP address from req, if present. The client IP which is
+// resolved from the trusted proxies takes precedence over the remote address.
 func extractUserIP(req *http.Request) (net.IP, error) {
+	if ip, ok := req.Context().Value(clientIPContextKey{}).(net.IP); ok && ip != nil {
+		return ip, nil
+	}
 	ip, _, err := net.SplitHostPort(req.RemoteAddr)
 	if err != nil {
 		return nil, fmt.Errorf("userip: %q is not IP:port", req.RemoteAddr)
@@ -205,3 +437,5 @@ func extractUserIP(req *http.Request) (net.IP, error) {
 	}
 	return userIP, nil
 }
+
+const GLOBAL_LIMITER string = ":global:"
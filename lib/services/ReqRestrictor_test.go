@@ -1 +1,209 @@
 package services
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+func TestReqRestrictor_FilterByDigest(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+
+	t.Run("followers receive the output of the leader", func(t *testing.T) {
+		rr, _ := NewReqRestrictor(logger, &ReqRestrictorOptionsTest{ Enabled: true, ByMethod: true, ByPath: true })
+		metrics := NewAgentMetrics()
+		rr.SetMetrics(metrics)
+		var count int32
+		release := make(chan struct{})
+		action := func() (interface{}, error) {
+			atomic.AddInt32(&count, 1)
+			<-release
+			return &commandResult{ stdout: []byte("shared output"), stderr: []byte("warning") }, nil
+		}
+		var wg sync.WaitGroup
+		results := make([]*commandResult, 5)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				r, _ := http.NewRequest("GET", "/reports", nil)
+				out, _, _ := rr.FilterByDigest(r, "reports", nil, action)
+				results[i] = out.(*commandResult)
+			}(i)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+		for _, result := range results {
+			assert.Equal(t, []byte("shared output"), result.stdout)
+			assert.Equal(t, []byte("warning"), result.stderr)
+		}
+		var text bytes.Buffer
+		metrics.WriteText(&text)
+		assert.Contains(t, text.String(), `opwire_singleflight_shared_total{resource="reports",method="GET"} 4`)
+	})
+
+	t.Run("requests with different bodies are not grouped when by-body is enabled", func(t *testing.T) {
+		rr, _ := NewReqRestrictor(logger, &ReqRestrictorOptionsTest{ Enabled: true, ByPath: true, ByBody: true })
+		assert.True(t, rr.HasSingleFlightBody("search", "POST"))
+		r, _ := http.NewRequest("POST", "/search", nil)
+		assert.Equal(t, rr.Digest(r, []byte("a")), rr.Digest(r, []byte("a")))
+		assert.NotEqual(t, rr.Digest(r, []byte("a")), rr.Digest(r, []byte("b")))
+	})
+
+	t.Run("body is ignored when by-body is disabled", func(t *testing.T) {
+		rr, _ := NewReqRestrictor(logger, &ReqRestrictorOptionsTest{ Enabled: true, ByPath: true })
+		assert.False(t, rr.HasSingleFlightBody("search", "POST"))
+		r, _ := http.NewRequest("POST", "/search", nil)
+		assert.Equal(t, rr.Digest(r, []byte("a")), rr.Digest(r, []byte("b")))
+	})
+
+	t.Run("single-flight key hashes the first values, cache key hashes all of them", func(t *testing.T) {
+		p := &SingleFlightPattern{ HasQueries: []string{ "type" } }
+		r1, _ := http.NewRequest("GET", "/search?type=car", nil)
+		r2, _ := http.NewRequest("GET", "/search?type=car&type=bike", nil)
+		assert.Equal(t, DigestRequest(r1, p, nil), DigestRequest(r2, p, nil))
+		assert.NotEqual(t, DigestCacheKey(r1, p, nil), DigestCacheKey(r2, p, nil))
+	})
+}
+
+func TestReqRestrictor_RegisterSingleFlight(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+	rr, _ := NewReqRestrictor(logger, &ReqRestrictorOptionsTest{ Enabled: true, ByMethod: true, ByPath: true })
+	rr.RegisterSingleFlight(&SingleFlightOptionsTest{ Enabled: false }, "orders", "POST")
+	rr.RegisterSingleFlight(&SingleFlightOptionsTest{ Enabled: true, ByBody: true }, "reports", "")
+	rr.RegisterSingleFlight(&SingleFlightOptionsTest{ Enabled: true }, "products", "")
+
+	assert.True(t, rr.HasSingleFlight("orders", "GET"))
+	assert.False(t, rr.HasSingleFlight("orders", "POST"))
+	assert.True(t, rr.HasSingleFlightBody("reports", "GET"))
+	assert.False(t, rr.HasSingleFlightBody("orders", "GET"))
+
+	// the overrides have separated groups
+	release := make(chan struct{})
+	var count int32
+	action := func() (interface{}, error) {
+		atomic.AddInt32(&count, 1)
+		<-release
+		return &commandResult{}, nil
+	}
+	var wg sync.WaitGroup
+	for _, resourceName := range []string{ "reports", "products", "reports", "products" } {
+		wg.Add(1)
+		go func(resourceName string) {
+			defer wg.Done()
+			r, _ := http.NewRequest("GET", "/", nil)
+			rr.FilterByDigest(r, resourceName, nil, action)
+		}(resourceName)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	// the disabled override runs every request
+	count = 0
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("POST", "/", nil)
+		rr.FilterByDigest(r, "orders", nil, action)
+	}
+	assert.Equal(t, int32(2), count)
+}
+
+type SingleFlightOptionsTest struct {
+	Enabled bool
+	ByBody bool
+}
+
+func (o *SingleFlightOptionsTest) GetEnabled() bool {
+	return o.Enabled
+}
+
+func (o *SingleFlightOptionsTest) GetReqIdName() string {
+	return ""
+}
+
+func (o *SingleFlightOptionsTest) GetByMethod() bool {
+	return false
+}
+
+func (o *SingleFlightOptionsTest) GetByPath() bool {
+	return false
+}
+
+func (o *SingleFlightOptionsTest) GetByHeaders() []string {
+	return nil
+}
+
+func (o *SingleFlightOptionsTest) GetByQueries() []string {
+	return nil
+}
+
+func (o *SingleFlightOptionsTest) GetByBody() bool {
+	return o.ByBody
+}
+
+func (o *SingleFlightOptionsTest) GetByUserIP() bool {
+	return false
+}
+
+type ReqRestrictorOptionsTest struct {
+	Enabled bool
+	ByMethod bool
+	ByPath bool
+	ByBody bool
+}
+
+func (o *ReqRestrictorOptionsTest) ConcurrentLimitEnabled() bool {
+	return false
+}
+
+func (o *ReqRestrictorOptionsTest) ConcurrentLimitTotal() int {
+	return 0
+}
+
+func (o *ReqRestrictorOptionsTest) ConcurrentLimitMaxQueue() int {
+	return -1
+}
+
+func (o *ReqRestrictorOptionsTest) ConcurrentLimitMaxWait() (time.Duration, error) {
+	return 0, nil
+}
+
+func (o *ReqRestrictorOptionsTest) SingleFlightEnabled() bool {
+	return o.Enabled
+}
+
+func (o *ReqRestrictorOptionsTest) SingleFlightReqIdName() string {
+	return ""
+}
+
+func (o *ReqRestrictorOptionsTest) SingleFlightByMethod() bool {
+	return o.ByMethod
+}
+
+func (o *ReqRestrictorOptionsTest) SingleFlightByPath() bool {
+	return o.ByPath
+}
+
+func (o *ReqRestrictorOptionsTest) SingleFlightByBody() bool {
+	return o.ByBody
+}
+
+func (o *ReqRestrictorOptionsTest) SingleFlightByHeaders() []string {
+	return nil
+}
+
+func (o *ReqRestrictorOptionsTest) SingleFlightByQueries() []string {
+	return nil
+}
+
+func (o *ReqRestrictorOptionsTest) SingleFlightByUserIP() bool {
+	return false
+}
@@ -15,8 +15,25 @@ type RequestPacket struct {
 	Header http.Header `json:"header"`
 	Query  url.Values `json:"query"`
 	Params map[string]string `json:"params"`
+	ClientIP string `json:"clientIP,omitempty"`
+	ClientCert *ClientIdentity `json:"clientCert,omitempty"`
+	Principal *Principal `json:"principal,omitempty"`
+	Origin *RequestOrigin `json:"origin,omitempty"`
 }
 
+type RequestOrigin struct {
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	File string `json:"file,omitempty"`
+	Event string `json:"event,omitempty"`
+}
+
+const ORIGIN_KIND_SCHEDULE string = "schedule"
+const ORIGIN_KIND_NATS string = "nats"
+const ORIGIN_KIND_SPOOL string = "spool"
+const ORIGIN_KIND_WATCH string = "watch"
+
 func NewReqSerializer() (*ReqSerializer, error) {
 	return &ReqSerializer{}, nil
 }
@@ -31,6 +48,15 @@ func (s *ReqSerializer) Encode(r *http.Request, fromExecUrl bool) ([]byte, error
 	if !fromExecUrl {
 		packet.Params = mux.Vars(r)
 	}
+	if userip, err := extractUserIP(r); err == nil && userip != nil {
+		packet.ClientIP = userip.String()
+	}
+	packet.ClientCert = ExtractClientIdentity(r)
+	packet.Principal = extractPrincipal(r)
+	return s.EncodePacket(packet)
+}
+
+func (s *ReqSerializer) EncodePacket(packet *RequestPacket) ([]byte, error) {
 	return json.Marshal(packet)
 }
 
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListenInheritedSockets() returns the sockets passed by systemd, or by the parent agent
+// when the agent is restarted with a listener handoff.
+func ListenInheritedSockets() ([]*SystemdListener, error) {
+	listeners, err := ListenSystemdSockets()
+	if err != nil || len(listeners) > 0 {
+		return listeners, err
+	}
+	defer func() {
+		os.Unsetenv(RESTART_ENV_LISTEN_FDS)
+		os.Unsetenv(RESTART_ENV_LISTEN_FDNAMES)
+	}()
+	return listenSystemdSockets(func(name string) string {
+		switch name {
+		case "LISTEN_PID":
+			return strconv.Itoa(os.Getpid())
+		case "LISTEN_FDS":
+			return os.Getenv(RESTART_ENV_LISTEN_FDS)
+		case "LISTEN_FDNAMES":
+			return os.Getenv(RESTART_ENV_LISTEN_FDNAMES)
+		}
+		return ""
+	}, SD_LISTEN_FDS_START)
+}
+
+// LookupRestartParent() returns the notify socket of the parent agent (empty when the agent
+// is not started by a restart), the variable is unset so that the commands do not inherit it.
+func LookupRestartParent() string {
+	socket := os.Getenv(RESTART_ENV_NOTIFY_SOCKET)
+	os.Unsetenv(RESTART_ENV_NOTIFY_SOCKET)
+	return socket
+}
+
+// NotifyRestartParent() reports the readiness of the new agent to its parent.
+func NotifyRestartParent(socket string) error {
+	if len(socket) == 0 {
+		return nil
+	}
+	return sendNotification(&net.UnixAddr{ Name: socket, Net: "unixgram" }, SD_NOTIFY_READY)
+}
+
+// ForkAgent() starts a new agent process with the same arguments, which inherits the
+// listeners as the file descriptors 3, 4, ... and reports its readiness on a private notify
+// socket. The executable is looked up again, so that an upgraded binary is started.
+func ForkAgent(listeners []*SystemdListener, timeout time.Duration) (int, error) {
+	files := make([]*os.File, 0, len(listeners))
+	names := make([]string, 0, len(listeners))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, item := range listeners {
+		filer, ok := item.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return 0, fmt.Errorf("Listener [%s] cannot be passed to a child process", item.Name)
+		}
+		file, err := filer.File()
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, file)
+		names = append(names, item.Name)
+	}
+
+	dir, err := ioutil.TempDir("", "opwire-restart")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{ Name: socket, Net: "unixgram" })
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	executable, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(filterEnvs(os.Environ(), "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", "WATCHDOG_PID"),
+		RESTART_ENV_LISTEN_FDS + "=" + strconv.Itoa(len(files)),
+		RESTART_ENV_LISTEN_FDNAMES + "=" + strings.Join(names, ":"),
+		RESTART_ENV_NOTIFY_SOCKET + "=" + socket)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		ready <- waitForReady(conn, timeout)
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			return 0, fmt.Errorf("New agent process is not ready: %s", err)
+		}
+		return cmd.Process.Pid, nil
+	case err := <-exited:
+		return 0, fmt.Errorf("New agent process has exited: %v", err)
+	}
+}
+
+func waitForReady(conn *net.UnixConn, timeout time.Duration) error {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 4096)
+	for {
+		size, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, state := range bytes.Split(buf[:size], []byte("\n")) {
+			if string(state) == SD_NOTIFY_READY {
+				return nil
+			}
+		}
+	}
+}
+
+func filterEnvs(envs []string, names ...string) []string {
+	filtered := make([]string, 0, len(envs))
+	for _, env := range envs {
+		excluded := false
+		for _, name := range names {
+			if strings.HasPrefix(env, name + "=") {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			filtered = append(filtered, env)
+		}
+	}
+	return filtered
+}
+
+const RESTART_ENV_LISTEN_FDS string = "OPWIRE_AGENT_LISTEN_FDS"
+const RESTART_ENV_LISTEN_FDNAMES string = "OPWIRE_AGENT_LISTEN_FDNAMES"
+const RESTART_ENV_NOTIFY_SOCKET string = "OPWIRE_AGENT_NOTIFY_SOCKET"
+const DEFAULT_RESTART_TIMEOUT time.Duration = 30 * time.Second
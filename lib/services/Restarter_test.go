@@ -0,0 +1,95 @@
+// +build !windows
+
+package services
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForkAgent(t *testing.T) {
+	// the test binary is forked as the new agent process
+	if mode := os.Getenv("OPWIRE_TEST_RESTART_CHILD"); len(mode) > 0 {
+		runTestRestartChild(mode)
+		return
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+
+	os.Setenv("OPWIRE_TEST_RESTART_CHILD", "ready")
+	defer os.Unsetenv("OPWIRE_TEST_RESTART_CHILD")
+	defer forkTestOnly()()
+	pid, err := ForkAgent([]*SystemdListener{ &SystemdListener{ Name: "public", Listener: listener } }, 10 * time.Second)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer func() {
+		if process, err := os.FindProcess(pid); err == nil {
+			process.Kill()
+			process.Wait()
+		}
+	}()
+	assert.NotEqual(t, os.Getpid(), pid)
+
+	// the parent stops accepting, the requests are served by the child
+	listener.Close()
+	res, err := http.Get("http://" + address + "/")
+	if assert.Nil(t, err) {
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		assert.Equal(t, "child:public", string(body))
+	}
+}
+
+func TestForkAgent_NotReady(t *testing.T) {
+	if len(os.Getenv("OPWIRE_TEST_RESTART_CHILD")) > 0 {
+		return
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	// the child exits without reporting its readiness
+	os.Setenv("OPWIRE_TEST_RESTART_CHILD", "exit")
+	defer os.Unsetenv("OPWIRE_TEST_RESTART_CHILD")
+	defer forkTestOnly()()
+	_, err = ForkAgent([]*SystemdListener{ &SystemdListener{ Name: "public", Listener: listener } }, 10 * time.Second)
+	assert.NotNil(t, err)
+}
+
+// forkTestOnly() restricts the forked test binary to TestForkAgent, it returns the function
+// restoring the arguments.
+func forkTestOnly() func() {
+	args := os.Args
+	os.Args = []string{ args[0], "-test.run=^TestForkAgent$" }
+	return func() {
+		os.Args = args
+	}
+}
+
+func runTestRestartChild(mode string) {
+	if mode != "ready" {
+		os.Exit(1)
+	}
+	listeners, err := ListenInheritedSockets()
+	if err != nil || len(listeners) != 1 {
+		os.Exit(3)
+	}
+	name := listeners[0].Name
+	go http.Serve(listeners[0].Listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "child:" + name)
+	}))
+	if err := NotifyRestartParent(LookupRestartParent()); err != nil {
+		os.Exit(4)
+	}
+	time.Sleep(10 * time.Second)
+	os.Exit(0)
+}
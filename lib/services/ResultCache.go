@@ -0,0 +1,211 @@
+package services
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResultCache keeps the successful outputs of an idempotent resource, the entries are
+// expired after the TTL and evicted in LRU order when the capacity is exceeded.
+type ResultCache struct {
+	ttl time.Duration
+	maxEntries int
+	maxBytes int64
+	pattern *SingleFlightPattern
+	allQueries bool
+	lock sync.Mutex
+	entries map[string]*list.Element
+	order *list.List
+	bytes int64
+	hits uint64
+	misses uint64
+	evictions uint64
+}
+
+type ResultCacheOptions interface {
+	GetTtl() (time.Duration, error)
+	GetMaxEntries() int
+	GetMaxBytes() int
+	GetByMethod() bool
+	GetByPath() bool
+	GetByHeaders() []string
+	GetByQueries() []string
+	GetByBody() bool
+}
+
+type CachedResult struct {
+	Key string
+	Stdout []byte
+	ETag string
+	Duration time.Duration
+	StoredAt time.Time
+}
+
+type ResultCacheStats struct {
+	Ttl float64 `json:"ttl"`
+	Entries int `json:"entries"`
+	Bytes int64 `json:"bytes"`
+	MaxEntries int `json:"maxEntries"`
+	MaxBytes int64 `json:"maxBytes"`
+	Hits uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+func NewResultCache(opts ResultCacheOptions) (*ResultCache, error) {
+	c := new(ResultCache)
+	c.ttl = DEFAULT_CACHE_TTL
+	c.maxEntries = DEFAULT_CACHE_MAX_ENTRIES
+	c.maxBytes = DEFAULT_CACHE_MAX_BYTES
+	c.pattern = &SingleFlightPattern{ HasMethod: true, HasPath: true }
+	c.allQueries = true
+	if opts != nil {
+		ttl, err := opts.GetTtl()
+		if err != nil {
+			return nil, err
+		}
+		if ttl > 0 {
+			c.ttl = ttl
+		}
+		if opts.GetMaxEntries() > 0 {
+			c.maxEntries = opts.GetMaxEntries()
+		}
+		if opts.GetMaxBytes() > 0 {
+			c.maxBytes = int64(opts.GetMaxBytes())
+		}
+		c.pattern.HasMethod = opts.GetByMethod()
+		c.pattern.HasPath = opts.GetByPath()
+		c.pattern.HasHeaders = opts.GetByHeaders()
+		c.pattern.HasQueries = opts.GetByQueries()
+		c.pattern.HasBody = opts.GetByBody()
+		c.allQueries = c.pattern.HasQueries == nil
+	}
+	c.entries = make(map[string]*list.Element)
+	c.order = list.New()
+	return c, nil
+}
+
+func (c *ResultCache) HasBody() bool {
+	return c.pattern.HasBody
+}
+
+func (c *ResultCache) Key(r *http.Request, body []byte) string {
+	p := c.pattern
+	if c.allQueries {
+		queries := r.URL.Query()
+		keys := make([]string, 0, len(queries))
+		for key := range queries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		clone := *c.pattern
+		clone.HasQueries = keys
+		p = &clone
+	}
+	return DigestCacheKey(r, p, body)
+}
+
+func (c *ResultCache) Get(key string) *CachedResult {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		result := elem.Value.(*CachedResult)
+		if time.Since(result.StoredAt) < c.ttl {
+			c.order.MoveToFront(elem)
+			c.hits++
+			return result
+		}
+		c.remove(elem)
+	}
+	c.misses++
+	return nil
+}
+
+func (c *ResultCache) Put(key string, stdout []byte, duration time.Duration) {
+	size := int64(len(stdout))
+	if size > c.maxBytes {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	result := &CachedResult{
+		Key: key,
+		Stdout: stdout,
+		ETag: ComputeETag(stdout),
+		Duration: duration,
+		StoredAt: time.Now(),
+	}
+	c.entries[key] = c.order.PushFront(result)
+	c.bytes += size
+	for c.order.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *ResultCache) Purge() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	count := c.order.Len()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.bytes = 0
+	return count
+}
+
+func (c *ResultCache) Stats() *ResultCacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return &ResultCacheStats{
+		Ttl: c.ttl.Seconds(),
+		Entries: c.order.Len(),
+		Bytes: c.bytes,
+		MaxEntries: c.maxEntries,
+		MaxBytes: c.maxBytes,
+		Hits: c.hits,
+		Misses: c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// ComputeETag() returns a strong entity tag (the hash of the output).
+func ComputeETag(stdout []byte) string {
+	sum := sha256.Sum256(stdout)
+	return fmt.Sprintf(`"%x"`, sum[:16])
+}
+
+// MatchETag() checks the etag against the value of an If-None-Match header,
+// the weak comparison is used as specified in RFC 7232.
+func MatchETag(ifNoneMatch string, etag string) bool {
+	if len(ifNoneMatch) == 0 || len(etag) == 0 {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ResultCache) remove(elem *list.Element) {
+	result := elem.Value.(*CachedResult)
+	c.order.Remove(elem)
+	delete(c.entries, result.Key)
+	c.bytes -= int64(len(result.Stdout))
+}
+
+const DEFAULT_CACHE_TTL time.Duration = 60 * time.Second
+const DEFAULT_CACHE_MAX_ENTRIES int = 1000
+const DEFAULT_CACHE_MAX_BYTES int64 = 32 << 20 // 32MB
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultCache_Key(t *testing.T) {
+	t.Run("all of queries are used by default", func(t *testing.T) {
+		c, _ := NewResultCache(&ResultCacheOptionsTest{ ByMethod: true, ByPath: true })
+		r1, _ := http.NewRequest("GET", "/reports?year=2019&month=1", nil)
+		r2, _ := http.NewRequest("GET", "/reports?month=1&year=2019", nil)
+		r3, _ := http.NewRequest("GET", "/reports?month=2&year=2019", nil)
+		assert.Equal(t, c.Key(r1, nil), c.Key(r2, nil))
+		assert.NotEqual(t, c.Key(r1, nil), c.Key(r3, nil))
+	})
+	t.Run("only the declared headers and queries are used", func(t *testing.T) {
+		c, _ := NewResultCache(&ResultCacheOptionsTest{
+			ByMethod: true,
+			ByPath: true,
+			ByHeaders: []string{ "Accept-Language" },
+			ByQueries: []string{ "year" },
+		})
+		r1, _ := http.NewRequest("GET", "/reports?year=2019&month=1", nil)
+		r2, _ := http.NewRequest("GET", "/reports?year=2019&month=2", nil)
+		assert.Equal(t, c.Key(r1, nil), c.Key(r2, nil))
+		r2.Header.Set("Accept-Language", "vi")
+		assert.NotEqual(t, c.Key(r1, nil), c.Key(r2, nil))
+	})
+	t.Run("body is used when by-body is enabled", func(t *testing.T) {
+		c, _ := NewResultCache(&ResultCacheOptionsTest{ ByPath: true, ByBody: true })
+		r, _ := http.NewRequest("GET", "/search", nil)
+		assert.NotEqual(t, c.Key(r, []byte("a")), c.Key(r, []byte("b")))
+	})
+}
+
+func TestResultCache_GetPut(t *testing.T) {
+	t.Run("entries are expired after TTL", func(t *testing.T) {
+		c, _ := NewResultCache(&ResultCacheOptionsTest{ Ttl: 50 * time.Millisecond })
+		c.Put("k", []byte("v"), time.Second)
+		assert.Equal(t, []byte("v"), c.Get("k").Stdout)
+		time.Sleep(60 * time.Millisecond)
+		assert.Nil(t, c.Get("k"))
+		stats := c.Stats()
+		assert.Equal(t, uint64(1), stats.Hits)
+		assert.Equal(t, uint64(1), stats.Misses)
+		assert.Equal(t, 0, stats.Entries)
+	})
+	t.Run("least recently used entries are evicted by max-entries", func(t *testing.T) {
+		c, _ := NewResultCache(&ResultCacheOptionsTest{ MaxEntries: 2 })
+		c.Put("a", []byte("1"), 0)
+		c.Put("b", []byte("2"), 0)
+		c.Get("a")
+		c.Put("c", []byte("3"), 0)
+		assert.NotNil(t, c.Get("a"))
+		assert.Nil(t, c.Get("b"))
+		assert.NotNil(t, c.Get("c"))
+		assert.Equal(t, uint64(1), c.Stats().Evictions)
+	})
+	t.Run("entries are evicted by max-bytes", func(t *testing.T) {
+		c, _ := NewResultCache(&ResultCacheOptionsTest{ MaxBytes: 10 })
+		c.Put("a", []byte("123456"), 0)
+		c.Put("b", []byte("7890"), 0)
+		assert.Equal(t, int64(10), c.Stats().Bytes)
+		c.Put("c", []byte("x"), 0)
+		assert.Nil(t, c.Get("a"))
+		assert.Equal(t, int64(5), c.Stats().Bytes)
+		c.Put("d", []byte("too large output"), 0)
+		assert.Nil(t, c.Get("d"))
+	})
+	t.Run("purge removes all of entries", func(t *testing.T) {
+		c, _ := NewResultCache(nil)
+		c.Put("a", []byte("1"), 0)
+		c.Put("b", []byte("2"), 0)
+		assert.Equal(t, 2, c.Purge())
+		assert.Equal(t, 0, c.Stats().Entries)
+		assert.Equal(t, int64(0), c.Stats().Bytes)
+	})
+}
+
+type ResultCacheOptionsTest struct {
+	Ttl time.Duration
+	MaxEntries int
+	MaxBytes int
+	ByMethod bool
+	ByPath bool
+	ByHeaders []string
+	ByQueries []string
+	ByBody bool
+}
+
+func (o *ResultCacheOptionsTest) GetTtl() (time.Duration, error) {
+	return o.Ttl, nil
+}
+
+func (o *ResultCacheOptionsTest) GetMaxEntries() int {
+	return o.MaxEntries
+}
+
+func (o *ResultCacheOptionsTest) GetMaxBytes() int {
+	return o.MaxBytes
+}
+
+func (o *ResultCacheOptionsTest) GetByMethod() bool {
+	return o.ByMethod
+}
+
+func (o *ResultCacheOptionsTest) GetByPath() bool {
+	return o.ByPath
+}
+
+func (o *ResultCacheOptionsTest) GetByHeaders() []string {
+	return o.ByHeaders
+}
+
+func (o *ResultCacheOptionsTest) GetByQueries() []string {
+	return o.ByQueries
+}
+
+func (o *ResultCacheOptionsTest) GetByBody() bool {
+	return o.ByBody
+}
+
+func TestMatchETag(t *testing.T) {
+	etag := ComputeETag([]byte("Hello world"))
+	assert.Equal(t, etag, ComputeETag([]byte("Hello world")))
+	assert.NotEqual(t, etag, ComputeETag([]byte("Hello world!")))
+	assert.True(t, MatchETag(etag, etag))
+	assert.True(t, MatchETag(`"abc", ` + etag, etag))
+	assert.True(t, MatchETag("W/" + etag, etag))
+	assert.True(t, MatchETag("*", etag))
+	assert.False(t, MatchETag(`"abc"`, etag))
+	assert.False(t, MatchETag("", etag))
+}
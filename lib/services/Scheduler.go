@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"github.com/robfig/cron/v3"
+	"github.com/opwire/opwire-agent/lib/invokers"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+type Scheduler struct {
+	cron *cron.Cron
+	parser cron.Parser
+	jobs map[string]*ScheduledJob
+	runner ScheduleRunner
+	logger *loq.Logger
+}
+
+type ScheduleOptions interface {
+	GetEnabled() bool
+	GetCron() string
+	GetTimezone() string
+	GetResource() string
+	GetMethod() string
+	GetStdin() []byte
+	GetSettings() map[string]interface{}
+}
+
+type ScheduleRunner func(job *ScheduledJob) (*invokers.ExecutionState, []byte, []byte, error)
+
+type ScheduledJob struct {
+	Name string
+	Cron string
+	Timezone string
+	ResourceName string
+	MethodName string
+	Stdin []byte
+	SettingsEnvs []string
+	schedule cron.Schedule
+	running int32
+	lock sync.RWMutex
+	lastRun *time.Time
+	lastResult *ScheduleResult
+}
+
+type ScheduleResult struct {
+	Status string `json:"status"`
+	Duration float64 `json:"duration"`
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type ScheduleInfo struct {
+	Name string `json:"name"`
+	Cron string `json:"cron"`
+	Timezone string `json:"timezone,omitempty"`
+	Resource string `json:"resource"`
+	Method string `json:"method,omitempty"`
+	Running bool `json:"running"`
+	LastRun *time.Time `json:"lastRun"`
+	NextRun *time.Time `json:"nextRun"`
+	LastResult *ScheduleResult `json:"lastResult"`
+}
+
+const SCHEDULE_STATUS_OK string = "ok"
+const SCHEDULE_STATUS_FAILED string = "failed"
+const SCHEDULE_STATUS_TIMEOUT string = "timeout"
+
+func NewScheduler(logger *loq.Logger, runner ScheduleRunner) (*Scheduler, error) {
+	if runner == nil {
+		return nil, fmt.Errorf("ScheduleRunner must not be nil")
+	}
+	sc := new(Scheduler)
+	sc.logger = logger
+	sc.runner = runner
+	sc.parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	sc.cron = cron.New(cron.WithParser(sc.parser))
+	sc.jobs = make(map[string]*ScheduledJob)
+	return sc, nil
+}
+
+func (sc *Scheduler) Register(job *ScheduledJob) error {
+	if job == nil {
+		return fmt.Errorf("ScheduledJob must not be nil")
+	}
+	if _, ok := sc.jobs[job.Name]; ok {
+		return fmt.Errorf("Schedule [%s] has already been registered", job.Name)
+	}
+	spec := job.Cron
+	if len(job.Timezone) > 0 {
+		if _, err := time.LoadLocation(job.Timezone); err != nil {
+			return fmt.Errorf("Schedule [%s] has an invalid timezone: %s", job.Name, err.Error())
+		}
+		spec = fmt.Sprintf("CRON_TZ=%s %s", job.Timezone, job.Cron)
+	}
+	schedule, err := sc.parser.Parse(spec)
+	if err != nil {
+		return fmt.Errorf("Schedule [%s] has an invalid cron expression: %s", job.Name, err.Error())
+	}
+	job.schedule = schedule
+	sc.cron.Schedule(schedule, cron.FuncJob(func() {
+		sc.Trigger(job)
+	}))
+	sc.jobs[job.Name] = job
+	return nil
+}
+
+func (sc *Scheduler) HasJobs() bool {
+	return len(sc.jobs) > 0
+}
+
+func (sc *Scheduler) Start() {
+	sc.cron.Start()
+}
+
+// Stop() stops the scheduling, the returned context is done when the running jobs finish.
+func (sc *Scheduler) Stop() context.Context {
+	return sc.cron.Stop()
+}
+
+// Trigger() runs the job immediately, unless its previous run has not finished yet.
+func (sc *Scheduler) Trigger(job *ScheduledJob) {
+	if !atomic.CompareAndSwapInt32(&job.running, 0, 1) {
+		sc.logger.Log(loq.WarnLevel, "Previous run is still in progress, skip this turn", loq.String("schedule", job.Name))
+		return
+	}
+	defer atomic.StoreInt32(&job.running, 0)
+
+	startTime := time.Now()
+	sc.logger.Log(loq.InfoLevel, "A scheduled command has been invoked",
+		loq.String("schedule", job.Name),
+		loq.String("resourceName", job.ResourceName),
+		loq.String("methodName", job.MethodName))
+
+	state, stdout, stderr, err := sc.runner(job)
+
+	result := &ScheduleResult{
+		Status: SCHEDULE_STATUS_OK,
+		Stdout: string(stdout),
+		Stderr: string(stderr),
+	}
+	if state != nil {
+		result.Duration = state.Duration.Seconds()
+	}
+	if err != nil {
+		result.Status = SCHEDULE_STATUS_FAILED
+		result.Error = err.Error()
+	}
+	if state != nil && state.IsTimeout {
+		result.Status = SCHEDULE_STATUS_TIMEOUT
+	}
+
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.lastRun = &startTime
+	job.lastResult = result
+
+	if result.Status != SCHEDULE_STATUS_OK {
+		sc.logger.Log(loq.ErrorLevel, "The scheduled command has failed",
+			loq.String("schedule", job.Name),
+			loq.String("status", result.Status),
+			loq.String("error", result.Error))
+	}
+}
+
+func (sc *Scheduler) List() []*ScheduleInfo {
+	names := make([]string, 0, len(sc.jobs))
+	for name := range sc.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	list := make([]*ScheduleInfo, 0, len(names))
+	for _, name := range names {
+		job := sc.jobs[name]
+		job.lock.RLock()
+		info := &ScheduleInfo{
+			Name: job.Name,
+			Cron: job.Cron,
+			Timezone: job.Timezone,
+			Resource: job.ResourceName,
+			Method: job.MethodName,
+			Running: atomic.LoadInt32(&job.running) != 0,
+			LastRun: job.lastRun,
+			LastResult: job.lastResult,
+		}
+		job.lock.RUnlock()
+		if next := job.schedule.Next(now); !next.IsZero() {
+			info.NextRun = &next
+		}
+		list = append(list, info)
+	}
+	return list
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/opwire/opwire-agent/lib/invokers"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+func TestScheduler_Register(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+	runner := func(job *ScheduledJob) (*invokers.ExecutionState, []byte, []byte, error) {
+		return &invokers.ExecutionState{}, nil, nil, nil
+	}
+
+	t.Run("cron expression with seconds and timezone", func(t *testing.T) {
+		sc, err := NewScheduler(logger, runner)
+		assert.Nil(t, err)
+		err = sc.Register(&ScheduledJob{ Name: "cleanup", Cron: "30 */5 * * * *", Timezone: "Asia/Ho_Chi_Minh" })
+		assert.Nil(t, err)
+		list := sc.List()
+		assert.Equal(t, 1, len(list))
+		assert.NotNil(t, list[0].NextRun)
+		assert.Equal(t, 30, list[0].NextRun.Second())
+		assert.Nil(t, list[0].LastRun)
+	})
+
+	t.Run("invalid cron expression", func(t *testing.T) {
+		sc, _ := NewScheduler(logger, runner)
+		err := sc.Register(&ScheduledJob{ Name: "cleanup", Cron: "every monday" })
+		assert.NotNil(t, err)
+	})
+
+	t.Run("invalid timezone", func(t *testing.T) {
+		sc, _ := NewScheduler(logger, runner)
+		err := sc.Register(&ScheduledJob{ Name: "cleanup", Cron: "@daily", Timezone: "Mars/Olympus_Mons" })
+		assert.NotNil(t, err)
+	})
+
+	t.Run("duplicated schedule names", func(t *testing.T) {
+		sc, _ := NewScheduler(logger, runner)
+		assert.Nil(t, sc.Register(&ScheduledJob{ Name: "cleanup", Cron: "@hourly" }))
+		assert.NotNil(t, sc.Register(&ScheduledJob{ Name: "cleanup", Cron: "@daily" }))
+	})
+}
+
+func TestScheduler_Trigger(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+
+	t.Run("the last result is recorded", func(t *testing.T) {
+		sc, _ := NewScheduler(logger, func(job *ScheduledJob) (*invokers.ExecutionState, []byte, []byte, error) {
+			return &invokers.ExecutionState{ Duration: time.Second }, job.Stdin, nil, nil
+		})
+		job := &ScheduledJob{ Name: "echo", Cron: "@daily", Stdin: []byte("hello") }
+		assert.Nil(t, sc.Register(job))
+		sc.Trigger(job)
+		info := sc.List()[0]
+		assert.NotNil(t, info.LastRun)
+		assert.Equal(t, SCHEDULE_STATUS_OK, info.LastResult.Status)
+		assert.Equal(t, "hello", info.LastResult.Stdout)
+		assert.Equal(t, float64(1), info.LastResult.Duration)
+	})
+
+	t.Run("failures and timeouts are reported", func(t *testing.T) {
+		sc, _ := NewScheduler(logger, func(job *ScheduledJob) (*invokers.ExecutionState, []byte, []byte, error) {
+			if job.Name == "timeout" {
+				return &invokers.ExecutionState{ IsTimeout: true }, nil, nil, fmt.Errorf("signal: killed")
+			}
+			return nil, nil, []byte("oops"), fmt.Errorf("exit status 1")
+		})
+		failed := &ScheduledJob{ Name: "failed", Cron: "@daily" }
+		timeout := &ScheduledJob{ Name: "timeout", Cron: "@daily" }
+		assert.Nil(t, sc.Register(failed))
+		assert.Nil(t, sc.Register(timeout))
+		sc.Trigger(failed)
+		sc.Trigger(timeout)
+		list := sc.List()
+		assert.Equal(t, SCHEDULE_STATUS_FAILED, list[0].LastResult.Status)
+		assert.Equal(t, "exit status 1", list[0].LastResult.Error)
+		assert.Equal(t, "oops", list[0].LastResult.Stderr)
+		assert.Equal(t, SCHEDULE_STATUS_TIMEOUT, list[1].LastResult.Status)
+	})
+}
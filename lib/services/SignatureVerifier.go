@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureVerifier checks the HMAC signature of the webhook requests (GitHub, Stripe, Slack
+// like senders). The signed payload is built from a template of the raw body and the optional
+// timestamp, which must be fresh (within the tolerance) to prevent the replay attacks.
+type SignatureVerifier struct {
+	header string
+	hashFunc func() hash.Hash
+	secret []byte
+	prefix string
+	encoding string
+	signatureKey string
+	timestampHeader string
+	timestampKey string
+	tolerance time.Duration
+	payload string
+}
+
+type SignatureOptions interface {
+	GetHeader() string
+	GetAlgorithm() string
+	GetSecret() string
+	GetSecretFile() string
+	GetSecretEnv() string
+	GetPrefix() string
+	GetEncoding() string
+	GetSignatureKey() string
+	GetTimestampHeader() string
+	GetTimestampKey() string
+	GetTolerance() (time.Duration, error)
+	GetPayload() string
+}
+
+func NewSignatureVerifier(opts SignatureOptions) (*SignatureVerifier, error) {
+	v := new(SignatureVerifier)
+	v.header = opts.GetHeader()
+	if len(v.header) == 0 {
+		return nil, fmt.Errorf("Signature must declare the header")
+	}
+	switch opts.GetAlgorithm() {
+	case "", "sha256":
+		v.hashFunc = sha256.New
+	case "sha1":
+		v.hashFunc = sha1.New
+	default:
+		return nil, fmt.Errorf("Signature algorithm [%s] is not supported", opts.GetAlgorithm())
+	}
+	v.secret = []byte(opts.GetSecret())
+	if file := opts.GetSecretFile(); len(file) > 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		v.secret = bytes.TrimSpace(data)
+	}
+	if env := opts.GetSecretEnv(); len(env) > 0 {
+		v.secret = []byte(os.Getenv(env))
+	}
+	if len(v.secret) == 0 {
+		return nil, fmt.Errorf("Signature secret must not be empty")
+	}
+	v.prefix = opts.GetPrefix()
+	v.encoding = opts.GetEncoding()
+	switch v.encoding {
+	case "":
+		v.encoding = SIGNATURE_ENCODING_HEX
+	case SIGNATURE_ENCODING_HEX, SIGNATURE_ENCODING_BASE64:
+	default:
+		return nil, fmt.Errorf("Signature encoding [%s] is not supported", v.encoding)
+	}
+	v.signatureKey = opts.GetSignatureKey()
+	v.timestampHeader = opts.GetTimestampHeader()
+	v.timestampKey = opts.GetTimestampKey()
+	tolerance, err := opts.GetTolerance()
+	if err != nil {
+		return nil, err
+	}
+	v.tolerance = tolerance
+	if v.tolerance <= 0 {
+		v.tolerance = DEFAULT_SIGNATURE_TOLERANCE
+	}
+	v.payload = opts.GetPayload()
+	if len(v.payload) == 0 {
+		v.payload = SIGNATURE_PAYLOAD_BODY
+	}
+	return v, nil
+}
+
+// Verify() checks the signature of the raw body of a request.
+func (v *SignatureVerifier) Verify(r *http.Request, body []byte) error {
+	value := r.Header.Get(v.header)
+	if len(value) == 0 {
+		return fmt.Errorf("Signature header [%s] is missing", v.header)
+	}
+	signatures, timestamp := v.parseHeader(value)
+	if len(v.timestampHeader) > 0 {
+		timestamp = r.Header.Get(v.timestampHeader)
+	}
+	if len(v.timestampHeader) > 0 || len(v.timestampKey) > 0 {
+		if err := v.checkTimestamp(timestamp, time.Now()); err != nil {
+			return err
+		}
+	}
+	mac := hmac.New(v.hashFunc, v.secret)
+	mac.Write(v.buildPayload(timestamp, body))
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if decoded, err := v.decode(signature); err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Signature is invalid")
+}
+
+// parseHeader() extracts the signatures from the header, it is a list of key=value pairs
+// (e.g. "t=1492774577,v1=5257a8...") when the signature-key is declared.
+func (v *SignatureVerifier) parseHeader(value string) ([]string, string) {
+	if len(v.signatureKey) == 0 {
+		if len(v.prefix) > 0 {
+			if !strings.HasPrefix(value, v.prefix) {
+				return nil, ""
+			}
+			value = value[len(v.prefix):]
+		}
+		return []string{ strings.TrimSpace(value) }, ""
+	}
+	signatures := make([]string, 0)
+	timestamp := ""
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case v.signatureKey:
+			signatures = append(signatures, kv[1])
+		case v.timestampKey:
+			timestamp = kv[1]
+		}
+	}
+	return signatures, timestamp
+}
+
+func (v *SignatureVerifier) checkTimestamp(timestamp string, now time.Time) error {
+	if len(timestamp) == 0 {
+		return fmt.Errorf("Signature timestamp is missing")
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Signature timestamp is malformed")
+	}
+	if math.Abs(now.Sub(time.Unix(seconds, 0)).Seconds()) > v.tolerance.Seconds() {
+		return fmt.Errorf("Signature timestamp is out of the tolerance")
+	}
+	return nil
+}
+
+// buildPayload() replaces the {timestamp} & {body} placeholders of the payload template.
+func (v *SignatureVerifier) buildPayload(timestamp string, body []byte) []byte {
+	var payload bytes.Buffer
+	parts := strings.Split(v.payload, SIGNATURE_PAYLOAD_BODY)
+	for i, part := range parts {
+		if i > 0 {
+			payload.Write(body)
+		}
+		payload.WriteString(strings.Replace(part, "{timestamp}", timestamp, -1))
+	}
+	return payload.Bytes()
+}
+
+func (v *SignatureVerifier) decode(signature string) ([]byte, error) {
+	if v.encoding == SIGNATURE_ENCODING_BASE64 {
+		return base64.StdEncoding.DecodeString(signature)
+	}
+	return hex.DecodeString(strings.ToLower(signature))
+}
+
+const SIGNATURE_ENCODING_HEX string = "hex"
+const SIGNATURE_ENCODING_BASE64 string = "base64"
+const SIGNATURE_PAYLOAD_BODY string = "{body}"
+const DEFAULT_SIGNATURE_TOLERANCE time.Duration = 5 * time.Minute
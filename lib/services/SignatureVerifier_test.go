@@ -0,0 +1,144 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignatureVerifier(t *testing.T) {
+	body := []byte(`{"action":"opened","number":1}`)
+
+	t.Run("GitHub style signature with a prefix", func(t *testing.T) {
+		v, err := NewSignatureVerifier(&SignatureOptionsTest{ Header: "X-Hub-Signature-256", Secret: "gh-secret", Prefix: "sha256=" })
+		assert.Nil(t, err)
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-Hub-Signature-256", "sha256=" + hex.EncodeToString(computeTestHMAC(sha256.New, "gh-secret", body)))
+		assert.Nil(t, v.Verify(r, body))
+		assert.NotNil(t, v.Verify(r, []byte(`{"action":"closed","number":1}`)))
+		r.Header.Set("X-Hub-Signature-256", hex.EncodeToString(computeTestHMAC(sha256.New, "gh-secret", body)))
+		assert.NotNil(t, v.Verify(r, body))
+		assert.NotNil(t, v.Verify(httptest.NewRequest("POST", "/", nil), body))
+	})
+
+	t.Run("Stripe style signature with an embedded timestamp", func(t *testing.T) {
+		v, err := NewSignatureVerifier(&SignatureOptionsTest{
+			Header: "Stripe-Signature", Secret: "whsec", SignatureKey: "v1", TimestampKey: "t", Payload: "{timestamp}.{body}",
+		})
+		assert.Nil(t, err)
+		for offset, valid := range map[time.Duration]bool{ 0: true, -time.Hour: false } {
+			ts := fmt.Sprintf("%d", time.Now().Add(offset).Unix())
+			signature := hex.EncodeToString(computeTestHMAC(sha256.New, "whsec", []byte(ts + "." + string(body))))
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set("Stripe-Signature", fmt.Sprintf("t=%s,v1=deadbeef,v1=%s", ts, signature))
+			assert.Equal(t, valid, v.Verify(r, body) == nil)
+		}
+	})
+
+	t.Run("Slack style signature with a timestamp header", func(t *testing.T) {
+		v, err := NewSignatureVerifier(&SignatureOptionsTest{
+			Header: "X-Slack-Signature", Secret: "slack", Prefix: "v0=", TimestampHeader: "X-Slack-Request-Timestamp", Payload: "v0:{timestamp}:{body}",
+		})
+		assert.Nil(t, err)
+		ts := fmt.Sprintf("%d", time.Now().Unix())
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-Slack-Request-Timestamp", ts)
+		r.Header.Set("X-Slack-Signature", "v0=" + hex.EncodeToString(computeTestHMAC(sha256.New, "slack", []byte("v0:" + ts + ":" + string(body)))))
+		assert.Nil(t, v.Verify(r, body))
+		r.Header.Del("X-Slack-Request-Timestamp")
+		assert.NotNil(t, v.Verify(r, body))
+	})
+
+	t.Run("sha1 signature encoded in base64 with the secret of an environment variable", func(t *testing.T) {
+		os.Setenv("OPWIRE_TEST_WEBHOOK_SECRET", "env-secret")
+		defer os.Unsetenv("OPWIRE_TEST_WEBHOOK_SECRET")
+		v, err := NewSignatureVerifier(&SignatureOptionsTest{ Header: "X-Signature", Algorithm: "sha1", Encoding: "base64", SecretEnv: "OPWIRE_TEST_WEBHOOK_SECRET" })
+		assert.Nil(t, err)
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-Signature", base64.StdEncoding.EncodeToString(computeTestHMAC(sha1.New, "env-secret", body)))
+		assert.Nil(t, v.Verify(r, body))
+	})
+
+	t.Run("verifier requires a secret and a supported algorithm", func(t *testing.T) {
+		_, err := NewSignatureVerifier(&SignatureOptionsTest{ Header: "X-Signature" })
+		assert.NotNil(t, err)
+		_, err = NewSignatureVerifier(&SignatureOptionsTest{ Header: "X-Signature", Secret: "s", Algorithm: "md5" })
+		assert.NotNil(t, err)
+	})
+}
+
+func computeTestHMAC(h func() hash.Hash, secret string, payload []byte) []byte {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+type SignatureOptionsTest struct {
+	Header string
+	Algorithm string
+	Secret string
+	SecretEnv string
+	Prefix string
+	Encoding string
+	SignatureKey string
+	TimestampHeader string
+	TimestampKey string
+	Payload string
+}
+
+func (o *SignatureOptionsTest) GetHeader() string {
+	return o.Header
+}
+
+func (o *SignatureOptionsTest) GetAlgorithm() string {
+	return o.Algorithm
+}
+
+func (o *SignatureOptionsTest) GetSecret() string {
+	return o.Secret
+}
+
+func (o *SignatureOptionsTest) GetSecretFile() string {
+	return ""
+}
+
+func (o *SignatureOptionsTest) GetSecretEnv() string {
+	return o.SecretEnv
+}
+
+func (o *SignatureOptionsTest) GetPrefix() string {
+	return o.Prefix
+}
+
+func (o *SignatureOptionsTest) GetEncoding() string {
+	return o.Encoding
+}
+
+func (o *SignatureOptionsTest) GetSignatureKey() string {
+	return o.SignatureKey
+}
+
+func (o *SignatureOptionsTest) GetTimestampHeader() string {
+	return o.TimestampHeader
+}
+
+func (o *SignatureOptionsTest) GetTimestampKey() string {
+	return o.TimestampKey
+}
+
+func (o *SignatureOptionsTest) GetTolerance() (time.Duration, error) {
+	return 0, nil
+}
+
+func (o *SignatureOptionsTest) GetPayload() string {
+	return o.Payload
+}
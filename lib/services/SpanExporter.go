@@ -0,0 +1,261 @@
+package services
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OtlpExporter posts the spans to an OpenTelemetry collector with OTLP/HTTP, using the JSON
+// encoding of the protocol (the trace & span ids are hex strings, the 64-bit integers are
+// decimal strings).
+type OtlpExporter struct {
+	endpoint string
+	headers map[string]string
+	client *http.Client
+}
+
+type OtlpExporterOptions interface {
+	GetEndpoint() string
+	GetHeaders() map[string]string
+	GetTimeout() (time.Duration, error)
+}
+
+func NewOtlpExporter(opts OtlpExporterOptions) (*OtlpExporter, error) {
+	if opts == nil || len(opts.GetEndpoint()) == 0 {
+		return nil, fmt.Errorf("OTLP exporter must declare an endpoint")
+	}
+	timeout, err := opts.GetTimeout()
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = DEFAULT_OTLP_TIMEOUT
+	}
+	return &OtlpExporter{
+		endpoint: opts.GetEndpoint(),
+		headers: opts.GetHeaders(),
+		client: &http.Client{ Timeout: timeout },
+	}, nil
+}
+
+func (e *OtlpExporter) ExportSpans(serviceName string, spans []*Span) error {
+	payload, err := json.Marshal(buildOtlpRequest(serviceName, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("OTLP endpoint [%s] responded %s", e.endpoint, res.Status)
+	}
+	return nil
+}
+
+func (e *OtlpExporter) Close() error {
+	return nil
+}
+
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource *otlpResource `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope *otlpScope `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId string `json:"traceId"`
+	SpanId string `json:"spanId"`
+	ParentSpanId string `json:"parentSpanId,omitempty"`
+	Name string `json:"name"`
+	Kind int `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano string `json:"endTimeUnixNano"`
+	Attributes []*otlpKeyValue `json:"attributes,omitempty"`
+	Status *otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key string `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func buildOtlpRequest(serviceName string, spans []*Span) *otlpRequest {
+	items := make([]*otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		item := &otlpSpan{
+			TraceId: hex.EncodeToString(s.context.TraceID[:]),
+			SpanId: hex.EncodeToString(s.context.SpanID[:]),
+			Name: s.name,
+			Kind: s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.startTime.UnixNano(), 10),
+			EndTimeUnixNano: strconv.FormatInt(s.endTime.UnixNano(), 10),
+			Status: &otlpStatus{ Code: OTLP_STATUS_UNSET },
+		}
+		if s.hasParent() {
+			item.ParentSpanId = hex.EncodeToString(s.parentID[:])
+		}
+		for _, attr := range s.attributes {
+			item.Attributes = append(item.Attributes, buildOtlpKeyValue(attr.Key, attr.Value))
+		}
+		if s.failed {
+			item.Status = &otlpStatus{ Code: OTLP_STATUS_ERROR, Message: s.statusMessage }
+		}
+		items = append(items, item)
+	}
+	return &otlpRequest{
+		ResourceSpans: []*otlpResourceSpans{
+			&otlpResourceSpans{
+				Resource: &otlpResource{
+					Attributes: []*otlpKeyValue{ buildOtlpKeyValue("service.name", serviceName) },
+				},
+				ScopeSpans: []*otlpScopeSpans{
+					&otlpScopeSpans{
+						Scope: &otlpScope{ Name: DEFAULT_TRACING_SERVICE_NAME },
+						Spans: items,
+					},
+				},
+			},
+		},
+	}
+}
+
+func buildOtlpKeyValue(key string, value interface{}) *otlpKeyValue {
+	kv := &otlpKeyValue{ Key: key }
+	switch v := value.(type) {
+	case bool:
+		kv.Value = map[string]interface{}{ "boolValue": v }
+	case int:
+		kv.Value = map[string]interface{}{ "intValue": strconv.Itoa(v) }
+	case int64:
+		kv.Value = map[string]interface{}{ "intValue": strconv.FormatInt(v, 10) }
+	case float64:
+		kv.Value = map[string]interface{}{ "doubleValue": v }
+	default:
+		kv.Value = map[string]interface{}{ "stringValue": fmt.Sprint(v) }
+	}
+	return kv
+}
+
+// FileSpanExporter appends the spans to a file, one JSON object per line.
+type FileSpanExporter struct {
+	file *os.File
+	mutex sync.Mutex
+}
+
+type fileSpanRecord struct {
+	Service string `json:"service"`
+	TraceId string `json:"traceId"`
+	SpanId string `json:"spanId"`
+	ParentSpanId string `json:"parentSpanId,omitempty"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	StartTime time.Time `json:"startTime"`
+	EndTime time.Time `json:"endTime"`
+	Duration float64 `json:"duration"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Status string `json:"status"`
+	StatusMessage string `json:"statusMessage,omitempty"`
+}
+
+func NewFileSpanExporter(path string) (*FileSpanExporter, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("File exporter must declare a path")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSpanExporter{ file: file }, nil
+}
+
+func (e *FileSpanExporter) ExportSpans(serviceName string, spans []*Span) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, s := range spans {
+		record := &fileSpanRecord{
+			Service: serviceName,
+			TraceId: hex.EncodeToString(s.context.TraceID[:]),
+			SpanId: hex.EncodeToString(s.context.SpanID[:]),
+			Name: s.name,
+			Kind: "internal",
+			StartTime: s.startTime,
+			EndTime: s.endTime,
+			Duration: s.endTime.Sub(s.startTime).Seconds(),
+			Status: "ok",
+		}
+		if s.kind == SPAN_KIND_SERVER {
+			record.Kind = "server"
+		}
+		if s.hasParent() {
+			record.ParentSpanId = hex.EncodeToString(s.parentID[:])
+		}
+		if len(s.attributes) > 0 {
+			record.Attributes = make(map[string]interface{}, len(s.attributes))
+			for _, attr := range s.attributes {
+				record.Attributes[attr.Key] = attr.Value
+			}
+		}
+		if s.failed {
+			record.Status = "error"
+			record.StatusMessage = s.statusMessage
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, err := e.file.Write(buf.Bytes())
+	return err
+}
+
+func (e *FileSpanExporter) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.file.Close()
+}
+
+const OTLP_STATUS_UNSET int = 0
+const OTLP_STATUS_ERROR int = 2
+const DEFAULT_OTLP_TIMEOUT time.Duration = 10 * time.Second
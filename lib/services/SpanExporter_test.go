@@ -0,0 +1,134 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOtlpExporter_ExportSpans(t *testing.T) {
+	// a local collector stub receiving the OTLP/HTTP requests
+	received := make(chan map[string]interface{}, 4)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		var payload map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&payload))
+		received <- payload
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOtlpExporter(&OtlpExporterOptionsTest{
+		Endpoint: collector.URL + "/v1/traces",
+		Headers: map[string]string{ "Authorization": "Bearer token" },
+	})
+	assert.Nil(t, err)
+	tracer, _ := NewTracer(nil, &TracerOptionsTest{ ServiceName: "reports-agent", FlushInterval: 20 * time.Millisecond }, exporter)
+
+	ctx := ContextWithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := tracer.StartSpan(ctx, "HTTP POST", SPAN_KIND_SERVER)
+	span.SetAttribute("http.status_code", 200)
+	span.SetAttribute("http.method", "POST")
+	span.End()
+
+	select {
+	case payload := <-received:
+		resourceSpans := payload["resourceSpans"].([]interface{})[0].(map[string]interface{})
+		resource := resourceSpans["resource"].(map[string]interface{})
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{ "key": "service.name", "value": map[string]interface{}{ "stringValue": "reports-agent" } },
+		}, resource["attributes"])
+		scopeSpans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})
+		item := scopeSpans["spans"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", item["traceId"])
+		assert.Equal(t, "00f067aa0ba902b7", item["parentSpanId"])
+		assert.Equal(t, "HTTP POST", item["name"])
+		assert.Equal(t, float64(SPAN_KIND_SERVER), item["kind"])
+		assert.IsType(t, "", item["startTimeUnixNano"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{ "key": "http.status_code", "value": map[string]interface{}{ "intValue": "200" } },
+			map[string]interface{}{ "key": "http.method", "value": map[string]interface{}{ "stringValue": "POST" } },
+		}, item["attributes"])
+	case <-time.After(2 * time.Second):
+		t.Fatal("the spans are not exported")
+	}
+	assert.Nil(t, tracer.Shutdown(context.Background()))
+}
+
+func TestOtlpExporter_Rejected(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer collector.Close()
+	exporter, _ := NewOtlpExporter(&OtlpExporterOptionsTest{ Endpoint: collector.URL })
+	assert.NotNil(t, exporter.ExportSpans("opwire-agent", []*Span{ &Span{ name: "HTTP GET" } }))
+}
+
+func TestFileSpanExporter_ExportSpans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "opwire-traces")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.json")
+
+	exporter, err := NewFileSpanExporter(path)
+	assert.Nil(t, err)
+	tracer, _ := NewTracer(nil, nil, exporter)
+	ctx, parent := tracer.StartSpan(context.Background(), "HTTP GET", SPAN_KIND_SERVER)
+	_, child := tracer.StartSpan(ctx, "process sh", SPAN_KIND_INTERNAL)
+	child.SetAttribute("process.exit_code", 1)
+	child.SetError(&os.PathError{ Op: "exec", Path: "sh", Err: os.ErrNotExist })
+	child.End()
+	parent.End()
+	assert.Nil(t, tracer.Shutdown(context.Background()))
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	records := make([]map[string]interface{}, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	if assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, "process sh", records[0]["name"])
+		assert.Equal(t, "internal", records[0]["kind"])
+		assert.Equal(t, "error", records[0]["status"])
+		assert.Equal(t, map[string]interface{}{ "process.exit_code": float64(1) }, records[0]["attributes"])
+		assert.Equal(t, records[1]["spanId"], records[0]["parentSpanId"])
+		assert.Equal(t, records[1]["traceId"], records[0]["traceId"])
+		assert.Equal(t, "server", records[1]["kind"])
+		assert.Equal(t, "ok", records[1]["status"])
+		assert.Nil(t, records[1]["parentSpanId"])
+		assert.Equal(t, DEFAULT_TRACING_SERVICE_NAME, records[1]["service"])
+	}
+}
+
+type OtlpExporterOptionsTest struct {
+	Endpoint string
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+func (o *OtlpExporterOptionsTest) GetEndpoint() string {
+	return o.Endpoint
+}
+
+func (o *OtlpExporterOptionsTest) GetHeaders() map[string]string {
+	return o.Headers
+}
+
+func (o *OtlpExporterOptionsTest) GetTimeout() (time.Duration, error) {
+	return o.Timeout, nil
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+// SpoolBroker consumes the files dropped into a spool directory. Producers should write
+// a message into a temporary file then rename it into the directory, the optional headers
+// of the message are written into the sidecar file "<message-file>.headers" (MIME format).
+type SpoolBroker struct {
+	name string
+	directory string
+	processingDirectory string
+	replyDirectory string
+	deadLetterDirectory string
+	pollInterval time.Duration
+	stopChan chan struct{}
+	stopped sync.WaitGroup
+	logger *loq.Logger
+}
+
+type SpoolBrokerOptions interface {
+	GetDirectory() string
+	GetReplyDirectory() string
+	GetDeadLetterDirectory() string
+	GetPollInterval() (time.Duration, error)
+}
+
+func NewSpoolBroker(name string, logger *loq.Logger, opts SpoolBrokerOptions) (*SpoolBroker, error) {
+	if opts == nil {
+		return nil, fmt.Errorf("SpoolBrokerOptions must not be nil")
+	}
+	if len(opts.GetDirectory()) == 0 {
+		return nil, fmt.Errorf("Broker [%s] must declare a spool directory", name)
+	}
+	b := new(SpoolBroker)
+	b.name = name
+	b.logger = logger
+	b.directory = opts.GetDirectory()
+	b.processingDirectory = filepath.Join(b.directory, SPOOL_PROCESSING_DIR)
+	b.replyDirectory = opts.GetReplyDirectory()
+	b.deadLetterDirectory = opts.GetDeadLetterDirectory()
+	if len(b.deadLetterDirectory) == 0 {
+		b.deadLetterDirectory = filepath.Join(b.directory, SPOOL_DEAD_LETTER_DIR)
+	}
+	b.pollInterval = time.Second
+	if interval, err := opts.GetPollInterval(); interval > 0 && err == nil {
+		b.pollInterval = interval
+	}
+	return b, nil
+}
+
+func (b *SpoolBroker) GetName() string {
+	return b.name
+}
+
+func (b *SpoolBroker) Start(handler BrokerHandler) error {
+	if handler == nil {
+		return fmt.Errorf("BrokerHandler must not be nil")
+	}
+	for _, dir := range []string{ b.directory, b.processingDirectory, b.replyDirectory, b.deadLetterDirectory } {
+		if len(dir) > 0 {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+	}
+	// the messages that have been claimed but not acknowledged are delivered again
+	if err := b.recover(); err != nil {
+		return err
+	}
+	b.stopChan = make(chan struct{})
+	b.stopped.Add(1)
+	go func() {
+		defer b.stopped.Done()
+		ticker := time.NewTicker(b.pollInterval)
+		defer ticker.Stop()
+		for {
+			b.Poll(handler)
+			select {
+			case <-b.stopChan:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	b.logger.Log(loq.InfoLevel, "Spool broker is consuming messages",
+		loq.String("broker", b.name),
+		loq.String("directory", b.directory))
+	return nil
+}
+
+// Stop() waits for the message in progress to be completed.
+func (b *SpoolBroker) Stop() error {
+	if b.stopChan == nil {
+		return nil
+	}
+	close(b.stopChan)
+	b.stopped.Wait()
+	b.stopChan = nil
+	return nil
+}
+
+// Poll() processes all of the messages that are available in the spool directory.
+func (b *SpoolBroker) Poll(handler BrokerHandler) {
+	names, err := b.scan(b.directory)
+	if err != nil {
+		b.logger.Log(loq.ErrorLevel, "Scanning spool directory failed", loq.String("broker", b.name), loq.Error(err))
+		return
+	}
+	for _, name := range names {
+		if b.stopChan != nil {
+			select {
+			case <-b.stopChan:
+				return
+			default:
+			}
+		}
+		if retry := b.process(name, handler); retry {
+			return
+		}
+	}
+}
+
+func (b *SpoolBroker) scan(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		name := file.Name()
+		if !file.Mode().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, SPOOL_HEADERS_EXT) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *SpoolBroker) recover() error {
+	names, err := b.scan(b.processingDirectory)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := b.move(name, b.processingDirectory, b.directory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *SpoolBroker) process(name string, handler BrokerHandler) bool {
+	// claims the message, another consumer may have taken it already
+	if err := os.Rename(filepath.Join(b.directory, name), filepath.Join(b.processingDirectory, name)); err != nil {
+		return false
+	}
+	os.Rename(filepath.Join(b.directory, name + SPOOL_HEADERS_EXT), filepath.Join(b.processingDirectory, name + SPOOL_HEADERS_EXT))
+
+	msgPath := filepath.Join(b.processingDirectory, name)
+	body, err := ioutil.ReadFile(msgPath)
+	var header http.Header
+	if err == nil {
+		header, err = readSpoolHeaders(msgPath + SPOOL_HEADERS_EXT)
+	}
+
+	var result *BrokerResult
+	if err != nil {
+		result = &BrokerResult{ Error: err }
+	} else {
+		id := header.Get(REQ_HEADER_REQUEST_ID_NAME)
+		if len(id) == 0 {
+			id = name
+		}
+		result = handler(&BrokerMessage{
+			Id: id,
+			Header: header,
+			Body: body,
+			Origin: &RequestOrigin{
+				Kind: ORIGIN_KIND_SPOOL,
+				Name: b.name,
+				File: name,
+			},
+		})
+	}
+
+	if result != nil && result.Retry {
+		b.move(name, b.processingDirectory, b.directory)
+		return true
+	}
+
+	if !result.Failed() {
+		if len(b.replyDirectory) > 0 {
+			if err := writeFileAtomically(filepath.Join(b.replyDirectory, name), result.Stdout); err != nil {
+				b.logger.Log(loq.ErrorLevel, "Writing reply failed", loq.String("broker", b.name), loq.Error(err))
+			}
+		}
+		os.Remove(msgPath)
+		os.Remove(msgPath + SPOOL_HEADERS_EXT)
+		return false
+	}
+
+	errorMessage := result.ErrorMessage()
+	b.logger.Log(loq.ErrorLevel, "The message has failed",
+		loq.String("broker", b.name),
+		loq.String("file", name),
+		loq.String("error", errorMessage))
+
+	var report bytes.Buffer
+	report.WriteString(errorMessage)
+	report.WriteString("\n")
+	if result != nil {
+		report.Write(result.Stderr)
+	}
+	if err := writeFileAtomically(filepath.Join(b.deadLetterDirectory, name + SPOOL_ERROR_EXT), report.Bytes()); err != nil {
+		b.logger.Log(loq.ErrorLevel, "Writing error report failed", loq.String("broker", b.name), loq.Error(err))
+	}
+	if err := b.move(name, b.processingDirectory, b.deadLetterDirectory); err != nil {
+		b.logger.Log(loq.ErrorLevel, "Moving message to dead-letter failed", loq.String("broker", b.name), loq.Error(err))
+	}
+	return false
+}
+
+func (b *SpoolBroker) move(name string, fromDir string, toDir string) error {
+	if err := os.Rename(filepath.Join(fromDir, name), filepath.Join(toDir, name)); err != nil {
+		return err
+	}
+	headersFile := name + SPOOL_HEADERS_EXT
+	if _, err := os.Stat(filepath.Join(fromDir, headersFile)); err == nil {
+		return os.Rename(filepath.Join(fromDir, headersFile), filepath.Join(toDir, headersFile))
+	}
+	return nil
+}
+
+func readSpoolHeaders(headersPath string) (http.Header, error) {
+	data, err := ioutil.ReadFile(headersPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return http.Header{}, nil
+		}
+		return nil, err
+	}
+	data = append(bytes.TrimRight(data, "\r\n"), []byte("\r\n\r\n")...)
+	mime, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(data))).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	return http.Header(mime), nil
+}
+
+func writeFileAtomically(filePath string, data []byte) error {
+	dir, name := filepath.Split(filePath)
+	tmp, err := ioutil.TempFile(dir, "." + name)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+const SPOOL_PROCESSING_DIR string = ".processing"
+const SPOOL_DEAD_LETTER_DIR string = ".dead-letter"
+const SPOOL_HEADERS_EXT string = ".headers"
+const SPOOL_ERROR_EXT string = ".error"
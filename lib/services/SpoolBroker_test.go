@@ -0,0 +1,120 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+func TestSpoolBroker_Poll(t *testing.T) {
+	logger, _ := loq.NewLogger(nil)
+
+	dir, err := ioutil.TempDir("", "opwire-spool")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	inbox := filepath.Join(dir, "inbox")
+	outbox := filepath.Join(dir, "outbox")
+	failed := filepath.Join(dir, "failed")
+
+	broker, err := NewSpoolBroker("uploads", logger, &SpoolBrokerOptionsTest{
+		Directory: inbox,
+		ReplyDirectory: outbox,
+		DeadLetterDirectory: failed,
+	})
+	assert.Nil(t, err)
+
+	handler := func(msg *BrokerMessage) *BrokerResult {
+		switch msg.Header.Get("X-Action") {
+		case "fail":
+			return &BrokerResult{ Stderr: []byte("oops"), Error: fmt.Errorf("exit status 1") }
+		case "retry":
+			return &BrokerResult{ Error: fmt.Errorf("not ready"), Retry: true }
+		}
+		return &BrokerResult{ Stdout: append([]byte(msg.Origin.File + ": "), msg.Body...) }
+	}
+
+	assert.Nil(t, broker.Start(handler))
+	assert.Nil(t, broker.Stop())
+
+	t.Run("message is acknowledged and replied", func(t *testing.T) {
+		ioutil.WriteFile(filepath.Join(inbox, "001.csv"), []byte("a,b,c"), 0644)
+		broker.Poll(handler)
+		assert.False(t, fileExists(filepath.Join(inbox, "001.csv")))
+		assert.False(t, fileExists(filepath.Join(inbox, SPOOL_PROCESSING_DIR, "001.csv")))
+		reply, err := ioutil.ReadFile(filepath.Join(outbox, "001.csv"))
+		assert.Nil(t, err)
+		assert.Equal(t, "001.csv: a,b,c", string(reply))
+	})
+
+	t.Run("failed message is moved to the dead-letter directory", func(t *testing.T) {
+		ioutil.WriteFile(filepath.Join(inbox, "002.csv"), []byte("x"), 0644)
+		ioutil.WriteFile(filepath.Join(inbox, "002.csv" + SPOOL_HEADERS_EXT), []byte("X-Action: fail\n"), 0644)
+		broker.Poll(handler)
+		assert.False(t, fileExists(filepath.Join(inbox, "002.csv")))
+		assert.True(t, fileExists(filepath.Join(failed, "002.csv")))
+		assert.True(t, fileExists(filepath.Join(failed, "002.csv" + SPOOL_HEADERS_EXT)))
+		report, err := ioutil.ReadFile(filepath.Join(failed, "002.csv" + SPOOL_ERROR_EXT))
+		assert.Nil(t, err)
+		assert.Equal(t, "exit status 1\noops", string(report))
+	})
+
+	t.Run("message is kept in the spool directory for retrying", func(t *testing.T) {
+		ioutil.WriteFile(filepath.Join(inbox, "003.csv"), []byte("y"), 0644)
+		ioutil.WriteFile(filepath.Join(inbox, "003.csv" + SPOOL_HEADERS_EXT), []byte("X-Action: retry"), 0644)
+		broker.Poll(handler)
+		assert.True(t, fileExists(filepath.Join(inbox, "003.csv")))
+		assert.True(t, fileExists(filepath.Join(inbox, "003.csv" + SPOOL_HEADERS_EXT)))
+	})
+
+	t.Run("unacknowledged messages are delivered again after restarting", func(t *testing.T) {
+		ioutil.WriteFile(filepath.Join(inbox, SPOOL_PROCESSING_DIR, "004.csv"), []byte("z"), 0644)
+		done := make(chan string, 1)
+		assert.Nil(t, broker.Start(func(msg *BrokerMessage) *BrokerResult {
+			if msg.Origin.File == "004.csv" {
+				done <- string(msg.Body)
+			}
+			return &BrokerResult{}
+		}))
+		defer broker.Stop()
+		select {
+		case body := <-done:
+			assert.Equal(t, "z", body)
+		case <-time.After(3 * time.Second):
+			t.Error("message has not been delivered again")
+		}
+	})
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+type SpoolBrokerOptionsTest struct {
+	Directory string
+	ReplyDirectory string
+	DeadLetterDirectory string
+	PollInterval time.Duration
+}
+
+func (o *SpoolBrokerOptionsTest) GetDirectory() string {
+	return o.Directory
+}
+
+func (o *SpoolBrokerOptionsTest) GetReplyDirectory() string {
+	return o.ReplyDirectory
+}
+
+func (o *SpoolBrokerOptionsTest) GetDeadLetterDirectory() string {
+	return o.DeadLetterDirectory
+}
+
+func (o *SpoolBrokerOptionsTest) GetPollInterval() (time.Duration, error) {
+	return o.PollInterval, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SystemdListener is a socket passed by the systemd socket activation, named by the
+// FileDescriptorName= option of the socket unit ("unknown" by default), or by the parent
+// agent of a restart.
+type SystemdListener struct {
+	Name string
+	Listener net.Listener
+}
+
+// ListenSystemdSockets() returns the sockets passed with the LISTEN_FDS & LISTEN_FDNAMES
+// environment variables, which are unset so that the child processes do not inherit them.
+func ListenSystemdSockets() ([]*SystemdListener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	return listenSystemdSockets(os.Getenv, SD_LISTEN_FDS_START)
+}
+
+func listenSystemdSockets(getenv func(string) string, firstFd int) ([]*SystemdListener, error) {
+	if pid, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	listeners := make([]*SystemdListener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "unknown"
+		if i < len(names) && len(names[i]) > 0 {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(firstFd + i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Listener.Close()
+			}
+			return nil, fmt.Errorf("Socket [%s] passed by systemd is not a listener: %s", name, err)
+		}
+		listeners = append(listeners, &SystemdListener{ Name: name, Listener: listener })
+	}
+	return listeners, nil
+}
+
+// SystemdNotifier sends the service state to systemd (sd_notify), all of its methods are
+// no-op when the agent is not started by a Type=notify unit (NOTIFY_SOCKET is not set).
+type SystemdNotifier struct {
+	addr *net.UnixAddr
+	watchdog time.Duration
+	stopped chan struct{}
+	mutex sync.Mutex
+}
+
+func NewSystemdNotifier() *SystemdNotifier {
+	n := new(SystemdNotifier)
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if len(socket) == 0 {
+		return n
+	}
+	// an abstract socket name starts with '@'
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	n.addr = &net.UnixAddr{ Name: socket, Net: "unixgram" }
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err != nil || pid == os.Getpid() {
+		if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+			n.watchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return n
+}
+
+func (n *SystemdNotifier) Enabled() bool {
+	return n != nil && n.addr != nil
+}
+
+func (n *SystemdNotifier) Notify(state string) error {
+	if !n.Enabled() {
+		return nil
+	}
+	return sendNotification(n.addr, state)
+}
+
+// StartWatchdog() sends WATCHDOG=1 at the half of the WatchdogSec= interval.
+func (n *SystemdNotifier) StartWatchdog() {
+	if !n.Enabled() || n.watchdog <= 0 {
+		return
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.stopped != nil {
+		return
+	}
+	n.stopped = make(chan struct{})
+	go func(stopped chan struct{}) {
+		ticker := time.NewTicker(n.watchdog / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n.Notify(SD_NOTIFY_WATCHDOG)
+			case <-stopped:
+				return
+			}
+		}
+	}(n.stopped)
+}
+
+func (n *SystemdNotifier) StopWatchdog() {
+	if n == nil {
+		return
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.stopped != nil {
+		close(n.stopped)
+		n.stopped = nil
+	}
+}
+
+func sendNotification(addr *net.UnixAddr, state string) error {
+	conn, err := net.DialUnix(addr.Net, nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+const SD_LISTEN_FDS_START int = 3
+const SD_NOTIFY_READY string = "READY=1"
+const SD_NOTIFY_STOPPING string = "STOPPING=1"
+const SD_NOTIFY_WATCHDOG string = "WATCHDOG=1"
@@ -0,0 +1,105 @@
+// +build !windows
+
+package services
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenSystemdSockets(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer tcp.Close()
+	// the duplicated descriptor is owned (and closed) by listenSystemdSockets()
+	file, err := tcp.(*net.TCPListener).File()
+	assert.Nil(t, err)
+	fd, err := syscall.Dup(int(file.Fd()))
+	assert.Nil(t, err)
+	file.Close()
+
+	env := map[string]string{
+		"LISTEN_PID": strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS": "1",
+		"LISTEN_FDNAMES": "public",
+	}
+	getenv := func(name string) string {
+		return env[name]
+	}
+
+	t.Run("sockets passed to another process are ignored", func(t *testing.T) {
+		env["LISTEN_PID"] = strconv.Itoa(os.Getpid() + 1)
+		defer func() {
+			env["LISTEN_PID"] = strconv.Itoa(os.Getpid())
+		}()
+		listeners, err := listenSystemdSockets(getenv, fd)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(listeners))
+	})
+
+	t.Run("sockets passed to this process are listeners", func(t *testing.T) {
+		listeners, err := listenSystemdSockets(getenv, fd)
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(listeners)) {
+			assert.Equal(t, "public", listeners[0].Name)
+			assert.Equal(t, tcp.Addr().String(), listeners[0].Listener.Addr().String())
+			listeners[0].Listener.Close()
+		}
+	})
+}
+
+func TestSystemdNotifier(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "opwire-systemd")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{ Name: socket, Net: "unixgram" })
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	t.Run("notifier is disabled without NOTIFY_SOCKET", func(t *testing.T) {
+		os.Unsetenv("NOTIFY_SOCKET")
+		n := NewSystemdNotifier()
+		assert.False(t, n.Enabled())
+		assert.Nil(t, n.Notify(SD_NOTIFY_READY))
+		n.StartWatchdog()
+		n.StopWatchdog()
+	})
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	t.Run("states are sent to the notify socket", func(t *testing.T) {
+		n := NewSystemdNotifier()
+		assert.True(t, n.Enabled())
+		assert.Nil(t, n.Notify(SD_NOTIFY_READY))
+		assert.Equal(t, SD_NOTIFY_READY, readTestNotification(t, conn))
+		assert.Nil(t, n.Notify(SD_NOTIFY_STOPPING))
+		assert.Equal(t, SD_NOTIFY_STOPPING, readTestNotification(t, conn))
+	})
+
+	t.Run("watchdog is kept alive with WatchdogSec", func(t *testing.T) {
+		os.Setenv("WATCHDOG_USEC", "20000")
+		defer os.Unsetenv("WATCHDOG_USEC")
+		n := NewSystemdNotifier()
+		assert.Equal(t, 20 * time.Millisecond, n.watchdog)
+		n.StartWatchdog()
+		assert.Equal(t, SD_NOTIFY_WATCHDOG, readTestNotification(t, conn))
+		assert.Equal(t, SD_NOTIFY_WATCHDOG, readTestNotification(t, conn))
+		n.StopWatchdog()
+	})
+}
+
+func readTestNotification(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	size, err := conn.Read(buf)
+	assert.Nil(t, err)
+	return string(buf[:size])
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	loq "github.com/opwire/opwire-agent/lib/logging"
+)
+
+// SpanContext identifies a span across the processes, it is propagated with the W3C trace
+// context (the traceparent header and the TRACEPARENT environment variable).
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID [8]byte
+	Sampled bool
+}
+
+// ParseTraceparent() parses a traceparent value ("00-<trace-id>-<span-id>-<flags>"), the
+// values of the future versions are accepted as long as they begin with the same fields.
+func ParseTraceparent(value string) (*SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return nil, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return nil, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil, false
+	}
+	for _, part := range parts[:4] {
+		if part != strings.ToLower(part) {
+			return nil, false
+		}
+	}
+	sc := &SpanContext{}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return nil, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return nil, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return nil, false
+	}
+	if sc.TraceID == ([16]byte{}) || sc.SpanID == ([8]byte{}) {
+		return nil, false
+	}
+	sc.Sampled = flags[0] & 0x01 == 0x01
+	return sc, true
+}
+
+func (sc *SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+type spanContextKey struct{}
+
+// ContextWithTraceparent() makes the span of a traceparent value (e.g. an incoming header)
+// the parent of the spans started with the returned context.
+func ContextWithTraceparent(ctx context.Context, value string) context.Context {
+	if sc, ok := ParseTraceparent(value); ok {
+		return context.WithValue(ctx, spanContextKey{}, sc)
+	}
+	return ctx
+}
+
+// SpanContextFrom() returns the context of the current span, nil if there is none.
+func SpanContextFrom(ctx context.Context) *SpanContext {
+	if ctx == nil {
+		return nil
+	}
+	sc, _ := ctx.Value(spanContextKey{}).(*SpanContext)
+	return sc
+}
+
+type SpanAttribute struct {
+	Key string
+	Value interface{}
+}
+
+// Span is an operation of a trace, all of its methods are no-op on a nil receiver.
+type Span struct {
+	tracer *Tracer
+	name string
+	kind int
+	context SpanContext
+	parentID [8]byte
+	startTime time.Time
+	endTime time.Time
+	attributes []SpanAttribute
+	failed bool
+	statusMessage string
+	ended bool
+	mutex sync.Mutex
+}
+
+func (s *Span) Context() *SpanContext {
+	if s == nil {
+		return nil
+	}
+	sc := s.context
+	return &sc
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.attributes {
+		if s.attributes[i].Key == key {
+			s.attributes[i].Value = value
+			return
+		}
+	}
+	s.attributes = append(s.attributes, SpanAttribute{ Key: key, Value: value })
+}
+
+// SetError() marks the span as failed, a nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failed = true
+	s.statusMessage = err.Error()
+}
+
+// End() records the end of the span and queues it to the exporters if it is sampled, the
+// next calls are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.endTime = time.Now()
+	s.mutex.Unlock()
+	if s.context.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+func (s *Span) hasParent() bool {
+	return s.parentID != [8]byte{}
+}
+
+// SpanExporter sends the ended spans to a backend, the batches are exported sequentially.
+type SpanExporter interface {
+	ExportSpans(serviceName string, spans []*Span) error
+	Close() error
+}
+
+type TracerOptions interface {
+	GetServiceName() string
+	GetFlushInterval() (time.Duration, error)
+}
+
+// Tracer starts the spans and exports them in batches (every flush-interval, or as soon as a
+// batch is full). The spans are dropped when the queue is full. All of its methods are
+// no-op on a nil receiver.
+type Tracer struct {
+	serviceName string
+	exporters []SpanExporter
+	interval time.Duration
+	queue chan *Span
+	closing chan struct{}
+	closed chan struct{}
+	closeOnce sync.Once
+	dropped int64
+	logger *loq.Logger
+}
+
+func NewTracer(logger *loq.Logger, opts TracerOptions, exporters ...SpanExporter) (*Tracer, error) {
+	if len(exporters) == 0 {
+		return nil, fmt.Errorf("Tracer must declare at least one exporter")
+	}
+	t := new(Tracer)
+	t.serviceName = DEFAULT_TRACING_SERVICE_NAME
+	t.interval = DEFAULT_TRACING_FLUSH_INTERVAL
+	if opts != nil {
+		if name := opts.GetServiceName(); len(name) > 0 {
+			t.serviceName = name
+		}
+		interval, err := opts.GetFlushInterval()
+		if err != nil {
+			return nil, err
+		}
+		if interval > 0 {
+			t.interval = interval
+		}
+	}
+	t.exporters = exporters
+	t.logger = logger
+	if t.logger == nil {
+		t.logger, _ = loq.NewLogger(nil)
+	}
+	t.queue = make(chan *Span, TRACING_QUEUE_SIZE)
+	t.closing = make(chan struct{})
+	t.closed = make(chan struct{})
+	go t.run()
+	return t, nil
+}
+
+// StartSpan() starts a span, which is a child of the current span of ctx (or the root of a
+// new trace), and returns the context carrying it.
+func (t *Tracer) StartSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s := &Span{
+		tracer: t,
+		name: name,
+		kind: kind,
+		startTime: time.Now(),
+	}
+	if parent := SpanContextFrom(ctx); parent != nil {
+		s.context.TraceID = parent.TraceID
+		s.context.Sampled = parent.Sampled
+		s.parentID = parent.SpanID
+	} else {
+		rand.Read(s.context.TraceID[:])
+		s.context.Sampled = true
+	}
+	rand.Read(s.context.SpanID[:])
+	return context.WithValue(ctx, spanContextKey{}, s.Context()), s
+}
+
+// TraceProcess() starts the span of a process of a pipe chain and exports its context to the
+// process with the TRACEPARENT environment variable, so that an instrumented command
+// continues the trace. The returned function ends the span with the exit code.
+func (t *Tracer) TraceProcess(ctx context.Context, index int, cmd *exec.Cmd) func(err error) {
+	if t == nil {
+		return func(err error) {}
+	}
+	_, span := t.StartSpan(ctx, "process " + filepath.Base(cmd.Path), SPAN_KIND_INTERNAL)
+	span.SetAttribute("process.executable.name", filepath.Base(cmd.Path))
+	span.SetAttribute("opwire.pipe.index", index)
+	envs := cmd.Env
+	if envs == nil {
+		envs = os.Environ()
+	}
+	cmd.Env = append(filterEnvs(envs, TRACEPARENT_ENV_NAME), TRACEPARENT_ENV_NAME + "=" + span.Context().Traceparent())
+	return func(err error) {
+		if cmd.Process != nil {
+			span.SetAttribute("process.pid", cmd.Process.Pid)
+		}
+		if cmd.ProcessState != nil {
+			span.SetAttribute("process.exit_code", cmd.ProcessState.ExitCode())
+		}
+		span.SetError(err)
+		span.End()
+	}
+}
+
+// Shutdown() exports the queued spans and closes the exporters, it returns when they are
+// closed or when ctx is done.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.closeOnce.Do(func() {
+		close(t.closing)
+	})
+	select {
+	case <-t.closed:
+		if dropped := atomic.LoadInt64(&t.dropped); dropped > 0 {
+			t.logger.Log(loq.WarnLevel, "Spans have been dropped, the queue was full", loq.Int64("dropped", dropped))
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		atomic.AddInt64(&t.dropped, 1)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.closed)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, TRACING_BATCH_SIZE)
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= TRACING_BATCH_SIZE {
+				batch = t.export(batch)
+			}
+		case <-ticker.C:
+			batch = t.export(batch)
+		case <-t.closing:
+		drain:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					break drain
+				}
+			}
+			t.export(batch)
+			for _, exporter := range t.exporters {
+				if err := exporter.Close(); err != nil {
+					t.logger.Log(loq.WarnLevel, "Span exporter could not be closed", loq.Error(err))
+				}
+			}
+			return
+		}
+	}
+}
+
+func (t *Tracer) export(batch []*Span) []*Span {
+	if len(batch) == 0 {
+		return batch
+	}
+	for _, exporter := range t.exporters {
+		if err := exporter.ExportSpans(t.serviceName, batch); err != nil {
+			t.logger.Log(loq.WarnLevel, "Spans could not be exported", loq.Int("spans", len(batch)), loq.Error(err))
+		}
+	}
+	return make([]*Span, 0, TRACING_BATCH_SIZE)
+}
+
+const SPAN_KIND_INTERNAL int = 1
+const SPAN_KIND_SERVER int = 2
+const TRACEPARENT_HEADER_NAME string = "traceparent"
+const TRACEPARENT_ENV_NAME string = "TRACEPARENT"
+const DEFAULT_TRACING_SERVICE_NAME string = "opwire-agent"
+const DEFAULT_TRACING_FLUSH_INTERVAL time.Duration = 5 * time.Second
+const DEFAULT_TRACING_SHUTDOWN_TIMEOUT time.Duration = 5 * time.Second
+const TRACING_QUEUE_SIZE int = 2048
+const TRACING_BATCH_SIZE int = 512
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"github.com/opwire/opwire-agent/lib/invokers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(sc.TraceID[:]))
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(sc.SpanID[:]))
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	sc, ok = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	assert.True(t, ok)
+	assert.False(t, sc.Sampled)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	} {
+		_, ok := ParseTraceparent(value)
+		assert.False(t, ok, value)
+	}
+}
+
+func TestTracer_StartSpan(t *testing.T) {
+	exporter := &SpanExporterTest{}
+	tracer, err := NewTracer(nil, nil, exporter)
+	assert.Nil(t, err)
+
+	ctx := ContextWithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, parent := tracer.StartSpan(ctx, "HTTP GET", SPAN_KIND_SERVER)
+	_, child := tracer.StartSpan(ctx, "semaphore wait", SPAN_KIND_INTERNAL)
+	child.SetError(fmt.Errorf("the waiting time is over"))
+	child.End()
+	parent.SetAttribute("http.status_code", 503)
+	parent.End()
+	parent.End()
+
+	// an unsampled trace is propagated but not exported
+	unsampled := ContextWithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, ignored := tracer.StartSpan(unsampled, "HTTP GET", SPAN_KIND_SERVER)
+	assert.False(t, ignored.Context().Sampled)
+	ignored.End()
+
+	assert.Nil(t, tracer.Shutdown(context.Background()))
+	assert.True(t, exporter.closed)
+	if assert.Equal(t, 2, len(exporter.spans)) {
+		assert.Equal(t, child, exporter.spans[0])
+		assert.Equal(t, parent.context.TraceID, child.context.TraceID)
+		assert.Equal(t, parent.context.SpanID, child.parentID)
+		assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(parent.parentID[:]))
+		assert.True(t, child.failed)
+		assert.Equal(t, []SpanAttribute{ SpanAttribute{ Key: "http.status_code", Value: 503 } }, parent.attributes)
+	}
+
+	// a nil Tracer is a no-op
+	var disabled *Tracer
+	same, span := disabled.StartSpan(ctx, "HTTP GET", SPAN_KIND_SERVER)
+	assert.Equal(t, ctx, same)
+	assert.Nil(t, span)
+	span.SetAttribute("http.status_code", 200)
+	span.End()
+	assert.Nil(t, disabled.Shutdown(context.Background()))
+}
+
+func TestTracer_TraceProcess(t *testing.T) {
+	exporter := &SpanExporterTest{}
+	tracer, _ := NewTracer(nil, nil, exporter)
+	e, _ := invokers.NewExecutor(&invokers.ExecutorOptions{ Tracer: tracer })
+	e.Register(&invokers.CommandDescriptor{ CommandString: "sh -c 'echo $TRACEPARENT' | sh -c 'cat; exit 3'" }, "trace")
+
+	ctx := ContextWithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ci := &invokers.CommandInvocation{ Context: ctx, ResourceName: "trace", Envs: []string{ "TRACEPARENT=stale" } }
+	var stdout bytes.Buffer
+	_, err := e.Run(nil, ci, &stdout, nil)
+	_, exited := err.(*exec.ExitError)
+	assert.True(t, exited)
+	assert.Nil(t, tracer.Shutdown(context.Background()))
+
+	if assert.Equal(t, 2, len(exporter.spans)) {
+		first, second := exporter.spans[0], exporter.spans[1]
+		assert.Equal(t, first.Context().Traceparent(), strings.TrimSpace(stdout.String()))
+		for i, span := range exporter.spans {
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(span.context.TraceID[:]))
+			assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(span.parentID[:]))
+			assert.Equal(t, "process sh", span.name)
+			assert.Contains(t, span.attributes, SpanAttribute{ Key: "opwire.pipe.index", Value: i })
+		}
+		assert.Contains(t, first.attributes, SpanAttribute{ Key: "process.exit_code", Value: 0 })
+		assert.False(t, first.failed)
+		assert.Contains(t, second.attributes, SpanAttribute{ Key: "process.exit_code", Value: 3 })
+		assert.True(t, second.failed)
+	}
+}
+
+type SpanExporterTest struct {
+	spans []*Span
+	closed bool
+	mutex sync.Mutex
+}
+
+func (e *SpanExporterTest) ExportSpans(serviceName string, spans []*Span) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *SpanExporterTest) Close() error {
+	e.closed = true
+	return nil
+}
+
+type TracerOptionsTest struct {
+	ServiceName string
+	FlushInterval time.Duration
+}
+
+func (o *TracerOptionsTest) GetServiceName() string {
+	return o.ServiceName
+}
+
+func (o *TracerOptionsTest) GetFlushInterval() (time.Duration, error) {
+	return o.FlushInterval, nil
+}
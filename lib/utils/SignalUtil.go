@@ -10,3 +10,11 @@ import (
 func ShutdownSignals() []os.Signal {
 	return []os.Signal{ syscall.SIGTERM, syscall.SIGTSTP }
 }
+
+func ReloadSignals() []os.Signal {
+	return []os.Signal{ syscall.SIGHUP }
+}
+
+func RestartSignals() []os.Signal {
+	return []os.Signal{ syscall.SIGUSR2 }
+}
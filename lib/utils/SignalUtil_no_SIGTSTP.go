@@ -10,3 +10,11 @@ import (
 func ShutdownSignals() []os.Signal {
 	return []os.Signal{ syscall.SIGTERM }
 }
+
+func ReloadSignals() []os.Signal {
+	return []os.Signal{}
+}
+
+func RestartSignals() []os.Signal {
+	return []os.Signal{}
+}